@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+// the file in the output directory storing the hashes of the rendered images, see lib.BatchRender.Hash
+const batchStateFileName = ".fractgen-batch.json"
+
+const (
+	BATCH_STATUS_RENDERED = "rendered"
+	BATCH_STATUS_SKIPPED  = "skipped"
+	BATCH_STATUS_FAILED   = "failed"
+)
+
+type BatchCmd struct {
+	Workers     int      `help:"Number of images to render at the same time." default:"4"`
+	Force       bool     `help:"Render all images, also the up-to-date ones."`
+	Report      string   `help:"Path to write the summary report (JSON) to. Defaults to batch-report.json in the output directory." type:"path"`
+	PresetsFile []string `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets, then the presets files of the manifest." type:"path" sep:"none"`
+
+	Gallery     string `help:"Render every fractal preset as thumbnail into the given directory, with an index.html, instead of the jobs of a manifest." type:"path"`
+	ThumbWidth  int    `help:"Width of the --gallery thumbnails, in pixels." default:"320"`
+	ThumbHeight int    `help:"Height of the --gallery thumbnails, in pixels." default:"200"`
+	ThumbFormat string `help:"Format of the --gallery thumbnails." default:"jpeg"`
+
+	Manifest string `arg:"" optional:"" help:"Path to the batch manifest (JSON, YAML or TOML) with the render jobs." type:"existingfile"`
+}
+
+// The summary report of a batch run.
+type BatchReport struct {
+	Started  time.Time           `json:"started"`
+	Duration float64             `json:"durationSeconds"`
+	Rendered int                 `json:"rendered"`
+	Skipped  int                 `json:"skipped"`
+	Failed   int                 `json:"failed"`
+	Outputs  []BatchReportOutput `json:"outputs"`
+}
+
+type BatchReportOutput struct {
+	Job    string `json:"job"`
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	// BATCH_STATUS_*
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+}
+
+func (c *BatchCmd) Run(appContext *lib.AppContext) error {
+	var manifest lib.BatchManifest
+	switch {
+	case c.Gallery != "" && c.Manifest != "":
+		return errors.New("either a manifest or --gallery is allowed")
+	case c.Manifest != "":
+		var err error
+		manifest, err = lib.ReadBatchManifest(c.Manifest)
+		if err != nil {
+			return err
+		}
+	case c.Gallery == "":
+		return errors.New("either a manifest or --gallery is required")
+	}
+
+	presets, err := lib.ReadPresetFiles(slices.Concat(c.PresetsFile, manifest.PresetsFiles), appContext.EmbeddedPresets)
+	if err != nil {
+		return err
+	}
+	if c.Gallery != "" {
+		manifest = lib.PresetsGalleryManifest(presets, c.Gallery, c.ThumbWidth, c.ThumbHeight, c.ThumbFormat)
+	}
+	renders, err := manifest.Renders(presets)
+	if err != nil {
+		return err
+	}
+	if c.Report == "" {
+		c.Report = filepath.Join(manifest.OutputDir, "batch-report.json")
+	}
+
+	report := c.runRenders(manifest.OutputDir, renders)
+	fmt.Printf("%d images: %d rendered, %d up to date, %d failed (%.1fs)\n",
+		len(renders), report.Rendered, report.Skipped, report.Failed, report.Duration)
+
+	if c.Gallery != "" {
+		if err := writeGalleryIndex(c.Gallery, renders); err != nil {
+			return err
+		}
+		fmt.Printf("Gallery saved to %s\n", filepath.Join(c.Gallery, "index.html"))
+	}
+	if err := writeJsonFile(c.Report, report); err != nil {
+		return err
+	}
+	fmt.Printf("Report saved to %s\n", c.Report)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d images failed", report.Failed)
+	}
+	return nil
+}
+
+/*
+Renders the images with the workers, skipping the images which are up to date: the image file exists, and the hash
+of its render params and output options is unchanged since it was rendered. The hashes are stored in the output
+directory.
+*/
+func (c *BatchCmd) runRenders(outputDir string, renders []lib.BatchRender) BatchReport {
+	report := BatchReport{Started: time.Now(), Outputs: make([]BatchReportOutput, len(renders))}
+	statePath := filepath.Join(outputDir, batchStateFileName)
+	state := make(map[string]string)
+	if data, err := os.ReadFile(statePath); err == nil {
+		// a broken state file renders all images again:
+		_ = json.Unmarshal(data, &state)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	done := 0
+	for range max(c.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				render := renders[i]
+				output := BatchReportOutput{
+					Job: render.Job, Path: render.Path, Width: render.Width, Height: render.Height, Format: render.Format.Name,
+				}
+				hash := render.Hash()
+				mu.Lock()
+				upToDate := !c.Force && state[render.Path] == hash
+				mu.Unlock()
+				info, err := os.Stat(render.Path)
+				if upToDate && err == nil {
+					output.Status, output.Size = BATCH_STATUS_SKIPPED, info.Size()
+				} else {
+					start := time.Now()
+					err = render.RenderFile()
+					output.Duration = time.Since(start).Seconds()
+					if err == nil {
+						info, err = os.Stat(render.Path)
+					}
+					if err != nil {
+						output.Status, output.Error = BATCH_STATUS_FAILED, err.Error()
+					} else {
+						output.Status, output.Size = BATCH_STATUS_RENDERED, info.Size()
+					}
+				}
+
+				mu.Lock()
+				report.Outputs[i] = output
+				done++
+				switch output.Status {
+				case BATCH_STATUS_RENDERED:
+					report.Rendered++
+					// saved after each image, so an interrupted batch continues where it stopped:
+					state[render.Path] = hash
+					if err := writeJsonFile(statePath, state); err != nil {
+						fmt.Printf("Saving %s failed: %v\n", statePath, err)
+					}
+					fmt.Printf("[%d/%d] %s: rendered %s (%dx%d, %.1fs)\n", done, len(renders), render.Job, render.Path, render.Width, render.Height, output.Duration)
+				case BATCH_STATUS_SKIPPED:
+					report.Skipped++
+					fmt.Printf("[%d/%d] %s: %s is up to date\n", done, len(renders), render.Job, render.Path)
+				default:
+					report.Failed++
+					fmt.Printf("[%d/%d] %s: %s failed: %s\n", done, len(renders), render.Job, render.Path, output.Error)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range renders {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	report.Duration = time.Since(report.Started).Seconds()
+	return report
+}
+
+// writes the value as indented JSON, creating the file's directory
+func writeJsonFile(filePath string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, append(data, '\n'), 0o644)
+}
+
+var galleryIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Fractal presets</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #eee; margin: 2em; }
+main { display: grid; grid-template-columns: repeat(auto-fill, minmax({{.Width}}px, 1fr)); gap: 1.5em; }
+figure { margin: 0; }
+img { width: 100%; height: auto; display: block; }
+figcaption { margin-top: 0.4em; }
+small { color: #aaa; }
+</style>
+</head>
+<body>
+<h1>Fractal presets</h1>
+<main>
+{{range .Items}}<figure>
+<a href="{{.File}}"><img src="{{.File}}" width="{{.Width}}" height="{{.Height}}" alt="{{.Name}}" loading="lazy"></a>
+<figcaption>{{.Name}}<br><small>{{.IterFunc}}, {{.ColorPreset}}, {{.MaxIterations}} iterations</small></figcaption>
+</figure>
+{{end}}</main>
+</body>
+</html>
+`))
+
+// writes the index.html of the gallery: the thumbnails of the fractal presets, with their names
+func writeGalleryIndex(dir string, renders []lib.BatchRender) error {
+	type item struct {
+		lib.FractalPreset
+		File          string
+		Width, Height int
+	}
+	data := struct {
+		Width int
+		Items []item
+	}{}
+	for _, render := range renders {
+		if render.Params.Fractal == nil {
+			continue
+		}
+		data.Width = render.Width
+		data.Items = append(data.Items, item{*render.Params.Fractal, filepath.Base(render.Path), render.Width, render.Height})
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return galleryIndexTemplate.Execute(file, data)
+}
@@ -1,34 +1,62 @@
 package cli
 
 import (
-	"errors"
 	"fmt"
+	"image/color"
 	"log"
-	"math"
-	"math/big"
 	"os"
-	"path"
-	"strings"
+	"path/filepath"
+	"time"
 
 	"github.com/bylexus/go-fract/lib"
 	"github.com/bylexus/go-fract/web"
 )
 
 type ServeCmd struct {
-	Listen      string  `help:"Listen address / port to serve on." default:":8000"`
-	PresetsFile string  `help:"Path to presets file." type:"path"`
-	Webroot     *string `help:"Path to static webroot directory. If not set, the embedded files will be used." type:"path"`
+	Listen                string        `help:"Listen address / port to serve on." default:":8000"`
+	PresetsFile           []string      `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets. Presets changed by the preset API are saved to the last one, which must be a file." type:"path" sep:"none"`
+	PresetsReloadInterval time.Duration `help:"Interval to check the presets files for changes: the presets are reloaded when they change. 0 disables the reload." default:"2s"`
+	Webroot               *string       `help:"Path to static webroot directory. If not set, the embedded files will be used." type:"path"`
+
+	MaxPixels     int `help:"Max. number of pixels (width * height) of a requested image." default:"33177600"`
+	MaxIterations int `help:"Max. number of iterations of a requested image." default:"100000"`
+	MaxFrames     int `help:"Max. number of frames of a flight job." default:"3000"`
+	MaxQueuedJobs int `help:"Max. number of queued render jobs: more jobs are rejected (429 Too Many Requests) until the queued jobs are started. 0 = unlimited." default:"100"`
+
+	JobsDir      string        `help:"Directory to store the render jobs and their results in. Defaults to 'fractgen/jobs' in the user's cache directory." type:"path"`
+	Workers      int           `help:"Number of render jobs to run at the same time." default:"1"`
+	JobRetention time.Duration `help:"How long finished render jobs and their results are kept. 0 keeps them until they are deleted." default:"24h"`
 }
 
 func (c *ServeCmd) Run(appContext *lib.AppContext) error {
-	presets, err := lib.ReadPresetJson(c.PresetsFile, appContext.EmbeddedPresets)
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
 	if err != nil {
 		return err
 	}
 	if c.Webroot != nil {
 		appContext.WebrootFS = os.DirFS(*c.Webroot)
 	}
-	server := web.NewWebServer(web.WebServerConfig{Addr: c.Listen, WebrootFS: appContext.WebrootFS}, presets.ColorPresets, presets.FractalPresets)
+	if c.JobsDir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		c.JobsDir = filepath.Join(cacheDir, "fractgen", "jobs")
+	}
+	server, err := web.NewWebServer(web.WebServerConfig{
+		Addr:                  c.Listen,
+		WebrootFS:             appContext.WebrootFS,
+		Limits:                web.Limits{MaxPixels: c.MaxPixels, MaxIterations: c.MaxIterations, MaxFrames: c.MaxFrames, MaxQueuedJobs: c.MaxQueuedJobs},
+		JobsDir:               c.JobsDir,
+		Workers:               c.Workers,
+		JobRetention:          c.JobRetention,
+		PresetsFiles:          c.PresetsFile,
+		EmbeddedPresets:       appContext.EmbeddedPresets,
+		PresetsReloadInterval: c.PresetsReloadInterval,
+	}, presets)
+	if err != nil {
+		return err
+	}
 
 	fmt.Printf("Starting Webserver, listen on %s\n", server.Addr)
 	log.Fatal(server.ListenAndServe())
@@ -37,10 +65,14 @@ func (c *ServeCmd) Run(appContext *lib.AppContext) error {
 }
 
 type ImageCmd struct {
-	Format           string          `help:"Format of the image to generate."`
-	Width            int             `help:"Width of the image to generate, in pixels." default:"1920"`
-	Height           int             `help:"Height of the image to generate, in pixels." default:"1200"`
+	Format           string          `help:"Format of the image to generate: png, png16 (16 bit png), jpeg, webp, tiff, tiff16 (16 bit tiff), bmp, gif, ppm, pfm (linear floating point). Detected by the file extension if not set."`
+	Dither           string          `help:"Dithering when reducing the 16 bit colors to 8 bit (all formats except png16 / tiff16 / pfm). Gif images are dithered with Floyd-Steinberg error diffusion instead." enum:"none,ordered,blue-noise" default:"none"`
+	Width            int             `help:"Width of the image to generate, in pixels. Defaults to 1920, or the width of the --from image / --spec."`
+	Height           int             `help:"Height of the image to generate, in pixels. Defaults to 1200, or the height of the --from image / --spec (keeping its aspect ratio if only --width is given)."`
+	From             string          `help:"Re-render the image from the render params embedded in the given png / jpeg image, ignoring other fractal parameters." type:"existingfile"`
+	Spec             string          `help:"Render the image from a render spec (see the spec command), ignoring other fractal parameters."`
 	FractalPreset    string          `help:"Name of the fractal preset to use, e.g. '--fractal-preset=\"Mandelbrot Total\"'. Use in combination with --presets-file." default:"" `
+	Composition      string          `help:"Name of the composition preset to render, layering several fractal presets. Use in combination with --presets-file." default:""`
 	ColorPreset      string          `help:"Name of the color preset to use." default:"patchwork"`
 	Function         lib.FractalType `help:"Fractal function to use." enum:"mandelbrot,julia,mandelbrot3,mandelbrot4" default:"mandelbrot"`
 	PaletteRepeat    int             `help:"Number of times to repeat the palette." default:"1"`
@@ -53,13 +85,16 @@ type ImageCmd struct {
 	JuliaKr          float64         `help:"Julia Kr(r)" default:"-0.2"`
 	JuliaKi          float64         `help:"Julia Ki(i)" default:"0.8"`
 	MaxIter          int             `help:"Maximum number of iterations." default:"100"`
-	PresetsFile      string          `help:"Path to presets file." type:"path"`
+	PresetsFile      []string        `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets." type:"path" sep:"none"`
+
+	ColoringFlags `embed:""`
+	EncodeFlags   `embed:""`
 
 	OutputPath string `arg:"" help:"Path to save the image to." type:"path" default:"image.jpg"`
 }
 
 func (c *ImageCmd) Run(appContext *lib.AppContext) error {
-	presets, err := lib.ReadPresetJson(c.PresetsFile, appContext.EmbeddedPresets)
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
 	if err != nil {
 		return err
 	}
@@ -71,30 +106,62 @@ func (c *ImageCmd) Run(appContext *lib.AppContext) error {
 
 	var fractal lib.Fractal
 
-	if c.Format == "" {
-		ext := strings.ToLower(path.Ext(c.OutputPath))
-		switch ext {
-		case ".png":
-			c.Format = "png"
-		case ".jpg":
-			fallthrough
-		case ".jpeg":
-			c.Format = "jpeg"
-		default:
-			return errors.New("unknown image format")
+	if c.From == "" && c.Spec == "" {
+		if c.Width <= 0 {
+			c.Width = 1920
+		}
+		if c.Height <= 0 {
+			c.Height = 1200
 		}
 	}
 
-	if c.FractalPreset != "" {
-		fractalPreset, err := presets.FractalPresets.GetByName(c.FractalPreset)
+	var format lib.ImageFormat
+	if c.Format == "" {
+		format, err = lib.ImageFormatFromFilename(c.OutputPath)
+	} else {
+		format, err = lib.GetImageFormat(c.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	var img *lib.FractImage
+	if c.From != "" {
+		renderParams, err := lib.ReadRenderParamsFile(c.From)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Using the render params of '%s', ignoring other fractal parameters.\n", c.From)
+		img, err = renderParams.Render(c.Width, c.Height)
+		if err != nil {
+			return err
+		}
+	} else if c.Spec != "" {
+		renderParams, err := lib.DecodeRenderSpec(c.Spec, presets.ColorPresets)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Using the render spec, ignoring other fractal parameters.")
+		img, err = renderParams.Render(c.Width, c.Height)
+		if err != nil {
+			return err
+		}
+	} else if c.Composition != "" {
+		composition, err := presets.CompositionPresets.GetByName(c.Composition)
 		if err != nil {
 			return err
 		}
-		colorPreset, err = presets.ColorPresets.GetByIdent(fractalPreset.ColorPreset)
+		fmt.Printf("Using composition preset: '%s', ignoring other fractal parameters.\n", c.Composition)
+		img, err = lib.CalcCompositionImage(c.Width, c.Height, presets, composition)
 		if err != nil {
 			return err
 		}
-		fractal, err = lib.NewFractalFromPresets(c.Width, c.Height, colorPreset, fractalPreset)
+	} else if c.FractalPreset != "" {
+		fractalPreset, err := presets.FractalPresets.GetByName(c.FractalPreset)
+		if err != nil {
+			return err
+		}
+		fractal, err = lib.NewFractalFromPresets(c.Width, c.Height, presets.ColorPresets, fractalPreset)
 		if err != nil {
 			return err
 		}
@@ -112,6 +179,12 @@ func (c *ImageCmd) Run(appContext *lib.AppContext) error {
 			ColorPaletteLength:    c.PaletteLength,
 			ColorPaletteReverse:   c.PaletteReverse,
 			ColorPaletteHardStops: c.PaletteHardStops,
+			ColorPaletteSpace:     colorPreset.Interpolation,
+			ColorPaletteCurve:     colorPreset.Curve,
+		}
+		err = c.ColoringFlags.applyTo(&commonFractParams, presets.ColorPresets)
+		if err != nil {
+			return err
 		}
 		fractal, err = lib.NewFractalFromParams(c.Function, commonFractParams, c.JuliaKr, c.JuliaKi)
 		if err != nil {
@@ -119,27 +192,32 @@ func (c *ImageCmd) Run(appContext *lib.AppContext) error {
 		}
 	}
 
-	img := lib.CalcFractalImage(fractal)
+	if img == nil {
+		img = lib.CalcFractalImage(fractal)
+	}
 	file, err := os.Create(c.OutputPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	switch c.Format {
-	case "png":
-		err = img.EncodePng(file)
-	case "jpeg":
-		err = img.EncodeJpeg(file)
-	default:
-		return errors.New("unknown image format")
+	img.Dither = lib.DitherMode(c.Dither)
+	err = format.Encode(file, img, c.EncodeFlags.options())
+	if err != nil {
+		return err
 	}
 	fmt.Printf("Image saved to %s\n", c.OutputPath)
-	return err
+	if img.RenderParams != nil && img.RenderParams.Fractal != nil {
+		if spec, err := lib.EncodeRenderSpec(*img.RenderParams, presets.ColorPresets); err == nil {
+			fmt.Printf("Render spec: %s\n", spec)
+		}
+	}
+	return nil
 }
 
 type FlightCmd struct {
-	Format          string          `help:"Format of the image to generate." enum:"png,jpeg,jpg" default:"jpeg"`
+	Format          string          `help:"Format of the image to generate." enum:"png,png16,jpeg,jpg,webp,tiff,tiff16,bmp,gif,ppm,pfm" default:"jpeg"`
+	Dither          string          `help:"Dithering when reducing the 16 bit colors to 8 bit (all formats except png16 / tiff16 / pfm). Gif images are dithered with Floyd-Steinberg error diffusion instead." enum:"none,ordered,blue-noise" default:"none"`
 	Width           int             `help:"Width of the image to generate, in pixels." default:"720"`
 	Height          int             `help:"Height of the image to generate, in pixels." default:"450"`
 	ColorPreset     string          `help:"Name of the color preset to use." default:"patchwork"`
@@ -157,16 +235,23 @@ type FlightCmd struct {
 	Duration int `help:"Duration of the flight, in Seconds." default:"10"`
 	Fps      int `help:"Frames per second." default:"25"`
 
-	JuliaKr     float64 `help:"Julia Kr(r)" default:"-0.2"`
-	JuliaKi     float64 `help:"Julia Ki(i)" default:"0.8"`
-	MaxIter     int     `help:"Maximum number of iterations." default:"800"`
-	PresetsFile string  `help:"Path to presets file." type:"path"`
+	JuliaKr     float64  `help:"Julia Kr(r)" default:"-0.2"`
+	JuliaKi     float64  `help:"Julia Ki(i)" default:"0.8"`
+	MaxIter     int      `help:"Maximum number of iterations." default:"800"`
+	PresetsFile []string `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets." type:"path" sep:"none"`
+
+	JuliaPath        string `help:"Morph a Julia set instead of flying: the Julia constant travels along the path, while the view stays at the start view (e.g. --start-center-cx=0 --start-diameter-cx=3.5). One of line:kr1,ki1,kr2,ki2, circle:kr,ki,radius, bezier:kr1,ki1,kr2,ki2,kr3,ki3[,kr4,ki4] or cardioid[:scale] (the boundary of the Mandelbrot set's main cardioid)."`
+	JuliaPathPreview string `help:"Path to save a Mandelbrot image with the --julia-path drawn on it to." type:"path"`
+	PathColor        string `help:"Color of the path in the --julia-path-preview, as CSS color." default:"#ffffff"`
+
+	ColoringFlags `embed:""`
+	EncodeFlags   `embed:""`
 
 	OutputFolder string `arg:"" help:"Folder to save the image to." type:"path" required:"true"`
 }
 
 func (c *FlightCmd) Run(appContext *lib.AppContext) error {
-	presets, err := lib.ReadPresetJson(c.PresetsFile, appContext.EmbeddedPresets)
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
 	if err != nil {
 		return err
 	}
@@ -176,6 +261,11 @@ func (c *FlightCmd) Run(appContext *lib.AppContext) error {
 		return err
 	}
 
+	format, err := lib.GetImageFormat(c.Format)
+	if err != nil {
+		return err
+	}
+
 	var fractal lib.Fractal
 
 	var commonFractParams = lib.CommonFractParams{
@@ -189,98 +279,38 @@ func (c *FlightCmd) Run(appContext *lib.AppContext) error {
 		ColorPaletteRepeat:  c.PaletteRepeat,
 		ColorPaletteLength:  c.PaletteLength,
 		ColorPaletteReverse: c.PaletteReverse,
+		ColorPaletteSpace:   colorPreset.Interpolation,
+		ColorPaletteCurve:   colorPreset.Curve,
+	}
+	err = c.ColoringFlags.applyTo(&commonFractParams, presets.ColorPresets)
+	if err != nil {
+		return err
 	}
 
 	nrOfImages := c.Duration * c.Fps
+	if c.JuliaPath != "" {
+		return c.runJuliaMorph(format, commonFractParams, nrOfImages)
+	}
 
 	err = os.MkdirAll(c.OutputFolder, os.ModePerm)
 	if err != nil {
 		return err
 	}
-	startCenterCX := big.NewFloat(c.StartCenterCX)
-	endCenterCX := big.NewFloat(c.EndCenterCX)
-	deltaX := new(big.Float).Sub(endCenterCX, startCenterCX)
-
-	startCenterCY := big.NewFloat(c.StartCenterCY)
-	endCenterCY := big.NewFloat(c.EndCenterCY)
-	deltaY := new(big.Float).Sub(endCenterCY, startCenterCY)
-
-	startDiameterCX := big.NewFloat(c.StartDiameterCX)
-	endDiameterCX := big.NewFloat(c.EndDiameterCX)
-	deltaDiameter := new(big.Float).Sub(endDiameterCX, startDiameterCX)
-
-	/*
-		We cannot simply increase the diameter by the same amount every step: as we dive deeper into
-		the fractal, we are "nearer" to the end point: This means that the same amount of diameter increase
-		looks like we will get faster and faster.
-
-		To counteract this, we need to increase the diameter by an amount that scales with the current
-		distance from the end point. Or, we increase/decrease the diameter by a percentage amount of the actual value.
-
-		This seems to be a similar problem to the "Zineszins" problem:
-
-		Kn = K0 * (1 + p)^n
-
-		where:
-		- K0 is the initial value
-		- p is the percentage increase/decrease, from 0 to 1
-		- n is the number of steps
-		- Kn is the final value
-
-		We have:
-		- the initial value K0: c.StartDiameterCX
-		- the final value Kn: c.EndDiameterCX
-		- the number of steps (n, nrOfImages)
-		- We want: The value of p:
-			p = (nth root of (Kn / K0)) + 1
-
-			Example:
-
-			nrOfImages = 80
-			K0 = 4
-			Kn = 0.01
-
-			p = (Kn/K(0))^(1/80) - 1
-			p = (0.01/4)^(1/80) - 1
-			p = 1.0000000000000002
-	*/
-
-	// diameter percentage to increase per image:
-	// p = (nth root of (endDiameter / startDiameter)) + 1
-	var percIncrease float64 = math.Pow(float64(c.EndDiameterCX)/float64(c.StartDiameterCX), 1.0/float64(nrOfImages)) - 1.0
-
-	// i := 0
-	inc := big.NewFloat(1.0)
-	inc.Add(inc, big.NewFloat(percIncrease))
-
-	actDiameterCX := new(big.Float).Copy(startDiameterCX)
-	// fmt.Printf("Start Diameter CX: %v, End Diameter CX: %v, Inc Diameter CX: %v\n", startDiameterCX, endDiameterCX, inc)
-
-	// for actDiameterCX.Cmp(endDiameterCX) == dir {
-	for i := 0; i <= nrOfImages; i++ {
-		total := new(big.Float).Copy(deltaDiameter)
-		diff := new(big.Float).Sub(actDiameterCX, startDiameterCX)
-		percDone := diff.Quo(diff, total).Abs(diff)
-
-		// newCX = deltaX * percentage + startCenterCX
-		newCX := new(big.Float).Copy(deltaX)
-		newCX.Mul(newCX, percDone)
-		newCX.Add(newCX, startCenterCX)
-
-		// newCY = deltaY * percentage + startCenterCY
-		newCY := new(big.Float).Copy(deltaY)
-		newCY.Mul(newCY, percDone)
-		newCY.Add(newCY, startCenterCY)
-
-		commonFractParams.CenterCX, _ = newCX.Float64()
-		commonFractParams.CenterCY, _ = newCY.Float64()
-		commonFractParams.DiameterCX, _ = actDiameterCX.Mul(actDiameterCX, inc).Float64()
+	views := lib.FlightViews(
+		lib.FlightView{CenterCX: c.StartCenterCX, CenterCY: c.StartCenterCY, DiameterCX: c.StartDiameterCX},
+		lib.FlightView{CenterCX: c.EndCenterCX, CenterCY: c.EndCenterCY, DiameterCX: c.EndDiameterCX},
+		nrOfImages,
+	)
+	for i, view := range views {
+		commonFractParams.CenterCX = view.CenterCX
+		commonFractParams.CenterCY = view.CenterCY
+		commonFractParams.DiameterCX = view.DiameterCX
 
 		fractal, err = lib.NewFractalFromParams(c.Function, commonFractParams, c.JuliaKr, c.JuliaKi)
 		if err != nil {
 			return err
 		}
-		filename := fmt.Sprintf("%s/%08d.%s", c.OutputFolder, i, c.Format)
+		filename := fmt.Sprintf("%s/%08d.%s", c.OutputFolder, i, format.Extension())
 
 		img := lib.CalcFractalImage(fractal)
 		file, err := os.Create(filename)
@@ -289,15 +319,10 @@ func (c *FlightCmd) Run(appContext *lib.AppContext) error {
 		}
 		defer file.Close()
 
-		switch c.Format {
-		case "png":
-			err = img.EncodePng(file)
-		case "jpeg":
-			fallthrough
-		case "jpg":
-			err = img.EncodeJpeg(file)
-		default:
-			return errors.New("unknown image format")
+		img.Dither = lib.DitherMode(c.Dither)
+		err = format.Encode(file, img, c.EncodeFlags.options())
+		if err != nil {
+			return err
 		}
 		p := float64(i) / float64(nrOfImages)
 		fmt.Printf("%0.1f%% Image saved to %s\n", p*100, filename)
@@ -311,8 +336,75 @@ func (c *FlightCmd) Run(appContext *lib.AppContext) error {
 	return err
 }
 
+/*
+Renders the frames of a Julia morph: the Julia constant moves along the path at a constant speed, the view is the start
+view of the flight.
+*/
+func (c *FlightCmd) runJuliaMorph(format lib.ImageFormat, commonFractParams lib.CommonFractParams, nrOfImages int) error {
+	path, err := lib.ParseJuliaPath(c.JuliaPath)
+	if err != nil {
+		return err
+	}
+	if c.JuliaPathPreview != "" {
+		pathColor, err := lib.ParseCssColor(c.PathColor)
+		if err != nil {
+			return err
+		}
+		previewFormat, err := lib.ImageFormatFromFilename(c.JuliaPathPreview)
+		if err != nil {
+			return err
+		}
+		img, err := lib.CalcJuliaPathPreviewImage(commonFractParams, c.Width, c.Height, path, color.NRGBA(pathColor))
+		if err != nil {
+			return err
+		}
+		file, err := os.Create(c.JuliaPathPreview)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := previewFormat.Encode(file, img, c.EncodeFlags.options()); err != nil {
+			return err
+		}
+		fmt.Printf("Path preview saved to %s\n", c.JuliaPathPreview)
+	}
+
+	if err := os.MkdirAll(c.OutputFolder, os.ModePerm); err != nil {
+		return err
+	}
+	points := path.Points(nrOfImages)
+	for i, k := range points {
+		fractal, err := lib.NewFractalFromParams(lib.FRACTAL_TYPE_JULIA, commonFractParams, real(k), imag(k))
+		if err != nil {
+			return err
+		}
+		filename := fmt.Sprintf("%s/%08d.%s", c.OutputFolder, i, format.Extension())
+
+		img := lib.CalcFractalImage(fractal)
+		img.Dither = lib.DitherMode(c.Dither)
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		err = format.Encode(file, img, c.EncodeFlags.options())
+		file.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%0.1f%% Image saved to %s (Julia constant %v)\n", float64(i+1)/float64(len(points))*100, filename, k)
+	}
+	return nil
+}
+
 type Cli struct {
-	Serve  ServeCmd  `cmd:"" help:"Start the web server."`
-	Image  ImageCmd  `cmd:"" help:"Generate a single image."`
-	Flight FlightCmd `cmd:"" help:"Generate a flight through a fractal: generate a series of images from a start point to an end point."`
+	Serve   ServeCmd   `cmd:"" help:"Start the web server."`
+	Image   ImageCmd   `cmd:"" help:"Generate a single image."`
+	Flight  FlightCmd  `cmd:"" help:"Generate a flight through a fractal: generate a series of images from a start point to an end point, or a Julia morph along a path."`
+	Batch   BatchCmd   `cmd:"" help:"Render the images of a batch manifest, or a thumbnail gallery of all fractal presets."`
+	Gallery GalleryCmd `cmd:"" help:"Render all fractal presets, or all color presets applied to a fractal preset, into a contact sheet."`
+	Sweep   SweepCmd   `cmd:"" help:"Vary one or two fractal params over a range: render a grid of the steps, an animation, or a Julia map."`
+	Palette PaletteCmd `cmd:"" help:"Manage color palettes."`
+	Inspect InspectCmd `cmd:"" help:"Print the render params embedded in a png / jpeg image."`
+	Spec    SpecCmd    `cmd:"" help:"Convert between render specs, fractal presets and image command lines."`
+	Presets PresetsCmd `cmd:"" help:"Validate and convert presets files."`
 }
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"image/color"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+// Coloring options shared by the image generating commands.
+type ColoringFlags struct {
+	PaletteMapping         string  `help:"Mapping of the iteration values onto the palette." enum:"linear,log,sqrt,power,histogram,rank" default:"linear"`
+	PaletteMappingExponent float64 `help:"Exponent for --palette-mapping=power." default:"0.5"`
+	PaletteOffset          float64 `help:"Rotates the palette by the given amount, 1.0 = one full palette length." default:"0"`
+
+	Coloring            string  `help:"Coloring algorithm for the exterior of the set." enum:"escape-time,smooth,triangle-inequality,curvature,stripe,binary-decomposition,field-lines,final-angle" default:"smooth"`
+	Coloring2           string  `name:"coloring2" help:"Second coloring algorithm, combined with the first one by the blend mode." enum:"none,escape-time,smooth,triangle-inequality,curvature,stripe,binary-decomposition,field-lines,final-angle" default:"none"`
+	ColoringBlendMode   string  `help:"Blend mode to combine the two coloring algorithms." enum:"mix,add,multiply,screen,difference" default:"mix"`
+	ColoringBlendFactor float64 `help:"Weight of the second coloring algorithm (0.0 - 1.0)." default:"0.5"`
+	ColoringDensity     float64 `help:"Density of the stripes / field lines." default:"5"`
+
+	InteriorMode   string `help:"Coloring mode for the interior of the set." enum:"black,period,final-abs,angle,multiplier,atom-domain" default:"black"`
+	InteriorPreset string `help:"Name of the color preset to use for the interior of the set. Defaults to the color preset." default:""`
+	InteriorColor  string `help:"Color of the interior for --interior-mode=black, as CSS color, e.g. '#102030', 'transparent' or 'rgba(0, 0, 0, 0.5)'." default:"black"`
+	PeriodCheck    bool   `help:"Detect periodic orbits to stop iterating interior points early." default:"true" negatable:""`
+
+	DistanceMode         string   `help:"Use the distance estimation for outlines, coloring or 3D-like shading." enum:"off,outline,color,shading" default:"off"`
+	DistanceOutlineWidth float64  `help:"Width of the outline in pixels, for --distance-mode=outline." default:"1"`
+	LightAngle           *float64 `help:"Direction of the light in degrees, for --distance-mode=shading. Defaults to 45."`
+	LightHeight          float64  `help:"Height of the light over the plane, for --distance-mode=shading." default:"1.5"`
+}
+
+func (c ColoringFlags) applyTo(params *lib.CommonFractParams, colorPresets lib.ColorPresets) error {
+	if c.InteriorPreset != "" {
+		interiorPreset, err := colorPresets.GetByIdent(c.InteriorPreset)
+		if err != nil {
+			return err
+		}
+		params.InteriorColorPalette = interiorPreset.Palette
+	}
+	interiorColor, err := lib.ParseCssColor(c.InteriorColor)
+	if err != nil {
+		return err
+	}
+	params.InteriorColor = color.NRGBA(interiorColor)
+	params.ColorPaletteMapping = lib.PaletteMapping(c.PaletteMapping)
+	params.ColorPaletteMappingExponent = c.PaletteMappingExponent
+	params.ColorPaletteOffset = c.PaletteOffset
+
+	params.ColoringAlgorithm = lib.ColoringAlgorithm(c.Coloring)
+	if c.Coloring2 != "none" {
+		params.ColoringAlgorithm2 = lib.ColoringAlgorithm(c.Coloring2)
+	}
+	params.ColoringBlendMode = lib.BlendMode(c.ColoringBlendMode)
+	blendFactor := c.ColoringBlendFactor
+	params.ColoringBlendFactor = &blendFactor
+	params.ColoringDensity = c.ColoringDensity
+
+	params.PeriodCheck = c.PeriodCheck
+	params.InteriorMode = lib.InteriorMode(c.InteriorMode)
+
+	params.DistanceMode = lib.DistanceMode(c.DistanceMode)
+	params.DistanceOutlineWidth = c.DistanceOutlineWidth
+	params.LightAngle = c.LightAngle
+	params.LightHeight = c.LightHeight
+	return nil
+}
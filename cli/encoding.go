@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"github.com/bylexus/go-fract/lib"
+)
+
+// Image format options shared by the image generating commands.
+type EncodeFlags struct {
+	Quality           int    `help:"Quality of jpeg images (1 - 100)." default:"90"`
+	ChromaSubsampling string `help:"Chroma subsampling of jpeg images: 444 keeps the full color resolution, 420 has the smallest files." enum:"444,422,420" default:"420"`
+	PngCompression    string `help:"Compression level of png images." enum:"default,none,fast,best" default:"default"`
+	GifColors         int    `help:"Number of palette colors of gif images (2 - 256)." default:"256"`
+	Metadata          bool   `help:"Embed the render params into png and jpeg images, see the inspect command." default:"true" negatable:""`
+}
+
+func (c EncodeFlags) options() lib.EncodeOptions {
+	return lib.EncodeOptions{
+		JpegQuality:       c.Quality,
+		ChromaSubsampling: lib.ChromaSubsampling(c.ChromaSubsampling),
+		PngCompression:    lib.PngCompression(c.PngCompression),
+		GifColors:         c.GifColors,
+		OmitMetadata:      !c.Metadata,
+	}
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"os"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+type GalleryCmd struct {
+	FractalPreset string   `help:"Render all color presets applied to this fractal preset, instead of all fractal presets."`
+	CellWidth     int      `help:"Width of the fractal renders, in pixels." default:"240"`
+	CellHeight    int      `help:"Height of the fractal renders, in pixels." default:"150"`
+	Columns       int      `help:"Number of columns. 0 arranges the cells as square as possible." default:"0"`
+	Gap           int      `help:"Space between the cells, in pixels." default:"8"`
+	Captions      bool     `help:"Print the preset names below the cells." default:"true" negatable:""`
+	Background    string   `help:"Background color of the sheet, as CSS color." default:"#202020"`
+	Format        string   `help:"Format of the image to generate, see the image command. Detected by the file extension if not set."`
+	Dither        string   `help:"Dithering when reducing the 16 bit colors to 8 bit." enum:"none,ordered,blue-noise" default:"none"`
+	PresetsFile   []string `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets." type:"path" sep:"none"`
+
+	EncodeFlags `embed:""`
+
+	OutputPath string `arg:"" help:"Path to save the contact sheet to." type:"path" default:"gallery.jpg"`
+}
+
+func (c *GalleryCmd) Run(appContext *lib.AppContext) error {
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
+	if err != nil {
+		return err
+	}
+	var format lib.ImageFormat
+	if c.Format == "" {
+		format, err = lib.ImageFormatFromFilename(c.OutputPath)
+	} else {
+		format, err = lib.GetImageFormat(c.Format)
+	}
+	if err != nil {
+		return err
+	}
+	background, err := lib.ParseCssColor(c.Background)
+	if err != nil {
+		return err
+	}
+
+	cells := lib.FractalPresetsContactSheet(presets)
+	if c.FractalPreset != "" {
+		fractalPreset, err := presets.FractalPresets.GetByName(c.FractalPreset)
+		if err != nil {
+			return err
+		}
+		cells = lib.ColorPresetsContactSheet(presets, fractalPreset)
+	}
+	opts := lib.ContactSheetOptions{
+		CellWidth:  c.CellWidth,
+		CellHeight: c.CellHeight,
+		Columns:    c.Columns,
+		Gap:        c.Gap,
+		Captions:   c.Captions,
+		Background: color.NRGBA(background),
+	}
+	img, err := lib.CalcContactSheetImage(context.Background(), presets.ColorPresets, cells, opts, func(done, total int) {
+		fmt.Printf("\r%d / %d presets rendered", done, total)
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(c.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	img.Dither = lib.DitherMode(c.Dither)
+	if err := format.Encode(file, img, c.EncodeFlags.options()); err != nil {
+		return err
+	}
+	fmt.Printf("Contact sheet of %d presets (%dx%d) saved to %s\n", len(cells), img.Rect.Dx(), img.Rect.Dy(), c.OutputPath)
+	return nil
+}
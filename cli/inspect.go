@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+type InspectCmd struct {
+	Json  bool   `help:"Print the complete render params as JSON, including the palettes."`
+	Image string `arg:"" help:"Path to the png / jpeg image to inspect." type:"existingfile"`
+}
+
+func (c *InspectCmd) Run(appContext *lib.AppContext) error {
+	params, err := lib.ReadRenderParamsFile(c.Image)
+	if err != nil {
+		return err
+	}
+	if c.Json {
+		jsonData, err := json.MarshalIndent(params, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	printField("Generator", params.Generator)
+	printField("Size", fmt.Sprintf("%d x %d", params.Width, params.Height))
+	if params.Composition != nil {
+		printField("Composition", params.Composition.Name)
+		for i, layer := range params.Composition.Layers {
+			layerInfo := layer.FractalPreset
+			if layer.BlendMode != "" {
+				layerInfo += ", blend mode " + layer.BlendMode
+			}
+			if layer.Mask != nil {
+				layerInfo += ", mask " + layer.Mask.FractalPreset
+			}
+			printField(fmt.Sprintf("Layer %d", i+1), layerInfo)
+		}
+		return nil
+	}
+	if params.Fractal == nil {
+		return nil
+	}
+
+	f := params.Fractal
+	printField("Fractal", f.IterFunc)
+	printField("Center", fmt.Sprintf("%s, %s", formatFloat(f.CenterCX), formatFloat(f.CenterCY)))
+	printField("Diameter", formatFloat(f.DiameterCX))
+	printField("Iterations", strconv.Itoa(f.MaxIterations))
+	if f.IterFunc == lib.FRACTAL_TYPE_JULIA {
+		printField("Julia", fmt.Sprintf("%s, %s", formatFloat(f.JuliaKr), formatFloat(f.JuliaKi)))
+	}
+	if colorPreset, err := params.Presets.ColorPresets.GetByIdent(f.ColorPreset); err == nil {
+		printField("Palette", fmt.Sprintf("%d colors, repeat %d, length %d, reverse %t, hard stops %t",
+			len(colorPreset.Palette), f.ColorPaletteRepeat, f.ColorPaletteLength, f.ColorPaletteReverse, f.ColorPaletteHardStops))
+	}
+	printField("Mapping", f.ColorPaletteMapping)
+	coloring := f.ColoringAlgorithm
+	if f.ColoringAlgorithm2 != "" {
+		coloring += fmt.Sprintf(" + %s (%s", f.ColoringAlgorithm2, f.ColoringBlendMode)
+		if f.ColoringBlendFactor != nil {
+			coloring += ", " + formatFloat(*f.ColoringBlendFactor)
+		}
+		coloring += ")"
+	}
+	printField("Coloring", coloring)
+	printField("Interior", fmt.Sprintf("%s, %s", f.InteriorMode, f.InteriorColor))
+	printField("Distance", f.DistanceMode)
+	return nil
+}
+
+func printField(name, value string) {
+	fmt.Printf("%-12s %s\n", name+":", value)
+}
+
+// formats the float with the precision needed to parse it back exactly
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
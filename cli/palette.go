@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+type PaletteCmd struct {
+	Import   PaletteImportCmd   `cmd:"" help:"Import color presets from external gradient / palette formats (GIMP .ggr/.gpl, Adobe .ase, CSS gradients, UltraFractal .ugr, Fractint .map, images)."`
+	Generate PaletteGenerateCmd `cmd:"" help:"Generate a color preset procedurally (cosine gradient, color harmony, random) or from the dominant colors of a photo (k-means, median cut)."`
+}
+
+type PaletteImportCmd struct {
+	Format      string `help:"Format of the input. If not set, it is detected by the file extension." enum:",ggr,gpl,ase,css,ugr,map,image" default:""`
+	Name        string `help:"Name of the imported color preset. Defaults to the name in the file, or the file name."`
+	ImageRow    int    `help:"For images: the pixel row to take the colors from, -1 = the middle row." default:"-1"`
+	ImageColors int    `help:"For images: the number of colors to sample from the pixel row." default:"32"`
+	Output      string `help:"Presets file to add the imported color presets to. If not set, the color presets are printed as JSON." type:"path"`
+
+	Input string `arg:"" help:"File to import, or a CSS gradient string with --format=css, e.g. 'linear-gradient(#000, #ff8800 40%, #fff)'."`
+}
+
+func (c *PaletteImportCmd) Run(appContext *lib.AppContext) error {
+	var format = lib.PaletteFormat(c.Format)
+	var fallbackName = strings.TrimSuffix(filepath.Base(c.Input), filepath.Ext(c.Input))
+	var colorPresets lib.ColorPresets
+	var err error
+
+	if format == lib.PALETTE_FORMAT_CSS && strings.Contains(c.Input, "(") {
+		// the input is the gradient itself
+		fallbackName = "CSS Gradient"
+		colorPresets, err = lib.ImportColorPresets(strings.NewReader(c.Input), format, c.importOptions(fallbackName))
+	} else {
+		if format == "" {
+			format, err = lib.PaletteFormatFromFilename(c.Input)
+			if err != nil {
+				return err
+			}
+		}
+		file, openErr := os.Open(c.Input)
+		if openErr != nil {
+			return openErr
+		}
+		defer file.Close()
+		colorPresets, err = lib.ImportColorPresets(file, format, c.importOptions(fallbackName))
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Output == "" {
+		return printColorPresets(colorPresets)
+	}
+	return saveColorPresets(c.Output, colorPresets)
+}
+
+func (c *PaletteImportCmd) importOptions(fallbackName string) lib.PaletteImportOptions {
+	return lib.PaletteImportOptions{
+		Name:         c.Name,
+		FallbackName: fallbackName,
+		ImageRow:     c.ImageRow,
+		ImageColors:  c.ImageColors,
+	}
+}
+
+type PaletteGenerateCmd struct {
+	Generator     string    `help:"Palette generator to use." enum:"cosine,harmony,random,kmeans,median-cut" default:"cosine"`
+	Colors        int       `help:"Number of colors to generate." default:"16"`
+	CosineA       []float64 `help:"Cosine gradient a + b*cos(2π(c*t + d)): offset a (r,g,b)." default:"0.5,0.5,0.5"`
+	CosineB       []float64 `help:"Cosine gradient: amplitude b (r,g,b)." default:"0.5,0.5,0.5"`
+	CosineC       []float64 `help:"Cosine gradient: frequency c (r,g,b). Use integers for a seamless, cyclic palette." default:"1,1,1"`
+	CosineD       []float64 `help:"Cosine gradient: phase d (r,g,b)." default:"0,0.33,0.67"`
+	BaseHue       float64   `help:"Harmony: base hue, in degrees." default:"0"`
+	Harmony       string    `help:"Harmony: the color harmony." enum:"monochromatic,complementary,analogous,triadic,split-complementary,tetradic" default:"complementary"`
+	Saturation    float64   `help:"Harmony: saturation of the base color (0.0 - 1.0)." default:"0.8"`
+	Lightness     float64   `help:"Harmony: lightness of the base color (0.0 - 1.0)." default:"0.5"`
+	Seed          uint64    `help:"Random: seed for the random generator (also used by k-means). 0 = a random seed."`
+	Image         string    `help:"K-means, median cut: the reference image to extract the colors from." type:"existingfile"`
+	Interpolation string    `help:"Color space for the interpolation of the generated preset." enum:",rgb,linear-rgb,hsl,hsv,lab,oklab,oklch" default:""`
+	Curve         string    `help:"Interpolation curve of the generated preset." enum:",linear,smoothstep,spline" default:""`
+	Name          string    `help:"Name of the generated color preset. Defaults to the generator's name."`
+	Output        string    `help:"Presets file to add the generated color preset to. If not set, the color preset is printed as JSON." type:"path"`
+}
+
+func (c *PaletteGenerateCmd) Run(appContext *lib.AppContext) error {
+	opts := lib.PaletteGenerateOptions{
+		Generator:  lib.PaletteGenerator(c.Generator),
+		Colors:     c.Colors,
+		BaseHue:    c.BaseHue,
+		Harmony:    lib.ColorHarmony(c.Harmony),
+		Saturation: c.Saturation,
+		Lightness:  c.Lightness,
+		Seed:       c.Seed,
+	}
+	for _, cosineParam := range []struct {
+		flag   string
+		values []float64
+		target *[3]float64
+	}{
+		{"cosine-a", c.CosineA, &opts.CosineA},
+		{"cosine-b", c.CosineB, &opts.CosineB},
+		{"cosine-c", c.CosineC, &opts.CosineC},
+		{"cosine-d", c.CosineD, &opts.CosineD},
+	} {
+		if len(cosineParam.values) != 3 {
+			return fmt.Errorf("--%s needs 3 values (r,g,b)", cosineParam.flag)
+		}
+		copy(cosineParam.target[:], cosineParam.values)
+	}
+
+	if opts.Seed == 0 && (opts.Generator == lib.PALETTE_GENERATOR_RANDOM || opts.Generator == lib.PALETTE_GENERATOR_KMEANS) {
+		opts.Seed = rand.Uint64()
+		fmt.Fprintf(os.Stderr, "Using seed %d\n", opts.Seed)
+	}
+
+	if c.Image != "" {
+		file, err := os.Open(c.Image)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		opts.Image, _, err = image.Decode(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	palette, err := lib.GeneratePalette(opts)
+	if err != nil {
+		return err
+	}
+
+	name := c.Name
+	if name == "" {
+		name = generatedPresetName(opts, c.Image)
+	}
+	colorPresets := lib.ColorPresets{{
+		Name:          name,
+		Ident:         lib.PresetIdent(name),
+		Palette:       palette,
+		Interpolation: lib.ColorSpace(c.Interpolation),
+		Curve:         lib.InterpolationCurve(c.Curve),
+	}}
+
+	if c.Output == "" {
+		return printColorPresets(colorPresets)
+	}
+	return saveColorPresets(c.Output, colorPresets)
+}
+
+func generatedPresetName(opts lib.PaletteGenerateOptions, imagePath string) string {
+	switch opts.Generator {
+	case lib.PALETTE_GENERATOR_HARMONY:
+		return fmt.Sprintf("Harmony %s %.0f", opts.Harmony, opts.BaseHue)
+	case lib.PALETTE_GENERATOR_RANDOM:
+		return fmt.Sprintf("Random %d", opts.Seed)
+	case lib.PALETTE_GENERATOR_KMEANS, lib.PALETTE_GENERATOR_MEDIAN_CUT:
+		return strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	default:
+		return "Cosine Gradient"
+	}
+}
+
+func printColorPresets(colorPresets lib.ColorPresets) error {
+	jsonData, err := json.MarshalIndent(colorPresets, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonData))
+	return nil
+}
+
+// adds the color presets to the presets file, which is created if it does not exist yet
+func saveColorPresets(presetsFile string, colorPresets lib.ColorPresets) error {
+	_, err := lib.UpdatePresetJson(presetsFile, func(presets *lib.Presets) error {
+		presets.AddColorPresets(colorPresets...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, preset := range colorPresets {
+		fmt.Printf("Color preset '%s' (%s) with %d colors saved to %s\n", preset.Name, preset.Ident, len(preset.Palette), presetsFile)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+type PresetsCmd struct {
+	Validate PresetsValidateCmd `cmd:"" help:"Validate presets files (JSON, YAML or TOML) against the presets JSON Schema, and check the references between the presets."`
+	Schema   PresetsSchemaCmd   `cmd:"" help:"Print the JSON Schema of the presets files."`
+	Convert  PresetsConvertCmd  `cmd:"" help:"Convert a presets file between JSON, YAML and TOML."`
+}
+
+type PresetsValidateCmd struct {
+	Strict bool     `help:"Fail on warnings too (unknown properties, duplicate names / idents), not only on errors."`
+	Paths  []string `arg:"" optional:"" help:"Presets files or directories to validate, merged in order over the embedded presets like --presets-file. If not set, the embedded presets are validated." type:"path"`
+}
+
+func (c *PresetsValidateCmd) Run(appContext *lib.AppContext) error {
+	presets, err := lib.ReadPresetFiles(c.Paths, appContext.EmbeddedPresets)
+	var problems lib.PresetProblems
+	if errors.As(err, &problems) {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("%d errors, %d warnings found", len(problems.Errors()), len(problems)-len(problems.Errors()))
+	}
+	if err != nil {
+		return err
+	}
+	for _, warning := range presets.Warnings {
+		fmt.Println(warning)
+	}
+	if c.Strict && len(presets.Warnings) > 0 {
+		return fmt.Errorf("%d warnings found", len(presets.Warnings))
+	}
+	for _, source := range append([]string{lib.PRESETS_ORIGIN_EMBEDDED}, presets.Sources...) {
+		fmt.Printf("%s: ok\n", source)
+	}
+	fmt.Printf("%d fractal presets, %d color presets, %d compositions\n",
+		len(presets.FractalPresets), len(presets.ColorPresets), len(presets.CompositionPresets))
+	return nil
+}
+
+type PresetsSchemaCmd struct{}
+
+func (c *PresetsSchemaCmd) Run(appContext *lib.AppContext) error {
+	_, err := os.Stdout.Write(lib.PresetsSchema)
+	return err
+}
+
+type PresetsConvertCmd struct {
+	Format        string `help:"Format to convert to: json, yaml or toml. Detected by the output file extension if not set." enum:",json,yaml,toml" default:""`
+	CompactColors bool   `help:"Write the palette colors as CSS hex colors ('#ff8800') instead of R/G/B/A objects." default:"true" negatable:""`
+
+	Input  string `arg:"" help:"Presets file to convert. The format is detected by the file extension (.json, .yaml / .yml, .toml)." type:"existingfile"`
+	Output string `arg:"" optional:"" help:"File to write the converted presets to. If not set, the presets are printed." type:"path"`
+}
+
+func (c *PresetsConvertCmd) Run(appContext *lib.AppContext) error {
+	format := c.Format
+	if format == "" {
+		if c.Output == "" {
+			return errors.New("the format is required (--format) if no output file is set")
+		}
+		format = lib.PresetsFormatOfFile(c.Output)
+	}
+	presets, err := lib.ReadPresetJson(c.Input, nil)
+	if err != nil {
+		return err
+	}
+	data, err := lib.EncodePresets(presets, format, c.CompactColors)
+	if err != nil {
+		return err
+	}
+	if c.Output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(c.Output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%d fractal presets, %d color presets, %d compositions written to %s\n",
+		len(presets.FractalPresets), len(presets.ColorPresets), len(presets.CompositionPresets), c.Output)
+	return nil
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+type SpecCmd struct {
+	Encode SpecEncodeCmd `cmd:"" help:"Encode a fractal preset or the render params of an image as render spec."`
+	Decode SpecDecodeCmd `cmd:"" help:"Decode a render spec to a fractal preset and an equivalent image command line."`
+}
+
+type SpecEncodeCmd struct {
+	FractalPreset string   `help:"Name of the fractal preset to encode. Use in combination with --presets-file."`
+	From          string   `help:"Encode the render params embedded in the given png / jpeg image." type:"existingfile"`
+	Width         int      `help:"Width of the image, in pixels. Defaults to 1920, or the width of the --from image."`
+	Height        int      `help:"Height of the image, in pixels. Defaults to 1200, or the height of the --from image."`
+	InlinePalette bool     `help:"Include the palettes in the spec, instead of referencing the color presets by their ident."`
+	PresetsFile   []string `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets." type:"path" sep:"none"`
+}
+
+func (c *SpecEncodeCmd) Run(appContext *lib.AppContext) error {
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
+	if err != nil {
+		return err
+	}
+
+	var params lib.RenderParams
+	switch {
+	case c.From != "":
+		params, err = lib.ReadRenderParamsFile(c.From)
+		if err != nil {
+			return err
+		}
+	case c.FractalPreset != "":
+		fractalPreset, err := presets.FractalPresets.GetByName(c.FractalPreset)
+		if err != nil {
+			return err
+		}
+		params = lib.RenderParams{Width: 1920, Height: 1200, Fractal: &fractalPreset}
+	default:
+		return errors.New("either --fractal-preset or --from is required")
+	}
+	if c.Width > 0 {
+		params.Width = c.Width
+	}
+	if c.Height > 0 {
+		params.Height = c.Height
+	}
+
+	colorPresets := presets.ColorPresets
+	if c.InlinePalette {
+		// move the referenced color presets into the params, so they are encoded inline:
+		for _, ident := range []string{params.Fractal.ColorPreset, params.Fractal.InteriorColorPreset} {
+			if _, err := params.Presets.ColorPresets.GetByIdent(ident); ident == "" || err == nil {
+				continue
+			}
+			colorPreset, err := colorPresets.GetByIdent(ident)
+			if err != nil {
+				return err
+			}
+			params.Presets.AddColorPresets(colorPreset)
+		}
+		colorPresets = nil
+	}
+
+	spec, err := lib.EncodeRenderSpec(params, colorPresets)
+	if err != nil {
+		return err
+	}
+	fmt.Println(spec)
+	fmt.Printf("Link: /r/%s.jpg\n", spec)
+	return nil
+}
+
+type SpecDecodeCmd struct {
+	PresetsFile []string `help:"Path to presets file or directory, for the color presets referenced by the spec. Can be repeated: the presets files are merged in order over the embedded presets." type:"path" sep:"none"`
+
+	Spec string `arg:"" help:"The render spec to decode."`
+}
+
+func (c *SpecDecodeCmd) Run(appContext *lib.AppContext) error {
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
+	if err != nil {
+		return err
+	}
+	params, err := lib.DecodeRenderSpec(c.Spec, presets.ColorPresets)
+	if err != nil {
+		return err
+	}
+
+	fractalPreset := *params.Fractal
+	if fractalPreset.Name == "" {
+		fractalPreset.Name = "Render spec"
+	}
+	jsonData, err := json.MarshalIndent(lib.Presets{
+		ColorPresets:   params.Presets.ColorPresets,
+		FractalPresets: lib.FractalPresets{fractalPreset},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonData))
+	fmt.Println()
+	fmt.Println(imageCommandLine(params, presets.ColorPresets, c.Spec))
+	return nil
+}
+
+/*
+Returns the image command rendering the params: with the fractal parameters as flags if the palettes are known
+color presets, or with --spec if the palettes are only contained in the spec.
+*/
+func imageCommandLine(params lib.RenderParams, colorPresets lib.ColorPresets, spec string) string {
+	f := params.Fractal
+	args := []string{"fractgen", "image", fmt.Sprintf("--width=%d", params.Width), fmt.Sprintf("--height=%d", params.Height)}
+	for _, ident := range []string{f.ColorPreset, f.InteriorColorPreset} {
+		if ident == "" {
+			continue
+		}
+		used, _ := params.Presets.ColorPresets.GetByIdent(ident)
+		known, err := colorPresets.GetByIdent(ident)
+		if err != nil || !slices.Equal(known.Palette, used.Palette) || known.Interpolation != used.Interpolation || known.Curve != used.Curve {
+			args = append(args, "--spec="+spec, "image.jpg")
+			return strings.Join(args, " ")
+		}
+	}
+
+	function, _ := f.FractalFunction()
+	args = append(args,
+		"--function="+string(function),
+		"--center-cx="+formatFloat(f.CenterCX),
+		"--center-cy="+formatFloat(f.CenterCY),
+		"--diameter-cx="+formatFloat(f.DiameterCX),
+		fmt.Sprintf("--max-iter=%d", f.MaxIterations),
+		"--color-preset="+shellQuote(strings.ToLower(f.ColorPreset)),
+		fmt.Sprintf("--palette-repeat=%d", f.ColorPaletteRepeat),
+		fmt.Sprintf("--palette-length=%d", f.ColorPaletteLength),
+		"--palette-mapping-exponent="+formatFloat(f.ColorPaletteMappingExponent),
+		"--palette-offset="+formatFloat(f.ColorPaletteOffset),
+		"--coloring-density="+formatFloat(f.ColoringDensity),
+		"--distance-outline-width="+formatFloat(f.DistanceOutlineWidth),
+		"--light-height="+formatFloat(f.LightHeight),
+	)
+	if f.LightAngle != nil {
+		args = append(args, "--light-angle="+formatFloat(*f.LightAngle))
+	}
+	if f.ColoringBlendFactor != nil {
+		args = append(args, "--coloring-blend-factor="+formatFloat(*f.ColoringBlendFactor))
+	}
+	if function == lib.FRACTAL_TYPE_JULIA {
+		args = append(args, "--julia-kr="+formatFloat(f.JuliaKr), "--julia-ki="+formatFloat(f.JuliaKi))
+	}
+	if f.ColorPaletteReverse {
+		args = append(args, "--palette-reverse")
+	}
+	if f.ColorPaletteHardStops {
+		args = append(args, "--palette-hard-stops")
+	}
+	if f.DisablePeriodCheck {
+		args = append(args, "--no-period-check")
+	}
+	// empty values are the defaults of the flags:
+	for _, flag := range []struct{ name, value string }{
+		{"palette-mapping", f.ColorPaletteMapping},
+		{"coloring", f.ColoringAlgorithm},
+		{"coloring2", f.ColoringAlgorithm2},
+		{"coloring-blend-mode", f.ColoringBlendMode},
+		{"interior-mode", f.InteriorMode},
+		{"interior-preset", f.InteriorColorPreset},
+		{"interior-color", f.InteriorColor},
+		{"distance-mode", f.DistanceMode},
+	} {
+		if flag.value != "" {
+			args = append(args, fmt.Sprintf("--%s=%s", flag.name, shellQuote(strings.ToLower(flag.value))))
+		}
+	}
+	return strings.Join(append(args, "image.jpg"), " ")
+}
+
+// quotes the value for the shell if needed
+func shellQuote(s string) string {
+	if strings.ContainsAny(s, " '\"#()$&;|<>*?`\\") {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	return s
+}
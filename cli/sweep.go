@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+type SweepCmd struct {
+	Param         []string `help:"Fractal preset field to vary, as name=from:to:steps[:log] with the JSON name of the field, e.g. 'juliaKr=-1:1:9' or 'maxIterations=50:5000:6:log'. Give it twice for a grid (first param along the columns, second along the rows)." required:"" sep:"none"`
+	FractalPreset string   `help:"Name of the fractal preset to vary. Defaults to a Julia set." default:""`
+	Spec          string   `help:"Render spec of the fractal to vary (see the spec command), instead of a fractal preset."`
+	ColorPreset   string   `help:"Name of the color preset of the default Julia set." default:"patchwork"`
+
+	Animation  bool    `help:"Render the steps as frames of an animation into the output folder, instead of a grid image. All params change together, so they need the same number of steps."`
+	JuliaMap   bool    `help:"Place the Julia set thumbnails of a juliaKr / juliaKi grid over the Mandelbrot set, at the points of their constants."`
+	JuliaScale float64 `help:"Size of the --julia-map thumbnails, relative to their grid cells (0.05 - 1.0)." default:"0.8"`
+
+	Width       int      `help:"Width of each render (cell or frame), in pixels." default:"240"`
+	Height      int      `help:"Height of each render (cell or frame), in pixels. With --julia-map, the height follows from the spacing of the juliaKi values." default:"150"`
+	Gap         int      `help:"Space between the grid cells, in pixels." default:"8"`
+	Captions    bool     `help:"Print the param values below the grid cells." default:"true" negatable:""`
+	Background  string   `help:"Background color of the grid, as CSS color." default:"#202020"`
+	Format      string   `help:"Format of the image to generate, see the image command. Detected by the file extension if not set (jpeg for animations)."`
+	Dither      string   `help:"Dithering when reducing the 16 bit colors to 8 bit." enum:"none,ordered,blue-noise" default:"none"`
+	PresetsFile []string `help:"Path to presets file or directory, can be repeated: the presets files are merged in order over the embedded presets." type:"path" sep:"none"`
+
+	EncodeFlags `embed:""`
+
+	OutputPath string `arg:"" help:"Path to save the grid image to, or folder to save the animation frames to." type:"path" default:"sweep.jpg"`
+}
+
+func (c *SweepCmd) Run(appContext *lib.AppContext) error {
+	if c.Animation && c.JuliaMap {
+		return errors.New("either --animation or --julia-map is allowed")
+	}
+	presets, err := lib.ReadPresetFiles(c.PresetsFile, appContext.EmbeddedPresets)
+	if err != nil {
+		return err
+	}
+	params := make([]lib.SweepParam, len(c.Param))
+	for i, s := range c.Param {
+		if params[i], err = lib.ParseSweepParam(s); err != nil {
+			return err
+		}
+	}
+	preset, colorPresets, err := c.basePreset(presets)
+	if err != nil {
+		return err
+	}
+	var format lib.ImageFormat
+	switch {
+	case c.Format != "":
+		format, err = lib.GetImageFormat(c.Format)
+	case c.Animation:
+		format, err = lib.GetImageFormat("jpeg")
+	default:
+		format, err = lib.ImageFormatFromFilename(c.OutputPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Animation {
+		return c.renderFrames(format, colorPresets, preset, params)
+	}
+
+	background, err := lib.ParseCssColor(c.Background)
+	if err != nil {
+		return err
+	}
+	var img *lib.FractImage
+	progress := func(done, total int) {
+		fmt.Printf("\r%d / %d fractals rendered", done, total)
+	}
+	if c.JuliaMap {
+		if len(params) != 2 {
+			return errors.New("a Julia map needs the juliaKr and juliaKi params")
+		}
+		img, err = lib.CalcJuliaMapImage(context.Background(), colorPresets, preset, params[0], params[1], c.Width, c.JuliaScale, progress)
+	} else {
+		var cells []lib.ContactSheetCell
+		var columns int
+		cells, columns, err = lib.SweepGrid(preset, params)
+		if err != nil {
+			return err
+		}
+		opts := lib.ContactSheetOptions{
+			CellWidth:  c.Width,
+			CellHeight: c.Height,
+			Columns:    columns,
+			Gap:        c.Gap,
+			Captions:   c.Captions,
+			Background: color.NRGBA(background),
+		}
+		img, err = lib.CalcContactSheetImage(context.Background(), colorPresets, cells, opts, progress)
+	}
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(c.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	img.Dither = lib.DitherMode(c.Dither)
+	if err := format.Encode(file, img, c.EncodeFlags.options()); err != nil {
+		return err
+	}
+	fmt.Printf("Sweep (%dx%d) saved to %s\n", img.Rect.Dx(), img.Rect.Dy(), c.OutputPath)
+	return nil
+}
+
+// the fractal preset to vary, and the color presets to render it with
+func (c *SweepCmd) basePreset(presets lib.Presets) (lib.FractalPreset, lib.ColorPresets, error) {
+	switch {
+	case c.Spec != "":
+		renderParams, err := lib.DecodeRenderSpec(c.Spec, presets.ColorPresets)
+		if err != nil {
+			return lib.FractalPreset{}, nil, err
+		}
+		return *renderParams.Fractal, renderParams.Presets.ColorPresets, nil
+	case c.FractalPreset != "":
+		preset, err := presets.FractalPresets.GetByName(c.FractalPreset)
+		return preset, presets.ColorPresets, err
+	}
+	if _, err := presets.ColorPresets.GetByIdent(c.ColorPreset); err != nil {
+		return lib.FractalPreset{}, nil, err
+	}
+	return lib.FractalPreset{
+		Name:          "Julia sweep",
+		IterFunc:      lib.FRACTAL_TYPE_JULIA,
+		DiameterCX:    3.5,
+		ColorPreset:   c.ColorPreset,
+		JuliaKr:       -0.2,
+		JuliaKi:       0.8,
+		MaxIterations: 200,
+	}, presets.ColorPresets, nil
+}
+
+// renders the frames of the sweep into the output folder, like the flight command
+func (c *SweepCmd) renderFrames(format lib.ImageFormat, colorPresets lib.ColorPresets, preset lib.FractalPreset, params []lib.SweepParam) error {
+	frames, err := lib.SweepFrames(preset, params)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.OutputPath, os.ModePerm); err != nil {
+		return err
+	}
+	for i, framePreset := range frames {
+		fractal, err := lib.NewFractalFromPresets(c.Width, c.Height, colorPresets, framePreset)
+		if err != nil {
+			return err
+		}
+		img := lib.CalcFractalImage(fractal)
+		img.Dither = lib.DitherMode(c.Dither)
+		filename := filepath.Join(c.OutputPath, fmt.Sprintf("%08d.%s", i, format.Extension()))
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		err = format.Encode(file, img, c.EncodeFlags.options())
+		file.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[%d/%d] Image saved to %s\n", i+1, len(frames), filename)
+	}
+	return nil
+}
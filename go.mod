@@ -3,6 +3,11 @@ module github.com/bylexus/go-fract
 go 1.24.1
 
 require (
-	github.com/alecthomas/kong v1.10.0 // indirect
-	github.com/bylexus/go-stdlib v0.0.0-20241202152938-16dc4197cfba // indirect
+	github.com/BurntSushi/toml v1.5.0
+	github.com/alecthomas/kong v1.10.0
+	github.com/bylexus/go-stdlib v0.0.0-20241202152938-16dc4197cfba
+	golang.org/x/image v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/text v0.23.0 // indirect
@@ -0,0 +1,407 @@
+package lib
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// the size of the images of a batch job without a size, like the image command
+const (
+	BATCH_DEFAULT_WIDTH  = 1920
+	BATCH_DEFAULT_HEIGHT = 1200
+)
+
+/*
+A batch manifest: the render jobs of the batch command. A job renders a fractal preset, a composition preset,
+a render spec or an inline fractal (with the schema of a fractal preset) into one or several output images:
+
+	{
+	  "presetsFiles": ["presets.yaml"],
+	  "outputDir": "release",
+	  "defaults": {"quality": 85},
+	  "jobs": [
+	    {"fractalPreset": "Mandelbrot Total", "outputs": [
+	      {"path": "thumbs/mandelbrot.jpg", "width": 320},
+	      {"path": "posters/mandelbrot.png", "width": 7680, "height": 4320}
+	    ]},
+	    {"fractalPreset": "Mandelbrot Total", "overrides": {"maxIterations": 5000}, "path": "deep.webp"}
+	  ]
+	}
+
+The paths of the presets files and the output directory are relative to the manifest, the output paths relative to
+the output directory. The output options of a job (a job with a single output can define it in the job itself) default
+to the job's options, then to the manifest's defaults. The format must match the file extension of the path.
+*/
+type BatchManifest struct {
+	// merged in order over the embedded presets, see ReadPresetFiles
+	PresetsFiles []string    `json:"presetsFiles,omitempty"`
+	OutputDir    string      `json:"outputDir,omitempty"`
+	Defaults     BatchOutput `json:"defaults,omitempty"`
+	Jobs         []BatchJob  `json:"jobs"`
+}
+
+// A render job of a batch manifest: exactly one of FractalPreset, Composition, Spec and Fractal is set.
+type BatchJob struct {
+	// the name of the job in the report, defaults to the name of the preset
+	Name          string         `json:"name,omitempty"`
+	FractalPreset string         `json:"fractalPreset,omitempty"`
+	Composition   string         `json:"composition,omitempty"`
+	Spec          string         `json:"spec,omitempty"`
+	Fractal       *FractalPreset `json:"fractal,omitempty"`
+	// fractal preset fields replacing the fields of the fractal, e.g. {"maxIterations": 5000}
+	Overrides map[string]json.RawMessage `json:"overrides,omitempty"`
+
+	BatchOutput
+	Outputs []BatchOutput `json:"outputs,omitempty"`
+}
+
+/*
+An output image of a batch job. If only the width or the height is set, the other one is calculated from the aspect
+ratio of the job's size (the size of a render spec, or BATCH_DEFAULT_WIDTH x BATCH_DEFAULT_HEIGHT).
+The format is detected by the file extension if not set.
+*/
+type BatchOutput struct {
+	Path              string `json:"path,omitempty"`
+	Width             int    `json:"width,omitempty"`
+	Height            int    `json:"height,omitempty"`
+	Format            string `json:"format,omitempty"`
+	Quality           int    `json:"quality,omitempty"`
+	ChromaSubsampling string `json:"chromaSubsampling,omitempty"`
+	PngCompression    string `json:"pngCompression,omitempty"`
+	GifColors         int    `json:"gifColors,omitempty"`
+	Dither            string `json:"dither,omitempty"`
+	// embed the render params into png / jpeg images, default true
+	Metadata *bool `json:"metadata,omitempty"`
+}
+
+// the output with the unset options taken from the defaults
+func (o BatchOutput) withDefaults(defaults BatchOutput) BatchOutput {
+	return BatchOutput{
+		Path:              cmp.Or(o.Path, defaults.Path),
+		Width:             cmp.Or(o.Width, defaults.Width),
+		Height:            cmp.Or(o.Height, defaults.Height),
+		Format:            cmp.Or(o.Format, defaults.Format),
+		Quality:           cmp.Or(o.Quality, defaults.Quality),
+		ChromaSubsampling: cmp.Or(o.ChromaSubsampling, defaults.ChromaSubsampling),
+		PngCompression:    cmp.Or(o.PngCompression, defaults.PngCompression),
+		GifColors:         cmp.Or(o.GifColors, defaults.GifColors),
+		Dither:            cmp.Or(o.Dither, defaults.Dither),
+		Metadata:          cmp.Or(o.Metadata, defaults.Metadata),
+	}
+}
+
+/*
+Reads a batch manifest (JSON, YAML or TOML, by the file extension). The paths of the presets files and the output
+directory are made relative to the working directory.
+*/
+func ReadBatchManifest(filePath string) (BatchManifest, error) {
+	var manifest BatchManifest
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return manifest, err
+	}
+	jsonData, err := presetsToJson(data, PresetsFormatOfFile(filePath))
+	if err != nil {
+		return manifest, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("invalid batch manifest: %w", err)
+	}
+	dir := filepath.Dir(filePath)
+	for i, presetsFile := range manifest.PresetsFiles {
+		if !filepath.IsAbs(presetsFile) {
+			manifest.PresetsFiles[i] = filepath.Join(dir, presetsFile)
+		}
+	}
+	if !filepath.IsAbs(manifest.OutputDir) {
+		manifest.OutputDir = filepath.Join(dir, manifest.OutputDir)
+	}
+	return manifest, nil
+}
+
+// An output image of a batch manifest, ready to render: see BatchManifest.Renders.
+type BatchRender struct {
+	// the name of the job
+	Job    string
+	Path   string
+	Width  int
+	Height int
+	Format ImageFormat
+	Opts   EncodeOptions
+	Dither DitherMode
+	Params RenderParams
+}
+
+/*
+Returns the output images of all jobs of the manifest, with the fractals and compositions resolved by the presets.
+The errors of all jobs are returned together.
+*/
+func (m BatchManifest) Renders(presets Presets) ([]BatchRender, error) {
+	var renders []BatchRender
+	var errs []error
+	paths := make(map[string]string)
+	for i, job := range m.Jobs {
+		name := cmp.Or(job.Name, job.FractalPreset, job.Composition, fmt.Sprintf("job %d", i+1))
+		jobRenders, err := m.jobRenders(job, name, presets)
+		for _, render := range jobRenders {
+			if other, ok := paths[render.Path]; ok {
+				err = errors.Join(err, fmt.Errorf("%s is also written by %s", render.Path, other))
+			}
+			paths[render.Path] = name
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		renders = append(renders, jobRenders...)
+	}
+	return renders, errors.Join(errs...)
+}
+
+func (m BatchManifest) jobRenders(job BatchJob, name string, presets Presets) ([]BatchRender, error) {
+	params, err := job.renderParams(presets)
+	if err != nil {
+		return nil, err
+	}
+	outputs := job.Outputs
+	if len(outputs) == 0 {
+		outputs = []BatchOutput{{}}
+	}
+	renders := make([]BatchRender, 0, len(outputs))
+	for i, output := range outputs {
+		output = output.withDefaults(job.BatchOutput).withDefaults(m.Defaults)
+		if output.Path == "" {
+			return nil, fmt.Errorf("output %d: the path is missing", i+1)
+		}
+		render := BatchRender{Job: name, Path: output.Path, Params: params}
+		if !filepath.IsAbs(render.Path) {
+			render.Path = filepath.Join(m.OutputDir, render.Path)
+		}
+		render.Width, render.Height = params.Size(output.Width, output.Height)
+		if render.Width <= 0 || render.Height <= 0 {
+			return nil, fmt.Errorf("%s: invalid image size", output.Path)
+		}
+		if output.Format == "" {
+			render.Format, err = ImageFormatFromFilename(output.Path)
+		} else {
+			render.Format, err = GetImageFormat(output.Format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", output.Path, err)
+		}
+		if f, err := ImageFormatFromFilename(output.Path); err == nil && !slices.Equal(f.Extensions, render.Format.Extensions) {
+			return nil, fmt.Errorf("%s: the format %s does not match the file extension", output.Path, render.Format.Name)
+		}
+		render.Opts = EncodeOptions{
+			JpegQuality:       output.Quality,
+			ChromaSubsampling: ChromaSubsampling(output.ChromaSubsampling),
+			PngCompression:    PngCompression(output.PngCompression),
+			GifColors:         output.GifColors,
+			OmitMetadata:      output.Metadata != nil && !*output.Metadata,
+		}
+		render.Dither = DitherMode(output.Dither)
+		renders = append(renders, render)
+	}
+	return renders, nil
+}
+
+// the render params of the job's fractal or composition, with the job's size
+func (job BatchJob) renderParams(presets Presets) (RenderParams, error) {
+	sources := 0
+	for _, set := range []bool{job.FractalPreset != "", job.Composition != "", job.Spec != "", job.Fractal != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return RenderParams{}, errors.New("exactly one of fractalPreset, composition, spec and fractal is required")
+	}
+
+	defaultParams := RenderParams{Width: BATCH_DEFAULT_WIDTH, Height: BATCH_DEFAULT_HEIGHT}
+	width, height := defaultParams.Size(job.Width, job.Height)
+	params := RenderParams{Presets: Presets{ColorPresets: ColorPresets{}, FractalPresets: FractalPresets{}}}
+	switch {
+	case job.Composition != "":
+		if len(job.Overrides) > 0 {
+			return params, errors.New("the overrides are not supported for compositions")
+		}
+		composition, err := presets.CompositionPresets.GetByName(job.Composition)
+		if err != nil {
+			return params, fmt.Errorf("%w: %s", err, job.Composition)
+		}
+		return NewCompositionRenderParams(width, height, presets, composition)
+	case job.Spec != "":
+		var err error
+		params, err = DecodeRenderSpec(job.Spec, presets.ColorPresets)
+		if err != nil {
+			return params, err
+		}
+		params.Width, params.Height = params.Size(job.Width, job.Height)
+	case job.FractalPreset != "":
+		fractalPreset, err := presets.FractalPresets.GetByName(job.FractalPreset)
+		if err != nil {
+			return params, fmt.Errorf("%w: %s", err, job.FractalPreset)
+		}
+		params.Fractal = &fractalPreset
+	default:
+		fractalPreset := *job.Fractal
+		params.Fractal = &fractalPreset
+	}
+	if params.Width <= 0 || params.Height <= 0 {
+		params.Width, params.Height = width, height
+	}
+
+	if len(job.Overrides) > 0 {
+		fractalPreset, err := overrideFractalPreset(*params.Fractal, job.Overrides)
+		if err != nil {
+			return params, err
+		}
+		params.Fractal = &fractalPreset
+	}
+	if err := params.addReferencedColorPresets(presets.ColorPresets); err != nil {
+		return params, err
+	}
+	// check the fractal before rendering:
+	if _, err := NewFractalFromPresets(1, 1, params.Presets.ColorPresets, *params.Fractal); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// replaces the fields of the fractal preset by the overrides, which have the JSON names of the fields
+func overrideFractalPreset(fractalPreset FractalPreset, overrides map[string]json.RawMessage) (FractalPreset, error) {
+	data, err := json.Marshal(fractalPreset)
+	if err != nil {
+		return fractalPreset, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fractalPreset, err
+	}
+	for key, value := range overrides {
+		fields[key] = value
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return fractalPreset, err
+	}
+	var result FractalPreset
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return fractalPreset, fmt.Errorf("invalid overrides: %w", err)
+	}
+	return result, nil
+}
+
+/*
+A hash of everything the output image depends on: the render params (including the palettes), the size, the format
+and the encoder options. An output with an unchanged hash is up to date.
+*/
+func (r BatchRender) Hash() string {
+	params := r.Params
+	params.Generator = ""
+	data, _ := json.Marshal(struct {
+		Params RenderParams
+		Width  int
+		Height int
+		Format string
+		Opts   EncodeOptions
+		Dither DitherMode
+	}{params, r.Width, r.Height, r.Format.Name, r.Opts, r.Dither})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// Renders the output image.
+func (r BatchRender) Render() (*FractImage, error) {
+	img, err := r.Params.Render(r.Width, r.Height)
+	if err != nil {
+		return nil, err
+	}
+	img.Dither = r.Dither
+	return img, nil
+}
+
+// Renders the output image, and writes it to its path: the file is replaced only when the image is complete.
+func (r BatchRender) RenderFile() error {
+	img, err := r.Render()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), os.ModePerm); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(r.Path), filepath.Base(r.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if err := r.Format.Encode(tmpFile, img, r.Opts); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), r.Path)
+}
+
+// a file name for the preset name, e.g. "mandelbrot-total" for "Mandelbrot Total"
+func presetFileName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "preset"
+	}
+	return b.String()
+}
+
+/*
+Returns a manifest rendering every fractal preset as a thumbnail of the given size and format into the output
+directory, named by the preset name (e.g. mandelbrot-total.jpg). The jobs contain the presets: presets with the
+same name are rendered each.
+*/
+func PresetsGalleryManifest(presets Presets, outputDir string, width, height int, format string) BatchManifest {
+	manifest := BatchManifest{OutputDir: outputDir, Defaults: BatchOutput{Width: width, Height: height, Format: format}}
+	extension := format
+	if f, err := GetImageFormat(format); err == nil {
+		extension = f.Extension()
+	}
+	used := make(map[string]int)
+	for _, fractalPreset := range presets.FractalPresets {
+		fileName := presetFileName(fractalPreset.Name)
+		// presets with the same name, or differing in special characters only:
+		if used[fileName]++; used[fileName] > 1 {
+			fileName = fmt.Sprintf("%s-%d", fileName, used[fileName])
+		}
+		manifest.Jobs = append(manifest.Jobs, BatchJob{
+			Name:        fractalPreset.Name,
+			Fractal:     &fractalPreset,
+			BatchOutput: BatchOutput{Path: fileName + "." + extension},
+		})
+	}
+	return manifest
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+/*
+A BMP encoder: opaque images are written with 24 bit per pixel, images with transparency with 32 bit
+(BGRA, non-premultiplied) and a BITMAPV4HEADER, which defines the alpha channel mask.
+The rows are stored bottom-up.
+*/
+
+const (
+	bmpFileHeaderSize = 14
+	bmpInfoHeaderSize = 40
+	bmpV4HeaderSize   = 108
+	bmpBitfields      = 3
+)
+
+func EncodeBmp(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 {
+		return errors.New("bmp: empty image")
+	}
+
+	opaque := true
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+	bytesPerPixel, headerSize := 3, bmpInfoHeaderSize
+	if !opaque {
+		bytesPerPixel, headerSize = 4, bmpV4HeaderSize
+	}
+	// rows are padded to 4 bytes:
+	rowSize := (width*bytesPerPixel + 3) &^ 3
+	dataOffset := bmpFileHeaderSize + headerSize
+	fileSize := dataOffset + rowSize*height
+
+	header := make([]byte, dataOffset)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[2:], uint32(fileSize))
+	binary.LittleEndian.PutUint32(header[10:], uint32(dataOffset))
+
+	info := header[bmpFileHeaderSize:]
+	binary.LittleEndian.PutUint32(info[0:], uint32(headerSize))
+	binary.LittleEndian.PutUint32(info[4:], uint32(width))
+	binary.LittleEndian.PutUint32(info[8:], uint32(height)) // positive height: bottom-up
+	binary.LittleEndian.PutUint16(info[12:], 1)             // planes
+	binary.LittleEndian.PutUint16(info[14:], uint16(bytesPerPixel*8))
+	binary.LittleEndian.PutUint32(info[20:], uint32(rowSize*height))
+	// 2835 pixels per meter = 72 dpi
+	binary.LittleEndian.PutUint32(info[24:], 2835)
+	binary.LittleEndian.PutUint32(info[28:], 2835)
+	if !opaque {
+		binary.LittleEndian.PutUint32(info[16:], bmpBitfields)
+		// red, green, blue and alpha masks:
+		binary.LittleEndian.PutUint32(info[40:], 0x00ff0000)
+		binary.LittleEndian.PutUint32(info[44:], 0x0000ff00)
+		binary.LittleEndian.PutUint32(info[48:], 0x000000ff)
+		binary.LittleEndian.PutUint32(info[52:], 0xff000000)
+		copy(info[56:], "BGRs") // sRGB color space, little-endian 'sRGB'
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	row := make([]byte, rowSize)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			offset := (x - bounds.Min.X) * bytesPerPixel
+			if opaque {
+				row[offset], row[offset+1], row[offset+2] = c.B, c.G, c.R
+			} else {
+				row[offset], row[offset+1], row[offset+2], row[offset+3] = c.B, c.G, c.R, c.A
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
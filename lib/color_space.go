@@ -0,0 +1,364 @@
+package lib
+
+import (
+	"image/color"
+	"math"
+)
+
+type ColorSpace string
+
+const (
+	COLOR_SPACE_RGB        = "rgb"
+	COLOR_SPACE_LINEAR_RGB = "linear-rgb"
+	COLOR_SPACE_HSL        = "hsl"
+	COLOR_SPACE_HSV        = "hsv"
+	COLOR_SPACE_LAB        = "lab"
+	COLOR_SPACE_OKLAB      = "oklab"
+	COLOR_SPACE_OKLCH      = "oklch"
+)
+
+type InterpolationCurve string
+
+const (
+	INTERPOLATION_CURVE_LINEAR     = "linear"
+	INTERPOLATION_CURVE_SMOOTHSTEP = "smoothstep"
+	INTERPOLATION_CURVE_SPLINE     = "spline"
+)
+
+// a color in one of the color spaces, with 3 components
+type colorComponents [3]float64
+
+/*
+Interpolates between the two palette colors lower and upper, at position t (0.0 = lower, 1.0 = upper).
+
+The interpolation is done in the given color space:
+
+  - rgb: linear interpolation of the sRGB byte values (the "classic" palette look)
+  - linear-rgb: interpolation of the linearized (gamma-decoded) sRGB values
+  - hsl, hsv: interpolation of hue, saturation and lightness / value, taking the shortest path around the hue circle
+  - lab: CIE L*a*b* (D65)
+  - oklab, oklch: the perceptual OKLab color space, in cartesian or polar (lightness, chroma, hue) form
+
+The curve defines the interpolation between the two colors:
+
+  - linear: constant speed
+  - smoothstep: eases in and out at each color stop
+  - spline: a Catmull-Rom spline through all color stops, using the stops before (prev) and after (next)
+    the two colors. This gives smooth transitions without visible kinks at the color stops.
+*/
+func interpolateColor(prev, lower, upper, next color.RGBA, t float64, space ColorSpace, curve InterpolationCurve) color.NRGBA64 {
+	if curve == INTERPOLATION_CURVE_SMOOTHSTEP {
+		t = t * t * (3 - 2*t)
+	}
+
+	if (space == "" || space == COLOR_SPACE_RGB) && curve != INTERPOLATION_CURVE_SPLINE {
+		// the palette colors are 8 bit, the interpolated colors 16 bit (1 = 257):
+		return color.NRGBA64{
+			R: uint16(math.Round((float64(lower.R) + (float64(upper.R)-float64(lower.R))*t) * 257)),
+			G: uint16(math.Round((float64(lower.G) + (float64(upper.G)-float64(lower.G))*t) * 257)),
+			B: uint16(math.Round((float64(lower.B) + (float64(upper.B)-float64(lower.B))*t) * 257)),
+			A: uint16(math.Round((float64(lower.A) + (float64(upper.A)-float64(lower.A))*t) * 257)),
+		}
+	}
+
+	hueIndex := hueComponentIndex(space)
+	c0 := toColorSpace(prev, space)
+	c1 := toColorSpace(lower, space)
+	c2 := toColorSpace(upper, space)
+	c3 := toColorSpace(next, space)
+	if hueIndex >= 0 {
+		// achromatic colors have no hue: use the one of the neighbour color
+		c1, c2 = fixAchromaticHue(c1, c2, space)
+		c0, _ = fixAchromaticHue(c0, c1, space)
+		c3, _ = fixAchromaticHue(c3, c2, space)
+
+		// take the shortest path around the hue circle:
+		c0[hueIndex] = nearestHue(c0[hueIndex], c1[hueIndex])
+		c2[hueIndex] = nearestHue(c2[hueIndex], c1[hueIndex])
+		c3[hueIndex] = nearestHue(c3[hueIndex], c2[hueIndex])
+	}
+
+	var res colorComponents
+	for i := range res {
+		if curve == INTERPOLATION_CURVE_SPLINE {
+			res[i] = catmullRom(c0[i], c1[i], c2[i], c3[i], t)
+		} else {
+			res[i] = c1[i] + (c2[i]-c1[i])*t
+		}
+	}
+	if hueIndex >= 0 {
+		res[hueIndex] = math.Mod(res[hueIndex]+360, 360)
+	}
+	result := fromColorSpace64(res, space)
+
+	// the alpha channel is independent of the color space:
+	var alpha float64
+	if curve == INTERPOLATION_CURVE_SPLINE {
+		alpha = catmullRom(float64(prev.A), float64(lower.A), float64(upper.A), float64(next.A), t)
+	} else {
+		alpha = float64(lower.A) + (float64(upper.A)-float64(lower.A))*t
+	}
+	result.A = toWord(alpha / 255)
+	return result
+}
+
+func catmullRom(p0, p1, p2, p3, t float64) float64 {
+	return 0.5 * (2*p1 + (-p0+p2)*t + (2*p0-5*p1+4*p2-p3)*t*t + (-p0+3*p1-3*p2+p3)*t*t*t)
+}
+
+// returns the hue value (in degrees) that is nearest to the reference hue, by adding / subtracting 360°
+func nearestHue(hue, reference float64) float64 {
+	for hue-reference > 180 {
+		hue -= 360
+	}
+	for reference-hue > 180 {
+		hue += 360
+	}
+	return hue
+}
+
+func hueComponentIndex(space ColorSpace) int {
+	switch space {
+	case COLOR_SPACE_HSL, COLOR_SPACE_HSV:
+		return 0
+	case COLOR_SPACE_OKLCH:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// if one of the colors has no saturation / chroma, its hue is undefined: it takes the hue of the other color
+func fixAchromaticHue(a, b colorComponents, space ColorSpace) (colorComponents, colorComponents) {
+	var hueIdx, chromaIdx = 0, 1
+	if space == COLOR_SPACE_OKLCH {
+		hueIdx, chromaIdx = 2, 1
+	}
+	if a[chromaIdx] < 1e-6 {
+		a[hueIdx] = b[hueIdx]
+	} else if b[chromaIdx] < 1e-6 {
+		b[hueIdx] = a[hueIdx]
+	}
+	return a, b
+}
+
+func toColorSpace(c color.RGBA, space ColorSpace) colorComponents {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	switch space {
+	case COLOR_SPACE_LINEAR_RGB:
+		return colorComponents{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)}
+	case COLOR_SPACE_HSL:
+		return rgbToHsl(r, g, b)
+	case COLOR_SPACE_HSV:
+		return rgbToHsv(r, g, b)
+	case COLOR_SPACE_LAB:
+		return linearRgbToLab(srgbToLinear(r), srgbToLinear(g), srgbToLinear(b))
+	case COLOR_SPACE_OKLAB:
+		return linearRgbToOklab(srgbToLinear(r), srgbToLinear(g), srgbToLinear(b))
+	case COLOR_SPACE_OKLCH:
+		lab := linearRgbToOklab(srgbToLinear(r), srgbToLinear(g), srgbToLinear(b))
+		return colorComponents{
+			lab[0],
+			math.Hypot(lab[1], lab[2]),
+			math.Mod(math.Atan2(lab[2], lab[1])*180/math.Pi+360, 360),
+		}
+	default:
+		return colorComponents{r, g, b}
+	}
+}
+
+func fromColorSpace(c colorComponents, space ColorSpace) color.RGBA {
+	rgb := colorSpaceToRgb(c, space)
+	return color.RGBA{R: toByte(rgb[0]), G: toByte(rgb[1]), B: toByte(rgb[2]), A: 255}
+}
+
+// same as fromColorSpace, with 16 bit precision
+func fromColorSpace64(c colorComponents, space ColorSpace) color.NRGBA64 {
+	rgb := colorSpaceToRgb(c, space)
+	return color.NRGBA64{R: toWord(rgb[0]), G: toWord(rgb[1]), B: toWord(rgb[2]), A: 0xffff}
+}
+
+// converts the color components to sRGB components (0.0 - 1.0)
+func colorSpaceToRgb(c colorComponents, space ColorSpace) colorComponents {
+	var rgb colorComponents
+	switch space {
+	case COLOR_SPACE_LINEAR_RGB:
+		rgb = colorComponents{linearToSrgb(c[0]), linearToSrgb(c[1]), linearToSrgb(c[2])}
+	case COLOR_SPACE_HSL:
+		rgb = hslToRgb(c)
+	case COLOR_SPACE_HSV:
+		rgb = hsvToRgb(c)
+	case COLOR_SPACE_LAB:
+		rgb = linearToSrgbComponents(labToLinearRgb(c))
+	case COLOR_SPACE_OKLAB:
+		rgb = linearToSrgbComponents(oklabToLinearRgb(c))
+	case COLOR_SPACE_OKLCH:
+		hue := c[2] * math.Pi / 180
+		rgb = linearToSrgbComponents(oklabToLinearRgb(colorComponents{c[0], c[1] * math.Cos(hue), c[1] * math.Sin(hue)}))
+	default:
+		rgb = c
+	}
+	return rgb
+}
+
+func toByte(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+func toWord(v float64) uint16 {
+	return uint16(math.Round(math.Max(0, math.Min(1, v)) * 0xffff))
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func linearToSrgbComponents(c colorComponents) colorComponents {
+	return colorComponents{linearToSrgb(c[0]), linearToSrgb(c[1]), linearToSrgb(c[2])}
+}
+
+// returns hue (0-360), saturation (0-1), lightness (0-1)
+func rgbToHsl(r, g, b float64) colorComponents {
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	l := (maxC + minC) / 2
+	d := maxC - minC
+	if d == 0 {
+		return colorComponents{0, 0, l}
+	}
+	s := d / (1 - math.Abs(2*l-1))
+	return colorComponents{rgbHue(r, g, b, maxC, d), s, l}
+}
+
+func hslToRgb(c colorComponents) colorComponents {
+	h, s, l := c[0], c[1], c[2]
+	chroma := (1 - math.Abs(2*l-1)) * s
+	return hueToRgb(h, chroma, l-chroma/2)
+}
+
+// returns hue (0-360), saturation (0-1), value (0-1)
+func rgbToHsv(r, g, b float64) colorComponents {
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	d := maxC - minC
+	if d == 0 {
+		return colorComponents{0, 0, maxC}
+	}
+	return colorComponents{rgbHue(r, g, b, maxC, d), d / maxC, maxC}
+}
+
+func hsvToRgb(c colorComponents) colorComponents {
+	h, s, v := c[0], c[1], c[2]
+	chroma := v * s
+	return hueToRgb(h, chroma, v-chroma)
+}
+
+func rgbHue(r, g, b, maxC, d float64) float64 {
+	var h float64
+	switch maxC {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return math.Mod(h*60+360, 360)
+}
+
+// converts a hue / chroma pair to rgb, adding m to all components
+func hueToRgb(h, chroma, m float64) colorComponents {
+	hp := math.Mod(h, 360) / 60
+	x := chroma * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g, b = chroma, x, 0
+	case hp < 2:
+		r, g, b = x, chroma, 0
+	case hp < 3:
+		r, g, b = 0, chroma, x
+	case hp < 4:
+		r, g, b = 0, x, chroma
+	case hp < 5:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return colorComponents{r + m, g + m, b + m}
+}
+
+// CIE L*a*b* with D65 white point
+const labDelta = 6.0 / 29.0
+
+var labWhite = colorComponents{0.95047, 1.0, 1.08883}
+
+func labF(t float64) float64 {
+	if t > labDelta*labDelta*labDelta {
+		return math.Cbrt(t)
+	}
+	return t/(3*labDelta*labDelta) + 4.0/29.0
+}
+
+func labFInv(t float64) float64 {
+	if t > labDelta {
+		return t * t * t
+	}
+	return 3 * labDelta * labDelta * (t - 4.0/29.0)
+}
+
+func linearRgbToLab(r, g, b float64) colorComponents {
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / labWhite[0]
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / labWhite[1]
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / labWhite[2]
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return colorComponents{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func labToLinearRgb(c colorComponents) colorComponents {
+	fy := (c[0] + 16) / 116
+	fx := fy + c[1]/500
+	fz := fy - c[2]/200
+	x := labFInv(fx) * labWhite[0]
+	y := labFInv(fy) * labWhite[1]
+	z := labFInv(fz) * labWhite[2]
+	return colorComponents{
+		3.2404542*x - 1.5371385*y - 0.4985314*z,
+		-0.9692660*x + 1.8760108*y + 0.0415560*z,
+		0.0556434*x - 0.2040259*y + 1.0572252*z,
+	}
+}
+
+// OKLab, see https://bottosson.github.io/posts/oklab/
+func linearRgbToOklab(r, g, b float64) colorComponents {
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	return colorComponents{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+func oklabToLinearRgb(c colorComponents) colorComponents {
+	l := c[0] + 0.3963377774*c[1] + 0.2158037573*c[2]
+	m := c[0] - 0.1055613458*c[1] - 0.0638541728*c[2]
+	s := c[0] - 0.0894841775*c[1] - 1.2914855480*c[2]
+	l, m, s = l*l*l, m*m*m, s*s*s
+	return colorComponents{
+		4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		-1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		-0.0041960863*l - 0.7034186147*m + 1.7076147010*s,
+	}
+}
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+type ColoringAlgorithm string
+
+const (
+	COLORING_ALGORITHM_ESCAPE_TIME          = "escape-time"
+	COLORING_ALGORITHM_SMOOTH               = "smooth"
+	COLORING_ALGORITHM_TRIANGLE_INEQUALITY  = "triangle-inequality"
+	COLORING_ALGORITHM_CURVATURE            = "curvature"
+	COLORING_ALGORITHM_STRIPE               = "stripe"
+	COLORING_ALGORITHM_BINARY_DECOMPOSITION = "binary-decomposition"
+	COLORING_ALGORITHM_FIELD_LINES          = "field-lines"
+	COLORING_ALGORITHM_FINAL_ANGLE          = "final-angle"
+)
+
+type BlendMode string
+
+const (
+	BLEND_MODE_MIX        = "mix"
+	BLEND_MODE_ADD        = "add"
+	BLEND_MODE_MULTIPLY   = "multiply"
+	BLEND_MODE_SCREEN     = "screen"
+	BLEND_MODE_DIFFERENCE = "difference"
+)
+
+// the average of a value over all orbit points. The average without the last orbit point is kept, too,
+// to smoothly interpolate between the two, see Smooth().
+type OrbitAverage struct {
+	Last float64
+	Prev float64
+}
+
+// Interpolates between the last two averages, by the fractional part of the smooth iteration count.
+// This removes the iteration bands of the average coloring algorithms.
+func (a OrbitAverage) Smooth(frac float64) float64 {
+	return a.Prev + (a.Last-a.Prev)*frac
+}
+
+type orbitAverageSum struct {
+	sum float64
+	n   int
+	avg OrbitAverage
+}
+
+func (s *orbitAverageSum) add(value float64) {
+	s.sum += value
+	s.n++
+	s.avg.Prev = s.avg.Last
+	s.avg.Last = s.sum / float64(s.n)
+}
+
+// returns true if one of the two coloring algorithms is the given one
+func (f CommonFractParams) usesColoringAlgorithm(algorithm ColoringAlgorithm) bool {
+	return f.ColoringAlgorithm == algorithm || f.ColoringAlgorithm2 == algorithm
+}
+
+/*
+Calculates the coloring value for an escaped point, which is then mapped onto the color palette.
+
+If a second coloring algorithm is given, both values are normalized to 0.0 - 1.0 and
+combined using the blend mode:
+
+  - mix: linear interpolation by the blend factor
+  - add: adds the second value, weighted by the blend factor, wrapping around at 1.0
+  - multiply, screen, difference: like the corresponding layer blend modes in image editors
+*/
+func coloringValue(fractParams CommonFractParams, fractRes FractFunctionResult) float64 {
+	value := coloringAlgorithmValue(fractParams.ColoringAlgorithm, fractParams, fractRes)
+	if fractParams.ColoringAlgorithm2 == "" {
+		return value
+	}
+
+	maxIterations := float64(fractParams.MaxIterations)
+	v1 := value / maxIterations
+	v2 := coloringAlgorithmValue(fractParams.ColoringAlgorithm2, fractParams, fractRes) / maxIterations
+	factor := *fractParams.ColoringBlendFactor
+
+	var blended float64
+	switch fractParams.ColoringBlendMode {
+	case BLEND_MODE_ADD:
+		blended = math.Mod(v1+v2*factor, 1.0)
+	case BLEND_MODE_MULTIPLY:
+		blended = v1 * v2
+	case BLEND_MODE_SCREEN:
+		blended = 1 - (1-v1)*(1-v2)
+	case BLEND_MODE_DIFFERENCE:
+		blended = math.Abs(v1 - v2)
+	default:
+		blended = v1*(1-factor) + v2*factor
+	}
+	return blended * maxIterations
+}
+
+// calculates the value (0 - max iterations) of a single coloring algorithm
+func coloringAlgorithmValue(algorithm ColoringAlgorithm, fractParams CommonFractParams, fractRes FractFunctionResult) float64 {
+	var LOG_2 float64 = math.Log(2)
+	var LOG_MAX_BETRAG = math.Log(fractParams.MaxAbsSquareAmount)
+	var maxIterations = float64(fractParams.MaxIterations)
+
+	// normalized iteration count: the fractional part is used to smooth the average algorithms, too.
+	// see http://de.wikipedia.org/wiki/Mandelbrot-Menge#Iteration_eines_Bildpunktes:
+	var nu = math.Log(math.Log(fractRes.BailoutValue)/LOG_MAX_BETRAG) / LOG_2
+	var frac = math.Max(0, math.Min(1, 1-nu))
+
+	switch algorithm {
+	case COLORING_ALGORITHM_ESCAPE_TIME:
+		// Rough coloring: Escape time algorithm:
+		return float64(fractRes.Iterations)
+	case COLORING_ALGORITHM_TRIANGLE_INEQUALITY:
+		return fractRes.TriangleAverage.Smooth(frac) * maxIterations
+	case COLORING_ALGORITHM_CURVATURE:
+		return fractRes.CurvatureAverage.Smooth(frac) * maxIterations
+	case COLORING_ALGORITHM_STRIPE:
+		return fractRes.StripeAverage.Smooth(frac) * maxIterations
+	case COLORING_ALGORITHM_BINARY_DECOMPOSITION:
+		// the sign of the final z's imaginary part divides each iteration band into two halfs:
+		if imag(fractRes.FinalZ) >= 0 {
+			return 0
+		}
+		return maxIterations / 2
+	case COLORING_ALGORITHM_FIELD_LINES:
+		// lines where the final angle is a multiple of 2*PI / density:
+		angle := cmplx.Phase(fractRes.FinalZ)
+		return math.Abs(math.Sin(angle*fractParams.ColoringDensity/2)) * maxIterations
+	case COLORING_ALGORITHM_FINAL_ANGLE:
+		return (cmplx.Phase(fractRes.FinalZ) + math.Pi) / (2 * math.Pi) * maxIterations
+	default:
+		// Smooth coloring / normalized iteration count:
+		return float64(fractRes.Iterations) - nu
+	}
+}
+
+// adds the values for the average coloring algorithms for the orbit point z, with
+// the previous orbit points prevZ and prevPrevZ
+func (t *OrbitTracker) addAverages(z, prevZ, prevPrevZ complex128) {
+	if t.trackTriangle {
+		// triangle inequality: |z^d| - |c| <= |z^d + c| <= |z^d| + |c|
+		zPow := cmplx.Abs(complexPow(prevZ, t.power))
+		absC := cmplx.Abs(t.c)
+		lower := math.Abs(zPow - absC)
+		upper := zPow + absC
+		if upper-lower > 0 {
+			t.triangleAverage.add((cmplx.Abs(z) - lower) / (upper - lower))
+		}
+	}
+	if t.trackCurvature && prevZ != prevPrevZ && z != prevZ {
+		t.curvatureAverage.add(math.Abs(cmplx.Phase((z-prevZ)/(prevZ-prevPrevZ))) / math.Pi)
+	}
+	if t.trackStripe {
+		t.stripeAverage.add(0.5*math.Sin(t.stripeDensity*cmplx.Phase(z)) + 0.5)
+	}
+}
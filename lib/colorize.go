@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"encoding/json"
 	"image/color"
 	"math"
 )
@@ -10,31 +11,68 @@ type PaletteEntry struct {
 	Steps int `json:"steps"`
 }
 
+/*
+Decodes a palette entry: an object with the R, G, B, A components and the steps, or a CSS color (see ParseCssColor)
+as compact notation: "#ff8800", "rgb(255, 136, 0)", or {"color": "#ff8800", "steps": 128} with steps.
+*/
+func (e *PaletteEntry) UnmarshalJSON(data []byte) error {
+	var cssColor string
+	if err := json.Unmarshal(data, &cssColor); err == nil {
+		c, err := ParseCssColor(cssColor)
+		*e = PaletteEntry{RGBA: c}
+		return err
+	}
+	// without the UnmarshalJSON method:
+	type paletteEntry PaletteEntry
+	var entry struct {
+		paletteEntry
+		Color string `json:"color"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	*e = PaletteEntry(entry.paletteEntry)
+	if entry.Color != "" {
+		c, err := ParseCssColor(entry.Color)
+		if err != nil {
+			return err
+		}
+		e.RGBA = c
+	}
+	return nil
+}
+
 type ColorPalette []PaletteEntry
 
 const defaultPaletteLength = 256
 
+var blackColor = color.RGBA{R: 0, G: 0, B: 0, A: 255}
+
 func setImagePixel(img *FractImage, x, y int, fractParams CommonFractParams, fractRes FractFunctionResult) {
 	// calc the color for this pixel:
-	var LOG_2 float64 = math.Log(2)
-	var LOG_MAX_BETRAG = math.Log(fractParams.MaxAbsSquareAmount)
-	// var LOG_4 float64 = math.Log(4)
+	if fractRes.Interior {
+		setInteriorColor(img, x, y, fractParams, fractRes)
+		return
+	}
 
 	var iterValue float64
-	if fractRes.Iterations <= fractParams.MaxIterations {
-		if fractParams.SmoothColors == true {
-			// Smooth coloring, see http://de.wikipedia.org/wiki/Mandelbrot-Menge#Iteration_eines_Bildpunktes:
-			iterValue = float64(fractRes.Iterations) - math.Log(math.Log(fractRes.BailoutValue)/LOG_MAX_BETRAG)/LOG_2
-			// for orbit trap:
-			// iterValue = fractRes.BailoutValue
-			// fractParams.ColorPaletteLength = -1
-			// fractParams.MaxIterations = 4
-		} else {
-			// Rough coloring: Escape time algorithm:
-			iterValue = float64(fractRes.Iterations)
-		}
+	if fractParams.DistanceMode == DISTANCE_MODE_COLOR {
+		iterValue = distanceIterValue(fractParams, fractRes)
+	} else {
+		iterValue = coloringValue(fractParams, fractRes)
+		// for orbit trap:
+		// iterValue = fractRes.BailoutValue
+		// fractParams.ColorPaletteLength = -1
+		// fractParams.MaxIterations = 4
 	}
 
+	if img.deferredValues != nil {
+		// global palette mapping: colored later, when all values are known
+		if idx, ok := img.deferredIndex(x, y); ok {
+			img.deferredValues[idx] = float32(iterValue)
+		}
+		return
+	}
 	SetPaletteColor(img, x, y, iterValue, fractParams, fractRes)
 }
 
@@ -50,7 +88,7 @@ func SetPaletteColor(img *FractImage, x, y int, iterValue float64, fractParams C
 	}
 
 	// Default: If the iteration count exceeds the max iterations, set the pixel color to black
-	var selectedColor PaletteEntry = PaletteEntry{color.RGBA{R: 0, G: 0, B: 0, A: 255}, 255}
+	var selectedColor color.Color = blackColor
 	var repeat = 1
 	var maxIterations = float64(fractParams.MaxIterations)
 	if fractParams.ColorPaletteRepeat > 0 {
@@ -129,7 +167,7 @@ func SetPaletteColor(img *FractImage, x, y int, iterValue float64, fractParams C
 		}
 
 		// Find the two anchor colors that the current iteration count is between
-		ratio := iterValue / float64(maxIterations)
+		ratio := mapPaletteRatio(iterValue/float64(maxIterations), maxIterations, repeat, fractParams)
 		paletteEntry := ratio * float64(paletteLength) // Position within the full palette
 
 		// colorPaletteSectionWidth := float64(maxIterations) / float64(paletteLength)
@@ -139,6 +177,7 @@ func SetPaletteColor(img *FractImage, x, y int, iterValue float64, fractParams C
 		var stopsUntilNow = 0
 		var upperStop = 0
 		var actStopWidth = 0
+		var lowerIndex = 0
 		lower = palette[0]
 		upper = palette[1%nrOfColors]
 		for i, entry := range colorStopLengths {
@@ -148,25 +187,27 @@ func SetPaletteColor(img *FractImage, x, y int, iterValue float64, fractParams C
 				stopsUntilNow = upperStop
 				continue
 			} else {
-				lower = palette[i%nrOfColors]
+				lowerIndex = i % nrOfColors
+				lower = palette[lowerIndex]
 				upper = palette[(i+1)%nrOfColors]
 				break
 			}
 		}
 
 		if fractParams.ColorPaletteHardStops {
-			selectedColor = lower
+			selectedColor = color.NRGBA(lower.RGBA)
 		} else {
-			// Linear-interpolate the correct color based on the ratio
+			// Interpolate the correct color based on the ratio, in the palette's color space.
+			// The color stops before and after are needed for the spline interpolation.
 			relativeRatio := (paletteEntry - float64(stopsUntilNow)) / float64(actStopWidth)
-			selectedColor = PaletteEntry{color.RGBA{
-				R: uint8(math.Round(float64(lower.R) + (float64(upper.R)-float64(lower.R))*relativeRatio)),
-				G: uint8(math.Round(float64(lower.G) + (float64(upper.G)-float64(lower.G))*relativeRatio)),
-				B: uint8(math.Round(float64(lower.B) + (float64(upper.B)-float64(lower.B))*relativeRatio)),
-				A: 255,
-			}, 255}
+			prev := palette[(lowerIndex-1+nrOfColors)%nrOfColors]
+			next := palette[(lowerIndex+2)%nrOfColors]
+			selectedColor = interpolateColor(
+				prev.RGBA, lower.RGBA, upper.RGBA, next.RGBA, relativeRatio,
+				fractParams.ColorPaletteSpace, fractParams.ColorPaletteCurve,
+			)
 		}
 	}
-	// Set the pixel color
-	img.Set(x, y, selectedColor.RGBA)
+	// Set the pixel color: the palette colors are not alpha-premultiplied, in contrast to color.RGBA
+	img.Set(x, y, selectedColor)
 }
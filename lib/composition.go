@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+type LayerBlendMode string
+
+const (
+	LAYER_BLEND_MODE_ALPHA    = "alpha"
+	LAYER_BLEND_MODE_MULTIPLY = "multiply"
+	LAYER_BLEND_MODE_SCREEN   = "screen"
+	LAYER_BLEND_MODE_OVERLAY  = "overlay"
+	LAYER_BLEND_MODE_ADD      = "add"
+)
+
+type CompositionPresets []CompositionPreset
+
+func (p CompositionPresets) GetByName(name string) (CompositionPreset, error) {
+	name = strings.ToLower(name)
+	for _, preset := range p {
+		if strings.ToLower(preset.Name) == name {
+			return preset, nil
+		}
+	}
+	return CompositionPreset{}, errors.New("no composition preset found")
+}
+
+// A composition combines the renders of several fractal presets (the layers) into one image.
+type CompositionPreset struct {
+	Name string `json:"name"`
+	// CSS color the layers are composited on, defaults to transparent
+	Background string             `json:"background,omitempty"`
+	Layers     []CompositionLayer `json:"layers"`
+}
+
+type CompositionLayer struct {
+	// name of the fractal preset to render for this layer
+	FractalPreset string `json:"fractalPreset"`
+	// how the layer is combined with the layers below: alpha (default), multiply, screen, overlay, add
+	BlendMode string `json:"blendMode,omitempty"`
+	// opacity of the layer, 0.0 (transparent) - 1.0. Fully opaque if not set.
+	Opacity *float64 `json:"opacity,omitempty"`
+	// optional mask: the layer is only visible where the mask is
+	Mask *CompositionMask `json:"mask,omitempty"`
+}
+
+// A mask is the render of a fractal preset: its luminance (times its alpha) is the visibility of the layer,
+// e.g. a black and white palette with a white interior color only shows the layer inside the set.
+type CompositionMask struct {
+	FractalPreset string `json:"fractalPreset"`
+	Invert        bool   `json:"invert,omitempty"`
+}
+
+/*
+Renders the composition's layers with the given image size, and composites them, bottom (first) to top (last layer).
+
+Each layer is blended with the layers below with its blend mode (see blendChannel), then composited over
+them with the layer's alpha, multiplied by the opacity and the mask (see the W3C "Compositing and Blending" spec):
+
+	color = (1 - alpha_backdrop) * color_layer + alpha_backdrop * blend(color_backdrop, color_layer)
+	result = alpha * color + (1 - alpha) * color_backdrop
+*/
+func CalcCompositionImage(width, height int, presets Presets, composition CompositionPreset) (*FractImage, error) {
+	return CalcCompositionImageContext(context.Background(), width, height, presets, composition, nil)
+}
+
+/*
+Renders the composition like CalcCompositionImage, reporting the progress of the layer renders (may be nil).
+If the context is canceled, the rendering stops, and the context's error is returned.
+*/
+func CalcCompositionImageContext(ctx context.Context, width, height int, presets Presets, composition CompositionPreset, progress ProgressFunc) (*FractImage, error) {
+	if len(composition.Layers) == 0 {
+		return nil, fmt.Errorf("composition '%s' has no layers", composition.Name)
+	}
+
+	// the composited image, as non-premultiplied r, g, b, a values (0.0 - 1.0):
+	pixels := make([][4]float64, width*height)
+	if composition.Background != "" {
+		bg, err := ParseCssColor(composition.Background)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pixels {
+			pixels[i] = nrgbaComponents(color.NRGBA64Model.Convert(color.NRGBA(bg)).(color.NRGBA64))
+		}
+	}
+
+	// the progress of all layer and mask renders, each counting as 1000 steps:
+	nrOfRenders, rendered := 0, 0
+	for _, layer := range composition.Layers {
+		nrOfRenders++
+		if layer.Mask != nil {
+			nrOfRenders++
+		}
+	}
+	renderProgress := func(done, total int) {
+		if progress != nil {
+			progress(rendered*1000+done*1000/total, nrOfRenders*1000)
+		}
+	}
+
+	for i, layer := range composition.Layers {
+		layerImg, err := renderFractalPreset(ctx, width, height, presets, layer.FractalPreset, renderProgress)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i+1, err)
+		}
+		rendered++
+		var maskImg *FractImage
+		if layer.Mask != nil {
+			maskImg, err = renderFractalPreset(ctx, width, height, presets, layer.Mask.FractalPreset, renderProgress)
+			if err != nil {
+				return nil, fmt.Errorf("layer %d mask: %w", i+1, err)
+			}
+			rendered++
+		}
+		blendMode := LayerBlendMode(strings.ToLower(layer.BlendMode))
+		switch blendMode {
+		case "":
+			blendMode = LAYER_BLEND_MODE_ALPHA
+		case LAYER_BLEND_MODE_ALPHA, LAYER_BLEND_MODE_MULTIPLY, LAYER_BLEND_MODE_SCREEN, LAYER_BLEND_MODE_OVERLAY, LAYER_BLEND_MODE_ADD:
+		default:
+			return nil, fmt.Errorf("layer %d: unknown blend mode '%s'", i+1, layer.BlendMode)
+		}
+		opacity := 1.0
+		if layer.Opacity != nil {
+			opacity = min(max(*layer.Opacity, 0), 1)
+		}
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				src := nrgbaComponents(color.NRGBA64Model.Convert(layerImg.At(x, y)).(color.NRGBA64))
+				alpha := src[3] * opacity
+				if maskImg != nil {
+					alpha *= maskValue(maskImg, x, y, layer.Mask.Invert)
+				}
+				if alpha == 0 {
+					continue
+				}
+				pixels[y*width+x] = compositePixel(pixels[y*width+x], src, alpha, blendMode)
+			}
+		}
+	}
+
+	img := NewFractImage(width, height)
+	renderParams, err := NewCompositionRenderParams(width, height, presets, composition)
+	if err != nil {
+		return nil, err
+	}
+	img.RenderParams = &renderParams
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := pixels[y*width+x]
+			img.Set(x, y, color.NRGBA64{toWord(p[0]), toWord(p[1]), toWord(p[2]), toWord(p[3])})
+		}
+	}
+	return img, nil
+}
+
+func renderFractalPreset(ctx context.Context, width, height int, presets Presets, name string, progress ProgressFunc) (*FractImage, error) {
+	fractalPreset, err := presets.FractalPresets.GetByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, name)
+	}
+	fractal, err := NewFractalFromPresets(width, height, presets.ColorPresets, fractalPreset)
+	if err != nil {
+		return nil, err
+	}
+	return CalcFractalImageContext(ctx, fractal, progress)
+}
+
+// the mask's visibility at the given pixel: luminance times alpha (0.0 - 1.0)
+func maskValue(mask *FractImage, x, y int, invert bool) float64 {
+	c := nrgbaComponents(color.NRGBA64Model.Convert(mask.At(x, y)).(color.NRGBA64))
+	value := (0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]) * c[3]
+	if invert {
+		return 1 - value
+	}
+	return value
+}
+
+func compositePixel(backdrop, src [4]float64, alpha float64, blendMode LayerBlendMode) [4]float64 {
+	var result [4]float64
+	result[3] = alpha + backdrop[3]*(1-alpha)
+	if result[3] == 0 {
+		return result
+	}
+	for ch := 0; ch < 3; ch++ {
+		blended := (1-backdrop[3])*src[ch] + backdrop[3]*blendChannel(backdrop[ch], src[ch], blendMode)
+		// source-over, with non-premultiplied colors:
+		result[ch] = (alpha*blended + backdrop[ch]*backdrop[3]*(1-alpha)) / result[3]
+	}
+	return result
+}
+
+/*
+Blends a color channel of the layer (src) with the backdrop (0.0 - 1.0):
+
+  - alpha: the layer's color
+  - multiply: backdrop * src, darkens
+  - screen: 1 - (1 - backdrop) * (1 - src), lightens
+  - overlay: multiply or screen, depending on the backdrop: keeps the backdrop's highlights and shadows
+  - add: backdrop + src, clipped to 1.0
+*/
+func blendChannel(backdrop, src float64, blendMode LayerBlendMode) float64 {
+	switch blendMode {
+	case LAYER_BLEND_MODE_MULTIPLY:
+		return backdrop * src
+	case LAYER_BLEND_MODE_SCREEN:
+		return backdrop + src - backdrop*src
+	case LAYER_BLEND_MODE_OVERLAY:
+		if backdrop <= 0.5 {
+			return 2 * backdrop * src
+		}
+		return 1 - 2*(1-backdrop)*(1-src)
+	case LAYER_BLEND_MODE_ADD:
+		return math.Min(1, backdrop+src)
+	default:
+		return src
+	}
+}
+
+func nrgbaComponents(c color.NRGBA64) [4]float64 {
+	return [4]float64{float64(c.R) / 0xffff, float64(c.G) / 0xffff, float64(c.B) / 0xffff, float64(c.A) / 0xffff}
+}
@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"runtime"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// A cell of a contact sheet: a fractal, with the caption printed below it.
+type ContactSheetCell struct {
+	Caption string
+	Fractal FractalPreset
+}
+
+// The layout of a contact sheet.
+type ContactSheetOptions struct {
+	// the size of the fractal renders, in pixels
+	CellWidth  int
+	CellHeight int
+	// the number of columns, 0 for a sheet as square as possible
+	Columns int
+	// the space between the cells and around the sheet, in pixels
+	Gap int
+	// print the captions below the cells
+	Captions   bool
+	Background color.Color
+	// the color of the captions, defaults to light gray
+	TextColor color.Color
+}
+
+// the cells of all fractal presets, captioned with their names
+func FractalPresetsContactSheet(presets Presets) []ContactSheetCell {
+	cells := make([]ContactSheetCell, len(presets.FractalPresets))
+	for i, fractalPreset := range presets.FractalPresets {
+		cells[i] = ContactSheetCell{Caption: fractalPreset.Name, Fractal: fractalPreset}
+	}
+	return cells
+}
+
+// the cells of the fractal preset rendered with each color preset, captioned with the names of the color presets
+func ColorPresetsContactSheet(presets Presets, fractalPreset FractalPreset) []ContactSheetCell {
+	cells := make([]ContactSheetCell, len(presets.ColorPresets))
+	for i, colorPreset := range presets.ColorPresets {
+		cells[i] = ContactSheetCell{Caption: colorPreset.Name, Fractal: fractalPreset}
+		cells[i].Fractal.ColorPreset = colorPreset.Ident
+	}
+	return cells
+}
+
+// the number of columns and rows of the sheet
+func (o ContactSheetOptions) grid(nrOfCells int) (int, int) {
+	columns := o.Columns
+	if columns <= 0 {
+		// about as many columns as rows, by the sheet's size:
+		columns = int(math.Ceil(math.Sqrt(float64(nrOfCells) * float64(o.CellHeight+o.captionHeight()) / float64(o.CellWidth))))
+	}
+	columns = max(1, min(columns, nrOfCells))
+	return columns, (nrOfCells + columns - 1) / columns
+}
+
+// the font size of the captions, by the cell width
+func (o ContactSheetOptions) fontSize() float64 {
+	return max(9, min(18, float64(o.CellWidth)/14))
+}
+
+func (o ContactSheetOptions) captionHeight() int {
+	if !o.Captions {
+		return 0
+	}
+	return int(math.Ceil(o.fontSize() * 1.8))
+}
+
+// Returns the size of the contact sheet of the given number of cells.
+func (o ContactSheetOptions) Size(nrOfCells int) (int, int) {
+	columns, rows := o.grid(nrOfCells)
+	return columns*(o.CellWidth+o.Gap) + o.Gap, rows*(o.CellHeight+o.captionHeight()+o.Gap) + o.Gap
+}
+
+/*
+Renders the cells into a grid image, in rows from the top left, with the caption below each cell. The cells are
+rendered in parallel, reporting the progress in rendered cells (may be nil). If the context is canceled, the rendering
+stops, and the context's error is returned.
+*/
+func CalcContactSheetImage(ctx context.Context, colorPresets ColorPresets, cells []ContactSheetCell, opts ContactSheetOptions, progress ProgressFunc) (*FractImage, error) {
+	if len(cells) == 0 {
+		return nil, errors.New("the contact sheet has no cells")
+	}
+	if opts.CellWidth <= 0 || opts.CellHeight <= 0 {
+		return nil, errors.New("invalid cell size")
+	}
+	fractals := make([]Fractal, len(cells))
+	for i, cell := range cells {
+		fractal, err := NewFractalFromPresets(opts.CellWidth, opts.CellHeight, colorPresets, cell.Fractal)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cell.Caption, err)
+		}
+		fractals[i] = fractal
+	}
+
+	width, height := opts.Size(len(cells))
+	sheet := NewFractImage(width, height)
+	if opts.Background != nil {
+		draw.Draw(sheet.RGBA64, sheet.Rect, image.NewUniform(opts.Background), image.Point{}, draw.Src)
+	}
+	columns, _ := opts.grid(len(cells))
+	cellOrigin := func(i int) image.Point {
+		return image.Point{
+			X: opts.Gap + (i%columns)*(opts.CellWidth+opts.Gap),
+			Y: opts.Gap + (i/columns)*(opts.CellHeight+opts.captionHeight()+opts.Gap),
+		}
+	}
+
+	var done int
+	err := renderFractals(ctx, fractals, func(i int, img *FractImage) {
+		origin := cellOrigin(i)
+		draw.Draw(sheet.RGBA64, image.Rectangle{Min: origin, Max: origin.Add(img.Rect.Size())}, img.RGBA64, image.Point{}, draw.Over)
+		done++
+		if progress != nil {
+			progress(done, len(cells))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Captions {
+		if err := drawCaptions(sheet, cells, opts, cellOrigin); err != nil {
+			return nil, err
+		}
+	}
+	return sheet, nil
+}
+
+/*
+Renders the fractals, and passes each image to the done function (called by one goroutine at a time). Each render
+uses all CPUs for its blocks, but small images have few blocks: several fractals are rendered at once.
+*/
+func renderFractals(ctx context.Context, fractals []Fractal, done func(i int, img *FractImage)) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var renderErr error
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i, fractal := range fractals {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			img, err := CalcFractalImageContext(ctx, fractal, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				renderErr = err
+				return
+			}
+			done(i, img)
+		}()
+	}
+	wg.Wait()
+	return renderErr
+}
+
+// prints the captions centered below the cells, shortened with an ellipsis if they are wider than the cells
+func drawCaptions(sheet *FractImage, cells []ContactSheetCell, opts ContactSheetOptions, cellOrigin func(i int) image.Point) error {
+	goRegular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return err
+	}
+	face, err := opentype.NewFace(goRegular, &opentype.FaceOptions{Size: opts.fontSize(), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+	textColor := opts.TextColor
+	if textColor == nil {
+		textColor = color.Gray{Y: 0xe0}
+	}
+
+	drawer := font.Drawer{Dst: sheet.RGBA64, Src: image.NewUniform(textColor), Face: face}
+	maxWidth := fixed.I(opts.CellWidth)
+	for i, cell := range cells {
+		caption := []rune(cell.Caption)
+		text := string(caption)
+		for len(caption) > 0 && drawer.MeasureString(text) > maxWidth {
+			caption = caption[:len(caption)-1]
+			text = string(caption) + "…"
+		}
+		origin := cellOrigin(i)
+		metrics := face.Metrics()
+		// centered horizontally, and vertically in the caption area:
+		baseline := fixed.I(origin.Y+opts.CellHeight) + (fixed.I(opts.captionHeight())+metrics.Ascent-metrics.Descent)/2
+		drawer.Dot = fixed.Point26_6{X: fixed.I(origin.X) + (maxWidth-drawer.MeasureString(text))/2, Y: baseline}
+		drawer.DrawString(text)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"image/color"
+	"math"
+	"math/cmplx"
+)
+
+type DistanceMode string
+
+const (
+	DISTANCE_MODE_OFF     = "off"
+	DISTANCE_MODE_OUTLINE = "outline"
+	DISTANCE_MODE_COLOR   = "color"
+	DISTANCE_MODE_SHADING = "shading"
+)
+
+/*
+Calculates the exterior distance estimation for an escaped point.
+
+With the derivative z' of the orbit (dz/dc for the Mandelbrot set, dz/dz0 for julia sets), the distance
+from the point to the border of the set can be estimated as:
+
+	d = |z| * ln|z| / |z'|
+
+The distance is given in fractal units, not in pixels.
+
+In addition, the normal vector u = z / z' (normalized to length 1) is calculated: it points away from
+the set's border and is used for the slope / normal map lighting.
+*/
+func calcDistanceEstimate(result *FractFunctionResult, z, dz complex128) {
+	if result.Interior || dz == 0 {
+		return
+	}
+	absZ := cmplx.Abs(z)
+	result.DistanceEstimate = absZ * math.Log(absZ) / cmplx.Abs(dz)
+
+	u := z / dz
+	if absU := cmplx.Abs(u); absU > 0 {
+		result.Normal = u / complex(absU, 0)
+	}
+}
+
+// calculates the (smooth) iteration value for the distance coloring: the palette is mapped
+// logarithmically onto the distance to the border, measured in pixels.
+func distanceIterValue(fractParams CommonFractParams, fractRes FractFunctionResult) float64 {
+	pixelDist := fractRes.DistanceEstimate / fractParams.PixelSize()
+	ratio := math.Log2(1+pixelDist) / math.Log2(1+float64(fractParams.ImageWidth))
+	return math.Min(ratio, 1.0) * float64(fractParams.MaxIterations)
+}
+
+/*
+The shading stage: called after setImagePixel, it modifies the already colored pixel using the
+distance estimation:
+
+  - outline: exterior pixels nearer than the outline width (in pixels) to the border are painted black,
+    which results in crisp outlines of the set, even for the thin filaments.
+  - shading: the pixel's color is multiplied by the reflection of a light source with a given
+    angle (direction in the complex plane, in degrees) and height over the plane, using the normal vector:
+    this gives the fractal a 3D-like look.
+*/
+func shadeImagePixel(img *FractImage, x, y int, fractParams CommonFractParams, fractRes FractFunctionResult) {
+	if fractRes.Interior {
+		return
+	}
+
+	var shade float64 = 1
+	switch fractParams.DistanceMode {
+	case DISTANCE_MODE_OUTLINE:
+		if fractRes.DistanceEstimate < fractParams.DistanceOutlineWidth*fractParams.PixelSize() {
+			shade = 0
+		}
+	case DISTANCE_MODE_SHADING:
+		angle := *fractParams.LightAngle * math.Pi / 180.0
+		light := complex(math.Cos(angle), math.Sin(angle))
+		height := fractParams.LightHeight
+
+		reflection := (real(fractRes.Normal)*real(light) + imag(fractRes.Normal)*imag(light) + height) / (1 + height)
+		shade = math.Max(reflection, 0)
+	}
+	if shade == 1 {
+		return
+	}
+
+	if img.deferredShades != nil {
+		if idx, ok := img.deferredIndex(x, y); ok {
+			img.deferredShades[idx] = float32(shade)
+		}
+		return
+	}
+	img.shadePixel(x, y, shade)
+}
+
+// multiplies the pixel's color by the shade factor (0.0 = black, 1.0 = unchanged)
+func (img *FractImage) shadePixel(x, y int, shade float64) {
+	if shade == 1 {
+		return
+	}
+	c := img.RGBA64At(x, y)
+	img.SetRGBA64(x, y, color.RGBA64{
+		R: uint16(math.Round(float64(c.R) * shade)),
+		G: uint16(math.Round(float64(c.G) * shade)),
+		B: uint16(math.Round(float64(c.B) * shade)),
+		A: c.A,
+	})
+}
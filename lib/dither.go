@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+type DitherMode string
+
+const (
+	DITHER_MODE_NONE       = "none"
+	DITHER_MODE_ORDERED    = "ordered"
+	DITHER_MODE_BLUE_NOISE = "blue-noise"
+)
+
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce       sync.Once
+	blueNoiseThresholds []float64
+)
+
+/*
+Reduces the 16 bit image to 8 bit per channel (non-premultiplied). Without dithering, the values are rounded,
+which shows as banding in smooth gradients. The dithering adds a threshold pattern before reducing:
+
+  - ordered: an 8x8 Bayer matrix, a regular cross-hatch pattern
+  - blue-noise: a 64x64 blue noise mask, an irregular pattern without visible structure
+*/
+func (img *FractImage) To8Bit() *image.NRGBA {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+
+	threshold := func(x, y int) float64 { return 0.5 }
+	switch img.Dither {
+	case DITHER_MODE_ORDERED:
+		threshold = bayerThreshold
+	case DITHER_MODE_BLUE_NOISE:
+		blueNoiseOnce.Do(func() { blueNoiseThresholds = generateBlueNoise(blueNoiseSize) })
+		threshold = func(x, y int) float64 {
+			return blueNoiseThresholds[(y&(blueNoiseSize-1))*blueNoiseSize+(x&(blueNoiseSize-1))]
+		}
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.RGBA64At(x, y)).(color.NRGBA64)
+			t := threshold(x, y)
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: reduceTo8Bit(c.R, t),
+				G: reduceTo8Bit(c.G, t),
+				B: reduceTo8Bit(c.B, t),
+				A: reduceTo8Bit(c.A, t),
+			})
+		}
+	}
+	return out
+}
+
+// reduces a 16 bit value to 8 bit: with a threshold of 0.5, the value is rounded
+func reduceTo8Bit(v uint16, threshold float64) uint8 {
+	return uint8(math.Min(255, math.Floor(float64(v)*255/0xffff+threshold)))
+}
+
+// the threshold (0.0 - 1.0) of the 8x8 Bayer matrix: the bit-reversed interleaving of x XOR y and y
+func bayerThreshold(x, y int) float64 {
+	x, y = x&7, y&7
+	xy := x ^ y
+	var v int
+	for bit := 0; bit < 3; bit++ {
+		v = v<<1 | (xy>>bit)&1
+		v = v<<1 | (y>>bit)&1
+	}
+	return (float64(v) + 0.5) / 64
+}
+
+/*
+Generates a blue noise threshold mask of size x size with the void-and-cluster method (Ulichney 1993):
+the energy of a pixel is the sum of the gaussian-weighted (toroidal) distances to all set pixels.
+Starting with a random pattern, the pixels are ranked by repeatedly removing the tightest cluster
+(the set pixel with the highest energy), and by filling the largest void (the free pixel with the lowest energy).
+*/
+func generateBlueNoise(size int) []float64 {
+	n := size * size
+	const sigma = 1.5
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			tx, ty := min(dx, size-dx), min(dy, size-dy)
+			kernel[dy*size+dx] = math.Exp(-float64(tx*tx+ty*ty) / (2 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(i int, set bool) {
+		pattern[i] = set
+		sign := 1.0
+		if !set {
+			sign = -1.0
+		}
+		ix, iy := i%size, i/size
+		for j := range energy {
+			dx := (j%size - ix + size) % size
+			dy := (j/size - iy + size) % size
+			energy[j] += sign * kernel[dy*size+dx]
+		}
+	}
+	// the set pixel with the highest energy (set = true), or the free pixel with the lowest energy (set = false)
+	extreme := func(set bool) int {
+		best := -1
+		for i, p := range pattern {
+			if p != set {
+				continue
+			}
+			if best < 0 || (set && energy[i] > energy[best]) || (!set && energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// random initial pattern with 10% of the pixels, relaxed until the tightest cluster is the largest void:
+	rnd := rand.New(rand.NewPCG(1, 2))
+	initial := n / 10
+	for _, i := range rnd.Perm(n)[:initial] {
+		toggle(i, true)
+	}
+	for iteration := 0; iteration < n; iteration++ {
+		tightest := extreme(true)
+		toggle(tightest, false)
+		void := extreme(false)
+		toggle(void, true)
+		if void == tightest {
+			break
+		}
+	}
+
+	ranks := make([]int, n)
+	initialPattern := append([]bool(nil), pattern...)
+	initialEnergy := append([]float64(nil), energy...)
+	for rank := initial - 1; rank >= 0; rank-- {
+		tightest := extreme(true)
+		toggle(tightest, false)
+		ranks[tightest] = rank
+	}
+	copy(pattern, initialPattern)
+	copy(energy, initialEnergy)
+	for rank := initial; rank < n; rank++ {
+		void := extreme(false)
+		toggle(void, true)
+		ranks[void] = rank
+	}
+
+	thresholds := make([]float64, n)
+	for i, rank := range ranks {
+		thresholds[i] = (float64(rank) + 0.5) / float64(n)
+	}
+	return thresholds
+}
@@ -0,0 +1,34 @@
+//go:build !unix
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const lockFileTimeout = 10 * time.Second
+
+/*
+Locks the lock file exclusively, waiting until it is available. Returns the unlock function.
+Without flock, the lock is the existence of the lock file: it is created exclusively, and removed on unlock.
+*/
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(lockFileTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the file is locked, remove %s if no other process is using it", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"math"
+	"math/big"
+)
+
+// The visible part of the fractal in a frame of a flight
+type FlightView struct {
+	CenterCX   float64 `json:"centerCX"`
+	CenterCY   float64 `json:"centerCY"`
+	DiameterCX float64 `json:"diameterCX"`
+}
+
+/*
+Returns the views of the nrOfFrames + 1 frames of a flight from the start to the end view.
+
+We cannot simply increase the diameter by the same amount every step: as we dive deeper into
+the fractal, we are "nearer" to the end point: This means that the same amount of diameter increase
+looks like we will get faster and faster.
+
+To counteract this, we need to increase the diameter by an amount that scales with the current
+distance from the end point. Or, we increase/decrease the diameter by a percentage amount of the actual value.
+
+This seems to be a similar problem to the "Zineszins" problem:
+
+	Kn = K0 * (1 + p)^n
+
+where:
+  - K0 is the initial value
+  - p is the percentage increase/decrease, from 0 to 1
+  - n is the number of steps
+  - Kn is the final value
+
+We have:
+  - the initial value K0: the start diameter
+  - the final value Kn: the end diameter
+  - the number of steps (n, nrOfFrames)
+  - We want: The value of p:
+    p = (nth root of (Kn / K0)) + 1
+
+Example:
+
+	nrOfFrames = 80
+	K0 = 4
+	Kn = 0.01
+
+	p = (Kn/K(0))^(1/80) - 1
+	p = (0.01/4)^(1/80) - 1
+	p = 1.0000000000000002
+
+The center moves from the start to the end center by the same percentage as the diameter.
+*/
+func FlightViews(start, end FlightView, nrOfFrames int) []FlightView {
+	startCenterCX := big.NewFloat(start.CenterCX)
+	endCenterCX := big.NewFloat(end.CenterCX)
+	deltaX := new(big.Float).Sub(endCenterCX, startCenterCX)
+
+	startCenterCY := big.NewFloat(start.CenterCY)
+	endCenterCY := big.NewFloat(end.CenterCY)
+	deltaY := new(big.Float).Sub(endCenterCY, startCenterCY)
+
+	startDiameterCX := big.NewFloat(start.DiameterCX)
+	endDiameterCX := big.NewFloat(end.DiameterCX)
+	deltaDiameter := new(big.Float).Sub(endDiameterCX, startDiameterCX)
+
+	// diameter percentage to increase per image:
+	// p = (nth root of (endDiameter / startDiameter)) + 1
+	var percIncrease float64 = math.Pow(end.DiameterCX/start.DiameterCX, 1.0/float64(nrOfFrames)) - 1.0
+
+	inc := big.NewFloat(1.0)
+	inc.Add(inc, big.NewFloat(percIncrease))
+
+	actDiameterCX := new(big.Float).Copy(startDiameterCX)
+
+	views := make([]FlightView, 0, nrOfFrames+1)
+	for i := 0; i <= nrOfFrames; i++ {
+		total := new(big.Float).Copy(deltaDiameter)
+		diff := new(big.Float).Sub(actDiameterCX, startDiameterCX)
+		percDone := diff.Quo(diff, total).Abs(diff)
+
+		// newCX = deltaX * percentage + startCenterCX
+		newCX := new(big.Float).Copy(deltaX)
+		newCX.Mul(newCX, percDone)
+		newCX.Add(newCX, startCenterCX)
+
+		// newCY = deltaY * percentage + startCenterCY
+		newCY := new(big.Float).Copy(deltaY)
+		newCY.Mul(newCY, percDone)
+		newCY.Add(newCY, startCenterCY)
+
+		var view FlightView
+		view.CenterCX, _ = newCX.Float64()
+		view.CenterCY, _ = newCY.Float64()
+		view.DiameterCX, _ = actDiameterCX.Mul(actDiameterCX, inc).Float64()
+		views = append(views, view)
+	}
+	return views
+}
@@ -1,9 +1,13 @@
 package lib
 
 import (
+	"context"
 	"errors"
+	"image/color"
 	"math/big"
 	"runtime"
+	"strings"
+	"sync/atomic"
 
 	"github.com/bylexus/go-stdlib/ethreads"
 )
@@ -25,11 +29,31 @@ type Fractal interface {
 	CreatePixelCalcJobFn(startPixX, startPixY, width, height int, img *FractImage) ethreads.JobFn
 	ImageWidth() int
 	ImageHeight() int
+	FractParams() CommonFractParams
 }
 
 type FractFunctionResult struct {
 	Iterations   int
 	BailoutValue float64
+	// true if the point did not escape within the max. iterations:
+	Interior bool
+
+	// interior orbit information, see OrbitTracker:
+	Period       int
+	FinalAbs     float64
+	AngleAverage float64
+	Multiplier   float64
+	AtomPeriod   int
+
+	// exterior orbit information for the coloring algorithms:
+	FinalZ           complex128
+	TriangleAverage  OrbitAverage
+	CurvatureAverage OrbitAverage
+	StripeAverage    OrbitAverage
+
+	// exterior distance estimation, see calcDistanceEstimate:
+	DistanceEstimate float64
+	Normal           complex128
 }
 
 type CommonFractParams struct {
@@ -43,6 +67,8 @@ type CommonFractParams struct {
 	ImageWidth  int
 	ImageHeight int
 
+	// Deprecated: use ColoringAlgorithm. Smooth colors are the default coloring algorithm; set to whether the
+	// colors are smooth during initialization.
 	SmoothColors          bool
 	ColorPaletteLength    int
 	ColorPalette          ColorPalette
@@ -50,6 +76,31 @@ type CommonFractParams struct {
 	ColorPaletteReverse   bool
 	ColorPaletteHardStops bool
 
+	ColorPaletteSpace           ColorSpace
+	ColorPaletteCurve           InterpolationCurve
+	ColorPaletteMapping         PaletteMapping
+	ColorPaletteMappingExponent float64
+	ColorPaletteOffset          float64
+
+	PeriodCheck          bool
+	InteriorMode         InteriorMode
+	InteriorColorPalette ColorPalette
+	// color of the interior for the "black" interior mode, can be (semi-)transparent. nil = opaque black
+	InteriorColor color.Color
+
+	ColoringAlgorithm  ColoringAlgorithm
+	ColoringAlgorithm2 ColoringAlgorithm
+	ColoringBlendMode  BlendMode
+	// weight of the second coloring algorithm, 0.0 - 1.0. nil = 0.5
+	ColoringBlendFactor *float64
+	ColoringDensity     float64
+
+	DistanceMode         DistanceMode
+	DistanceOutlineWidth float64
+	// direction of the light in degrees, for the shading distance mode. nil = 45
+	LightAngle  *float64
+	LightHeight float64
+
 	// calculaed during initialization:
 	aspect float64
 	minCX  float64
@@ -66,6 +117,22 @@ func (f CommonFractParams) PixelToFractal(x, y int) (cx, cy float64) {
 	return cx, cy
 }
 
+// the inverse of PixelToFractal: returns the (fractional) pixel position of a point of the fractal plane
+func (f CommonFractParams) FractalToPixel(cx, cy float64) (x, y float64) {
+	x = (cx - f.minCX) / (f.maxCX - f.minCX) * float64(f.ImageWidth)
+	y = float64(f.ImageHeight) - (cy-f.minCY)/(f.maxCY-f.minCY)*float64(f.ImageHeight)
+	return x, y
+}
+
+func (f CommonFractParams) FractParams() CommonFractParams {
+	return f
+}
+
+// returns the width of a single pixel in the fractal plane
+func (f CommonFractParams) PixelSize() float64 {
+	return (f.maxCX - f.minCX) / float64(f.ImageWidth)
+}
+
 func initializeFractParams(commonFractParams CommonFractParams) CommonFractParams {
 	// var aspect, fract_width, fract_heigth float64
 	var aspect, fract_width, fract_heigth, centerCX, centerCY *big.Float
@@ -94,7 +161,56 @@ func initializeFractParams(commonFractParams CommonFractParams) CommonFractParam
 		commonFractParams.ColorPaletteLength = -1
 	}
 
-	commonFractParams.SmoothColors = true
+	if commonFractParams.ColoringAlgorithm == "" {
+		// also for the deprecated SmoothColors: the colors were always smooth
+		commonFractParams.ColoringAlgorithm = COLORING_ALGORITHM_SMOOTH
+	}
+	commonFractParams.SmoothColors = commonFractParams.ColoringAlgorithm == COLORING_ALGORITHM_SMOOTH
+	if commonFractParams.ColoringBlendMode == "" {
+		commonFractParams.ColoringBlendMode = BLEND_MODE_MIX
+	}
+	if commonFractParams.ColoringBlendFactor == nil {
+		blendFactor := 0.5
+		commonFractParams.ColoringBlendFactor = &blendFactor
+	}
+	if commonFractParams.ColoringDensity <= 0 {
+		commonFractParams.ColoringDensity = 5
+	}
+
+	if commonFractParams.ColorPaletteMapping == "" {
+		commonFractParams.ColorPaletteMapping = PALETTE_MAPPING_LINEAR
+	}
+	if commonFractParams.ColorPaletteMappingExponent <= 0 {
+		commonFractParams.ColorPaletteMappingExponent = 0.5
+	}
+
+	if commonFractParams.InteriorMode == "" {
+		commonFractParams.InteriorMode = INTERIOR_MODE_BLACK
+	}
+	if commonFractParams.InteriorColor == nil {
+		commonFractParams.InteriorColor = blackColor
+	}
+	if len(commonFractParams.InteriorColorPalette) == 0 {
+		commonFractParams.InteriorColorPalette = commonFractParams.ColorPalette
+	}
+	if commonFractParams.DistanceMode == "" {
+		commonFractParams.DistanceMode = DISTANCE_MODE_OFF
+	}
+	if commonFractParams.DistanceOutlineWidth <= 0 {
+		commonFractParams.DistanceOutlineWidth = 1
+	}
+	if commonFractParams.LightAngle == nil {
+		lightAngle := 45.0
+		commonFractParams.LightAngle = &lightAngle
+	}
+	if commonFractParams.LightHeight <= 0 {
+		commonFractParams.LightHeight = 1.5
+	}
+
+	// period and multiplier coloring need a detected cycle:
+	if commonFractParams.InteriorMode == INTERIOR_MODE_PERIOD || commonFractParams.InteriorMode == INTERIOR_MODE_MULTIPLIER {
+		commonFractParams.PeriodCheck = true
+	}
 
 	// Calculated during initialization:
 	commonFractParams.aspect, _ = aspect.Float64()
@@ -107,32 +223,91 @@ func initializeFractParams(commonFractParams CommonFractParams) CommonFractParam
 	return commonFractParams
 }
 
+// Reports the progress of a render: the number of calculated and the total number of steps.
+// It is called from several goroutines.
+type ProgressFunc func(done, total int)
+
 func CalcFractalImage(f Fractal) *FractImage {
+	img, _ := CalcFractalImageContext(context.Background(), f, nil)
+	return img
+}
+
+/*
+Calculates the fractal image like CalcFractalImage, reporting the progress (in calculated pixel blocks, may be nil).
+If the context is canceled, the calculation stops, and the context's error is returned.
+*/
+func CalcFractalImageContext(ctx context.Context, f Fractal, progress ProgressFunc) (*FractImage, error) {
 	tp := ethreads.NewThreadPool(runtime.NumCPU()*2, nil)
 	tp.Start()
 
 	img := NewFractImage(f.ImageWidth(), f.ImageHeight())
+	if renderParams, err := NewFractalRenderParams(f); err == nil {
+		img.RenderParams = &renderParams
+	}
+	params := f.FractParams()
+	if params.needsGlobalPaletteMapping() {
+		img.deferColoring()
+	}
 
 	// We calculate blocks of pixels in separate goroutines: For each block,
 	// we start a new goroutine in the thread pool.
 	// A single pixel per goroutine is too inperformant / generates too many goroutines.
 	// A block size of 64x64 pixels is a good compromise between inperformant and too many goroutines.
 	var blockWidth, blockHeight = 64, 64
+	var nrOfBlocks = ((f.ImageWidth() + blockWidth - 1) / blockWidth) * ((f.ImageHeight() + blockHeight - 1) / blockHeight)
+	var doneBlocks atomic.Int64
 	for y := 0; y < f.ImageHeight(); y += blockHeight {
 		for x := 0; x < f.ImageWidth(); x += blockWidth {
-			tp.AddJobFn(f.CreatePixelCalcJobFn(x, y, blockWidth, blockHeight, img))
+			jobFn := f.CreatePixelCalcJobFn(x, y, blockWidth, blockHeight, img)
+			tp.AddJobFn(func(id ethreads.ThreadId) {
+				if ctx.Err() != nil {
+					return
+				}
+				jobFn(id)
+				if progress != nil {
+					progress(int(doneBlocks.Add(1)), nrOfBlocks)
+				}
+			})
 		}
 	}
 	tp.Shutdown()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	return img
+	if params.needsGlobalPaletteMapping() {
+		// 2nd pass: color the pixels after all values are known
+		applyGlobalPaletteMapping(img, params)
+	}
+
+	return img, nil
 }
 
-func NewFractalFromPresets(width, height int, colorPreset ColorPreset, fractalPreset FractalPreset) (Fractal, error) {
+func NewFractalFromPresets(width, height int, colorPresets ColorPresets, fractalPreset FractalPreset) (Fractal, error) {
 	fractFunc, err := fractalPreset.FractalFunction()
 	if err != nil {
 		return nil, err
 	}
+	colorPreset, err := colorPresets.GetByIdent(fractalPreset.ColorPreset)
+	if err != nil {
+		return nil, err
+	}
+	var interiorPalette ColorPalette
+	if fractalPreset.InteriorColorPreset != "" {
+		interiorColorPreset, err := colorPresets.GetByIdent(fractalPreset.InteriorColorPreset)
+		if err != nil {
+			return nil, err
+		}
+		interiorPalette = interiorColorPreset.Palette
+	}
+	var interiorColor color.Color
+	if fractalPreset.InteriorColor != "" {
+		c, err := ParseCssColor(fractalPreset.InteriorColor)
+		if err != nil {
+			return nil, err
+		}
+		interiorColor = color.NRGBA(c)
+	}
 	commonParams := CommonFractParams{
 		ImageWidth:            width,
 		ImageHeight:           height,
@@ -145,6 +320,25 @@ func NewFractalFromPresets(width, height int, colorPreset ColorPreset, fractalPr
 		ColorPaletteLength:    fractalPreset.ColorPaletteLength,
 		ColorPaletteReverse:   fractalPreset.ColorPaletteReverse,
 		ColorPaletteHardStops: fractalPreset.ColorPaletteHardStops,
+		PeriodCheck:           !fractalPreset.DisablePeriodCheck,
+		InteriorMode:          InteriorMode(strings.ToLower(fractalPreset.InteriorMode)),
+		InteriorColorPalette:  interiorPalette,
+		InteriorColor:         interiorColor,
+		DistanceMode:          DistanceMode(strings.ToLower(fractalPreset.DistanceMode)),
+		DistanceOutlineWidth:  fractalPreset.DistanceOutlineWidth,
+		LightAngle:            fractalPreset.LightAngle,
+		LightHeight:           fractalPreset.LightHeight,
+		ColoringAlgorithm:     ColoringAlgorithm(strings.ToLower(fractalPreset.ColoringAlgorithm)),
+		ColoringAlgorithm2:    ColoringAlgorithm(strings.ToLower(fractalPreset.ColoringAlgorithm2)),
+		ColoringBlendMode:     BlendMode(strings.ToLower(fractalPreset.ColoringBlendMode)),
+		ColoringBlendFactor:   fractalPreset.ColoringBlendFactor,
+		ColoringDensity:       fractalPreset.ColoringDensity,
+
+		ColorPaletteSpace:           colorPreset.Interpolation,
+		ColorPaletteCurve:           colorPreset.Curve,
+		ColorPaletteMapping:         PaletteMapping(strings.ToLower(fractalPreset.ColorPaletteMapping)),
+		ColorPaletteMappingExponent: fractalPreset.ColorPaletteMappingExponent,
+		ColorPaletteOffset:          fractalPreset.ColorPaletteOffset,
 	}
 	switch fractFunc {
 	case FRACTAL_TYPE_MANDELBROT:
@@ -2,27 +2,55 @@ package lib
 
 import (
 	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"os"
 )
 
+// The fractal image is calculated with 16 bit per color channel, which avoids banding in smooth
+// gradients. The 8 bit formats (png, jpeg, webp, ...) reduce the colors, with optional dithering.
 type FractImage struct {
-	*image.RGBA
+	*image.RGBA64
+
+	// dithering used when the image is reduced to 8 bit per channel, see To8Bit
+	Dither DitherMode
+	// the params the image was rendered with, embedded as metadata by the encoders. nil = no metadata
+	RenderParams *RenderParams
+
+	// pixel values and shading factors for deferred coloring, see deferColoring:
+	deferredValues []float32
+	deferredShades []float32
 }
 
 func NewFractImage(width, height int) *FractImage {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	return &FractImage{img}
+	img := image.NewRGBA64(image.Rect(0, 0, width, height))
+	return &FractImage{RGBA64: img}
 }
 
 func (img *FractImage) EncodePng(w io.Writer) error {
-	return png.Encode(w, img)
+	return encodePng(w, img.To8Bit(), EncodeOptions{})
+}
+
+// encodes the image as 16 bit png
+func (img *FractImage) EncodePng16(w io.Writer) error {
+	return encodePng(w, img.RGBA64, EncodeOptions{})
 }
 
 func (img *FractImage) EncodeJpeg(w io.Writer) error {
-	return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	return img.encodeJpeg(w, EncodeOptions{})
+}
+
+// encodes the image as lossless WebP, keeping the alpha channel
+func (img *FractImage) EncodeWebp(w io.Writer) error {
+	return EncodeWebp(w, img.To8Bit())
+}
+
+func (img *FractImage) EncodeTiff(w io.Writer) error {
+	return EncodeTiff(w, img.To8Bit())
+}
+
+// encodes the image as 16 bit tiff
+func (img *FractImage) EncodeTiff16(w io.Writer) error {
+	return EncodeTiff(w, img.RGBA64)
 }
 
 func (img *FractImage) SavePng(path string) error {
@@ -32,7 +60,7 @@ func (img *FractImage) SavePng(path string) error {
 	}
 	defer f.Close()
 
-	if err := png.Encode(f, img); err != nil {
+	if err := img.EncodePng(f); err != nil {
 		return err
 	}
 
@@ -49,7 +77,7 @@ func (img *FractImage) SaveJpeg(path string) error {
 	}
 	defer f.Close()
 
-	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 90}); err != nil {
+	if err := img.EncodeJpeg(f); err != nil {
 		return err
 	}
 
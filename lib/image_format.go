@@ -0,0 +1,306 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"mime"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type PngCompression string
+
+const (
+	PNG_COMPRESSION_DEFAULT = "default"
+	PNG_COMPRESSION_NONE    = "none"
+	PNG_COMPRESSION_FAST    = "fast"
+	PNG_COMPRESSION_BEST    = "best"
+)
+
+// Options for the image encoders. Options not applicable to a format are ignored.
+type EncodeOptions struct {
+	// jpeg: quality, 1 - 100. 0 means the default of 90.
+	JpegQuality int
+	// jpeg: chroma subsampling, 444, 422 or 420 (default)
+	ChromaSubsampling ChromaSubsampling
+	// png: compression level, default, none, fast or best
+	PngCompression PngCompression
+	// gif: number of palette colors, 2 - 256. 0 means 256.
+	GifColors int
+	// don't embed the render params of the image as metadata (png, jpeg)
+	OmitMetadata bool
+}
+
+// An output image format: the registry below maps format names, file extensions and mime types to the encoders.
+type ImageFormat struct {
+	Name string
+	// file extensions, the first is used for new files
+	Extensions []string
+	MimeType   string
+	encode     func(img *FractImage, w io.Writer, opts EncodeOptions) error
+	// inserts the render params into the encoded image, nil if the format has no metadata support
+	embedMetadata func(data []byte, params RenderParams) ([]byte, error)
+}
+
+var imageFormats = []ImageFormat{
+	{
+		Name: "png", Extensions: []string{".png"}, MimeType: "image/png",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return encodePng(w, img.To8Bit(), opts)
+		},
+		embedMetadata: embedPngMetadata,
+	},
+	{
+		Name: "png16", Extensions: []string{".png"}, MimeType: "image/png",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return encodePng(w, img.RGBA64, opts)
+		},
+		embedMetadata: embedPngMetadata,
+	},
+	{
+		Name: "jpeg", Extensions: []string{".jpg", ".jpeg"}, MimeType: "image/jpeg",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return img.encodeJpeg(w, opts)
+		},
+		embedMetadata: embedJpegMetadata,
+	},
+	{
+		Name: "webp", Extensions: []string{".webp"}, MimeType: "image/webp",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return img.EncodeWebp(w)
+		},
+	},
+	{
+		Name: "tiff", Extensions: []string{".tif", ".tiff"}, MimeType: "image/tiff",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return img.EncodeTiff(w)
+		},
+	},
+	{
+		Name: "tiff16", Extensions: []string{".tif", ".tiff"}, MimeType: "image/tiff",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return img.EncodeTiff16(w)
+		},
+	},
+	{
+		Name: "bmp", Extensions: []string{".bmp"}, MimeType: "image/bmp",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return EncodeBmp(w, img.To8Bit())
+		},
+	},
+	{
+		Name: "gif", Extensions: []string{".gif"}, MimeType: "image/gif",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return img.encodeGif(w, opts)
+		},
+	},
+	{
+		Name: "ppm", Extensions: []string{".ppm"}, MimeType: "image/x-portable-pixmap",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return EncodePpm(w, img.To8Bit())
+		},
+	},
+	{
+		Name: "pfm", Extensions: []string{".pfm"}, MimeType: "image/x-portable-floatmap",
+		encode: func(img *FractImage, w io.Writer, opts EncodeOptions) error {
+			return EncodePfm(w, img.RGBA64)
+		},
+	},
+}
+
+// alternative names of the formats
+var imageFormatAliases = map[string]string{
+	"jpg": "jpeg",
+	"tif": "tiff",
+}
+
+func ImageFormats() []ImageFormat {
+	return slices.Clone(imageFormats)
+}
+
+// Returns the names of all image formats, e.g. for help texts
+func ImageFormatNames() []string {
+	names := make([]string, len(imageFormats))
+	for i, f := range imageFormats {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func GetImageFormat(name string) (ImageFormat, error) {
+	name = strings.ToLower(name)
+	if alias, ok := imageFormatAliases[name]; ok {
+		name = alias
+	}
+	for _, f := range imageFormats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return ImageFormat{}, errors.New("unknown image format: " + name)
+}
+
+// Detects the image format by the file's extension. Extensions used by several formats
+// return the first (8 bit) one, e.g. png for ".png".
+func ImageFormatFromFilename(filename string) (ImageFormat, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, f := range imageFormats {
+		if slices.Contains(f.Extensions, ext) {
+			return f, nil
+		}
+	}
+	return ImageFormat{}, errors.New("unknown image format")
+}
+
+/*
+Selects the image format by an HTTP Accept header, e.g. "image/webp,image/*;q=0.8": the supported format with the
+highest quality value wins, where an explicitly listed mime type is preferred over a wildcard of the same quality.
+For wildcards (image/*, * / *), the fallback format is used. Returns false if no format is acceptable.
+*/
+func NegotiateImageFormat(accept string, fallback ImageFormat) (ImageFormat, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return fallback, true
+	}
+	var best ImageFormat
+	bestQ, bestSpecific, found := 0.0, false, false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qParam, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qParam, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		var format ImageFormat
+		specific := false
+		switch mediaType {
+		case "*/*", "image/*":
+			format = fallback
+		default:
+			i := slices.IndexFunc(imageFormats, func(f ImageFormat) bool { return f.MimeType == mediaType })
+			if i < 0 {
+				continue
+			}
+			format, specific = imageFormats[i], true
+		}
+		if !found || q > bestQ || (q == bestQ && specific && !bestSpecific) {
+			best, bestQ, bestSpecific, found = format, q, specific, true
+		}
+	}
+	return best, found
+}
+
+// returns the default file extension, without the dot
+func (f ImageFormat) Extension() string {
+	return strings.TrimPrefix(f.Extensions[0], ".")
+}
+
+// Encodes the image, with its render params as metadata if supported by the format.
+func (f ImageFormat) Encode(w io.Writer, img *FractImage, opts EncodeOptions) error {
+	if f.embedMetadata == nil || img.RenderParams == nil || opts.OmitMetadata {
+		return f.encode(img, w, opts)
+	}
+	var buf bytes.Buffer
+	if err := f.encode(img, &buf, opts); err != nil {
+		return err
+	}
+	data, err := f.embedMetadata(buf.Bytes(), *img.RenderParams)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func encodePng(w io.Writer, img image.Image, opts EncodeOptions) error {
+	encoder := png.Encoder{}
+	switch opts.PngCompression {
+	case PNG_COMPRESSION_DEFAULT, "":
+		encoder.CompressionLevel = png.DefaultCompression
+	case PNG_COMPRESSION_NONE:
+		encoder.CompressionLevel = png.NoCompression
+	case PNG_COMPRESSION_FAST:
+		encoder.CompressionLevel = png.BestSpeed
+	case PNG_COMPRESSION_BEST:
+		encoder.CompressionLevel = png.CompressionLevel(flate.BestCompression)
+	default:
+		return errors.New("png: unknown compression level")
+	}
+	return encoder.Encode(w, img)
+}
+
+func (img *FractImage) encodeJpeg(w io.Writer, opts EncodeOptions) error {
+	quality := opts.JpegQuality
+	if quality <= 0 {
+		quality = 90
+	}
+	return EncodeJpeg(w, img.To8Bit(), quality, opts.ChromaSubsampling)
+}
+
+/*
+Encodes the image as GIF, with an adaptive palette of the image's colors (median cut). Pixels with an alpha value
+below 50% get a transparent palette entry, as GIF has no partial transparency. If the image's dither mode is set,
+the colors are dithered with Floyd-Steinberg error diffusion.
+*/
+func (img *FractImage) encodeGif(w io.Writer, opts EncodeOptions) error {
+	nrOfColors := opts.GifColors
+	if nrOfColors <= 0 || nrOfColors > 256 {
+		nrOfColors = 256
+	}
+	nrOfColors = max(nrOfColors, 2)
+
+	var drawer draw.Drawer = draw.Src
+	if img.Dither != "" && img.Dither != DITHER_MODE_NONE {
+		drawer = draw.FloydSteinberg
+	}
+	return gif.Encode(w, img.To8Bit(), &gif.Options{
+		NumColors: nrOfColors,
+		Quantizer: medianCutQuantizer{},
+		Drawer:    drawer,
+	})
+}
+
+type medianCutQuantizer struct{}
+
+func (q medianCutQuantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	nrOfColors := cap(p) - len(p)
+	transparent := false
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y && !transparent; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a < 0x8000 {
+				transparent = true
+				break
+			}
+		}
+	}
+	if transparent {
+		p = append(p, color.RGBA{})
+		nrOfColors--
+	}
+	pixels := samplePixels(m, 1)
+	if len(pixels) == 0 {
+		return p
+	}
+	for _, c := range medianCutColors(pixels, nrOfColors) {
+		p = append(p, c)
+	}
+	return p
+}
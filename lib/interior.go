@@ -0,0 +1,257 @@
+package lib
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+type InteriorMode string
+
+const (
+	INTERIOR_MODE_BLACK       = "black"
+	INTERIOR_MODE_PERIOD      = "period"
+	INTERIOR_MODE_FINAL_ABS   = "final-abs"
+	INTERIOR_MODE_ANGLE       = "angle"
+	INTERIOR_MODE_MULTIPLIER  = "multiplier"
+	INTERIOR_MODE_ATOM_DOMAIN = "atom-domain"
+)
+
+// squared distance under which two orbit points are considered equal during the period check:
+const PERIOD_CHECK_EPSILON float64 = 1e-20
+
+// squared distance used to find the real period when a multiple of it was detected:
+const PERIOD_REFINE_EPSILON float64 = 1e-12
+
+/*
+OrbitTracker follows the orbit of a single point while it is iterated by a fractal function.
+
+It detects cycles using Brent's algorithm: a reference point is saved and compared to all following
+orbit points. If no match is found within the actual window, the reference point is replaced by the
+actual orbit point and the window length is doubled:
+
+	z0 | z1 z2 | z3 z4 z5 z6 | z7 ... z14 | ...
+	ref: z0    z2            z6           z14
+
+If an orbit point matches the reference point, the point is inside the set: the orbit is attracted by a
+cycle with a period of (actual iteration - reference iteration). This allows us to stop iterating
+interior points early instead of burning the full iteration budget.
+
+In addition, the tracker collects some orbit statistics used to colorize the interior of the set.
+*/
+type OrbitTracker struct {
+	periodCheck  bool
+	interiorMode InteriorMode
+	power        int
+	c            complex128
+
+	checkZ      complex128
+	checkIter   int
+	checkWindow int
+	period      int
+
+	steps        int
+	angleSum     float64
+	minAbsSquare float64
+	atomPeriod   int
+
+	// derivative dz/dc (or dz/dz0 for julia sets), used for the distance estimation:
+	trackDerivative bool
+	prevZ           complex128
+	dz              complex128
+	dzConst         complex128
+
+	// orbit averages for the coloring algorithms, see addAverages:
+	prevPrevZ        complex128
+	trackTriangle    bool
+	trackCurvature   bool
+	trackStripe      bool
+	stripeDensity    float64
+	triangleAverage  orbitAverageSum
+	curvatureAverage orbitAverageSum
+	stripeAverage    orbitAverageSum
+}
+
+// Creates a new orbit tracker for the given fractal params. z0 is the start value of the orbit,
+// c the constant added in each iteration step, and power the exponent of the iteration function (z^power + c).
+func NewOrbitTracker(fractParams CommonFractParams, z0, c complex128, power int) OrbitTracker {
+	return OrbitTracker{
+		periodCheck:  fractParams.PeriodCheck,
+		interiorMode: fractParams.InteriorMode,
+		power:        power,
+		c:            c,
+		checkZ:       z0,
+		checkIter:    0,
+		checkWindow:  1,
+		minAbsSquare: math.MaxFloat64,
+
+		// Mandelbrot: z' = power * z^(power-1) * z' + 1, z'(0) = 0
+		trackDerivative: fractParams.DistanceMode != DISTANCE_MODE_OFF,
+		prevZ:           z0,
+		dz:              0,
+		dzConst:         1,
+
+		prevPrevZ:      z0,
+		trackTriangle:  fractParams.usesColoringAlgorithm(COLORING_ALGORITHM_TRIANGLE_INEQUALITY),
+		trackCurvature: fractParams.usesColoringAlgorithm(COLORING_ALGORITHM_CURVATURE),
+		trackStripe:    fractParams.usesColoringAlgorithm(COLORING_ALGORITHM_STRIPE),
+		stripeDensity:  fractParams.ColoringDensity,
+	}
+}
+
+// Creates a new orbit tracker for a julia set: in contrast to the Mandelbrot set, the orbit starts
+// at the pixel's point z0, and the constant c is the julia constant.
+func NewJuliaOrbitTracker(fractParams CommonFractParams, z0, c complex128, power int) OrbitTracker {
+	t := NewOrbitTracker(fractParams, z0, c, power)
+	// Julia: z' = power * z^(power-1) * z', z'(0) = 1
+	t.dz = 1
+	t.dzConst = 0
+	return t
+}
+
+// Step must be called after each iteration with the actual orbit point (x + yi).
+// Returns true if a cycle was detected, which means the point is inside the set.
+func (t *OrbitTracker) Step(iter int, x, y float64) bool {
+	t.steps++
+	var z = complex(x, y)
+	if t.trackDerivative {
+		t.dz = complex(float64(t.power), 0)*complexPow(t.prevZ, t.power-1)*t.dz + t.dzConst
+	}
+	if t.trackTriangle || t.trackCurvature || t.trackStripe {
+		t.addAverages(z, t.prevZ, t.prevPrevZ)
+	}
+	t.prevPrevZ = t.prevZ
+	t.prevZ = z
+	if t.interiorMode == INTERIOR_MODE_ANGLE {
+		t.angleSum += math.Atan2(y, x)
+	}
+	absSquare := x*x + y*y
+	if absSquare < t.minAbsSquare {
+		t.minAbsSquare = absSquare
+		t.atomPeriod = iter
+	}
+
+	if !t.periodCheck {
+		return false
+	}
+	dx := x - real(t.checkZ)
+	dy := y - imag(t.checkZ)
+	if dx*dx+dy*dy < PERIOD_CHECK_EPSILON {
+		t.period = iter - t.checkIter
+		return true
+	}
+	if iter >= t.checkIter+t.checkWindow {
+		t.checkZ = complex(x, y)
+		t.checkIter = iter
+		t.checkWindow *= 2
+	}
+	return false
+}
+
+// Finish stores the collected orbit information in the fractal function result.
+// x, y is the last orbit point.
+func (t *OrbitTracker) Finish(result *FractFunctionResult, x, y float64) {
+	result.FinalZ = complex(x, y)
+	result.FinalAbs = math.Sqrt(x*x + y*y)
+	result.TriangleAverage = t.triangleAverage.avg
+	result.CurvatureAverage = t.curvatureAverage.avg
+	result.StripeAverage = t.stripeAverage.avg
+	result.AtomPeriod = t.atomPeriod
+	if t.steps > 0 {
+		result.AngleAverage = t.angleSum / float64(t.steps)
+	}
+	if t.trackDerivative {
+		calcDistanceEstimate(result, complex(x, y), t.dz)
+	}
+	if t.period > 0 {
+		t.refinePeriod(complex(x, y))
+
+		// the multiplier of the cycle is the derivative of f^p(z), which is
+		// the product of the derivatives power * z^(power-1) along the cycle:
+		var z = complex(x, y)
+		var multiplier complex128 = 1
+		for i := 0; i < t.period; i++ {
+			multiplier *= complex(float64(t.power), 0) * complexPow(z, t.power-1)
+			z = complexPow(z, t.power) + t.c
+		}
+		result.Multiplier = cmplx.Abs(multiplier)
+	}
+	result.Period = t.period
+}
+
+// Near the border of a component, the orbit converges slowly (|multiplier| ~ 1) and may spiral around
+// the cycle points: Brent's algorithm then finds a multiple of the real period. We check if a
+// divisor of the found period also closes the cycle.
+func (t *OrbitTracker) refinePeriod(z0 complex128) {
+	for q := 1; q < t.period; q++ {
+		if t.period%q != 0 {
+			continue
+		}
+		var z = z0
+		for i := 0; i < q; i++ {
+			z = complexPow(z, t.power) + t.c
+		}
+		d := z - z0
+		if real(d)*real(d)+imag(d)*imag(d) < PERIOD_REFINE_EPSILON {
+			t.period = q
+			return
+		}
+	}
+}
+
+func complexPow(z complex128, n int) complex128 {
+	var res complex128 = 1
+	for i := 0; i < n; i++ {
+		res *= z
+	}
+	return res
+}
+
+// Sets the color of an interior (non-escaping) pixel, depending on the interior mode.
+// The calculated interior value (0.0 - 1.0) is mapped onto the interior color palette.
+func setInteriorColor(img *FractImage, x, y int, fractParams CommonFractParams, fractRes FractFunctionResult) {
+	var palette = fractParams.InteriorColorPalette
+	var nrOfColors = float64(len(palette))
+	var ratio float64
+
+	switch fractParams.InteriorMode {
+	case INTERIOR_MODE_PERIOD:
+		if fractRes.Period <= 0 {
+			// no cycle detected within the max iterations:
+			img.Set(x, y, fractParams.InteriorColor)
+			return
+		}
+		// periods are discrete values: map them directly to the color stops
+		ratio = math.Mod(float64(fractRes.Period-1), nrOfColors) / nrOfColors
+	case INTERIOR_MODE_ATOM_DOMAIN:
+		ratio = math.Mod(float64(fractRes.AtomPeriod-1), nrOfColors) / nrOfColors
+	case INTERIOR_MODE_FINAL_ABS:
+		// interior orbits stay within |z| <= 2:
+		ratio = math.Min(fractRes.FinalAbs/2.0, 1.0)
+	case INTERIOR_MODE_ANGLE:
+		ratio = (fractRes.AngleAverage + math.Pi) / (2 * math.Pi)
+	case INTERIOR_MODE_MULTIPLIER:
+		if fractRes.Period <= 0 {
+			img.Set(x, y, fractParams.InteriorColor)
+			return
+		}
+		// attracting cycles have a multiplier |m| < 1:
+		ratio = math.Min(fractRes.Multiplier, 1.0)
+	default:
+		img.Set(x, y, fractParams.InteriorColor)
+		return
+	}
+
+	interiorParams := CommonFractParams{
+		MaxIterations:         interiorPaletteResolution,
+		ColorPalette:          palette,
+		ColorPaletteRepeat:    1,
+		ColorPaletteLength:    -1,
+		ColorPaletteHardStops: fractParams.ColorPaletteHardStops,
+		ColorPaletteSpace:     fractParams.ColorPaletteSpace,
+		ColorPaletteCurve:     fractParams.ColorPaletteCurve,
+	}
+	SetPaletteColor(img, x, y, ratio*interiorPaletteResolution, interiorParams, fractRes)
+}
+
+// number of "virtual iterations" the interior palette is spread over:
+const interiorPaletteResolution = 1000
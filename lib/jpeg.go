@@ -0,0 +1,380 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+/*
+A baseline JPEG encoder with selectable chroma subsampling, as the standard library's encoder always
+uses 4:2:0 subsampling, which blurs the colors of fine structures, e.g. the colored filaments of a fractal.
+
+It uses the example quantization and Huffman tables of the JPEG spec (Annex K), with the quality scaling of libjpeg.
+*/
+
+type ChromaSubsampling string
+
+const (
+	CHROMA_SUBSAMPLING_444 = "444"
+	CHROMA_SUBSAMPLING_422 = "422"
+	CHROMA_SUBSAMPLING_420 = "420"
+)
+
+// the quantization tables of the JPEG spec, in natural (row) order, for quality 50:
+var jpegLuminanceQuant = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+var jpegChrominanceQuant = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// zigzag order: the natural index of the n-th coefficient
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// a Huffman table: the number of codes per code length (1 - 16 bits), and the symbols in code order
+type jpegHuffmanSpec struct {
+	counts  [16]byte
+	symbols []byte
+}
+
+var jpegHuffmanSpecs = [4]jpegHuffmanSpec{
+	// luminance DC
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	// luminance AC
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	// chrominance DC
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	// chrominance AC
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// the cosine factors of the DCT: jpegDctCos[u][x] = c(u) / 2 * cos((2x + 1) * u * pi / 16)
+var jpegDctCos = func() [8][8]float64 {
+	var table [8][8]float64
+	for u := 0; u < 8; u++ {
+		cu := 1.0
+		if u == 0 {
+			cu = 1 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			table[u][x] = cu / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return table
+}()
+
+/*
+Encodes the image as baseline JPEG with the given quality (1 - 100) and chroma subsampling.
+Transparent pixels are composited over black.
+*/
+func EncodeJpeg(w io.Writer, img image.Image, quality int, subsampling ChromaSubsampling) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > 0xffff || height > 0xffff {
+		return errors.New("jpeg: image size must be between 1 and 65535 pixels")
+	}
+	quality = min(max(quality, 1), 100)
+
+	// horizontal and vertical sampling factors of the luminance, relative to the chrominance:
+	var h, v int
+	switch subsampling {
+	case CHROMA_SUBSAMPLING_444:
+		h, v = 1, 1
+	case CHROMA_SUBSAMPLING_422:
+		h, v = 2, 1
+	case CHROMA_SUBSAMPLING_420, "":
+		h, v = 2, 2
+	default:
+		return errors.New("jpeg: unknown chroma subsampling")
+	}
+
+	var quant [2][64]int
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range 64 {
+		quant[0][i] = min(max((jpegLuminanceQuant[i]*scale+50)/100, 1), 255)
+		quant[1][i] = min(max((jpegChrominanceQuant[i]*scale+50)/100, 1), 255)
+	}
+
+	bw := bufio.NewWriter(w)
+	jw := &jpegWriter{w: bw}
+	jw.writeHeaders(width, height, h, v, quant)
+
+	// the image, converted to YCbCr:
+	planes := [3][]float64{make([]float64, width*height), make([]float64, width*height), make([]float64, width*height)}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			rf, gf, bf := float64(r)/257, float64(g)/257, float64(b)/257
+			i := y*width + x
+			planes[0][i] = 0.299*rf + 0.587*gf + 0.114*bf
+			planes[1][i] = -0.168736*rf - 0.331264*gf + 0.5*bf + 128
+			planes[2][i] = 0.5*rf - 0.418688*gf - 0.081312*bf + 128
+		}
+	}
+
+	var prevDC [3]int
+	mcuWidth, mcuHeight := 8*h, 8*v
+	for mcuY := 0; mcuY < height; mcuY += mcuHeight {
+		for mcuX := 0; mcuX < width; mcuX += mcuWidth {
+			for by := 0; by < v; by++ {
+				for bx := 0; bx < h; bx++ {
+					block := jpegBlock(planes[0], width, height, mcuX+8*bx, mcuY+8*by, 1, 1)
+					prevDC[0] = jw.writeBlock(block, &quant[0], prevDC[0], 0)
+				}
+			}
+			for ch := 1; ch < 3; ch++ {
+				block := jpegBlock(planes[ch], width, height, mcuX, mcuY, h, v)
+				prevDC[ch] = jw.writeBlock(block, &quant[1], prevDC[ch], 2)
+			}
+		}
+	}
+	jw.flushBits()
+	jw.write([]byte{0xff, 0xd9})
+
+	if jw.err != nil {
+		return jw.err
+	}
+	return bw.Flush()
+}
+
+// returns an 8x8 block of the plane, starting at x, y, averaging sx * sy pixels per sample.
+// Pixels outside the image repeat the edge pixels.
+func jpegBlock(plane []float64, width, height, x, y, sx, sy int) [64]float64 {
+	var block [64]float64
+	for by := 0; by < 8; by++ {
+		for bx := 0; bx < 8; bx++ {
+			var sum float64
+			for dy := 0; dy < sy; dy++ {
+				for dx := 0; dx < sx; dx++ {
+					px := min(x+bx*sx+dx, width-1)
+					py := min(y+by*sy+dy, height-1)
+					sum += plane[py*width+px]
+				}
+			}
+			block[by*8+bx] = sum/float64(sx*sy) - 128
+		}
+	}
+	return block
+}
+
+type jpegWriter struct {
+	w   *bufio.Writer
+	err error
+	// the Huffman codes per table and symbol: the code length in the upper 8 bits, the code in the lower 24 bits
+	codes [4][256]uint32
+	acc   uint32
+	nBits uint
+}
+
+func (jw *jpegWriter) write(b []byte) {
+	if jw.err == nil {
+		_, jw.err = jw.w.Write(b)
+	}
+}
+
+func (jw *jpegWriter) writeMarker(marker byte, data []byte) {
+	n := len(data) + 2
+	jw.write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	jw.write(data)
+}
+
+func (jw *jpegWriter) writeHeaders(width, height, h, v int, quant [2][64]int) {
+	jw.write([]byte{0xff, 0xd8})
+	// JFIF APP0: version 1.1, no density units, aspect ratio 1:1, no thumbnail
+	jw.writeMarker(0xe0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+
+	var dqt []byte
+	for table := range quant {
+		dqt = append(dqt, byte(table))
+		for _, i := range jpegZigzag {
+			dqt = append(dqt, byte(quant[table][i]))
+		}
+	}
+	jw.writeMarker(0xdb, dqt)
+
+	jw.writeMarker(0xc0, []byte{
+		8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, byte(h<<4 | v), 0,
+		2, 0x11, 1,
+		3, 0x11, 1,
+	})
+
+	var dht []byte
+	for table, spec := range jpegHuffmanSpecs {
+		// table class (0 = DC, 1 = AC) and destination id (0 = luminance, 1 = chrominance):
+		dht = append(dht, byte((table%2)<<4|table/2))
+		dht = append(dht, spec.counts[:]...)
+		dht = append(dht, spec.symbols...)
+
+		code, k := uint32(0), 0
+		for length, count := range spec.counts {
+			for range count {
+				jw.codes[table][spec.symbols[k]] = uint32(length+1)<<24 | code
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+	jw.writeMarker(0xc4, dht)
+
+	// scan of all 3 components, with the luminance and chrominance Huffman tables:
+	jw.writeMarker(0xda, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+}
+
+// writes bits most significant bit first, with a 0 byte stuffed after each 0xff byte
+func (jw *jpegWriter) writeBits(bits uint32, n uint) {
+	jw.acc = jw.acc<<n | bits&(1<<n-1)
+	jw.nBits += n
+	for jw.nBits >= 8 {
+		b := byte(jw.acc >> (jw.nBits - 8))
+		jw.nBits -= 8
+		if b == 0xff {
+			jw.write([]byte{0xff, 0})
+		} else {
+			jw.write([]byte{b})
+		}
+	}
+}
+
+// pads the last byte with 1 bits
+func (jw *jpegWriter) flushBits() {
+	if jw.nBits > 0 {
+		jw.writeBits(0x7f, 8-jw.nBits)
+	}
+}
+
+func (jw *jpegWriter) writeSymbol(table int, symbol byte) {
+	code := jw.codes[table][symbol]
+	jw.writeBits(code&0xffffff, uint(code>>24))
+}
+
+// writes a value with its category (number of bits): negative values are written as value - 1, in ones' complement
+func (jw *jpegWriter) writeValue(table int, run int, value int) {
+	abs, bits := value, value
+	if value < 0 {
+		abs, bits = -value, value-1
+	}
+	category := 0
+	for abs > 0 {
+		category++
+		abs >>= 1
+	}
+	jw.writeSymbol(table, byte(run<<4|category))
+	if category > 0 {
+		jw.writeBits(uint32(bits), uint(category))
+	}
+}
+
+// transforms, quantizes and writes the block with the DC / AC Huffman tables dcTable and dcTable + 1.
+// Returns the quantized DC value, as the next block's DC value is coded as difference.
+func (jw *jpegWriter) writeBlock(block [64]float64, quant *[64]int, prevDC int, dcTable int) int {
+	// separable 2D DCT: first the rows, then the columns
+	var rows, coeffs [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += jpegDctCos[u][x] * block[y*8+x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += jpegDctCos[v][y] * rows[y*8+u]
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	dc := int(math.Round(coeffs[0] / float64(quant[0])))
+	jw.writeValue(dcTable, 0, dc-prevDC)
+
+	run := 0
+	for _, i := range jpegZigzag[1:] {
+		value := int(math.Round(coeffs[i] / float64(quant[i])))
+		if value == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			jw.writeSymbol(dcTable+1, 0xf0) // a run of 16 zeros
+			run -= 16
+		}
+		jw.writeValue(dcTable+1, run, value)
+		run = 0
+	}
+	if run > 0 {
+		jw.writeSymbol(dcTable+1, 0x00) // end of block
+	}
+	return dc
+}
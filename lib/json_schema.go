@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+A JSON Schema (draft 2020-12), limited to the keywords used by the presets schema: $ref (to $defs), anyOf, type,
+properties, required, additionalProperties, items, enum, minimum, maximum, exclusiveMinimum, minItems, minLength
+and format. The only format is "color", a CSS color (see ParseCssColor).
+*/
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []string               `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	MinItems             *int                   `json:"minItems"`
+	MinLength            *int                   `json:"minLength"`
+	Format               string                 `json:"format"`
+}
+
+/*
+Validates the value (decoded with json.Decoder.UseNumber) against the schema, and adds the problems with the value's
+JSON path. The root schema resolves the $refs.
+*/
+func (s *jsonSchema) validate(root *jsonSchema, value any, path string, problems *PresetProblems) {
+	s, ok := s.resolve(root)
+	if !ok {
+		problems.add(path, "unknown schema reference %s", s.Ref)
+		return
+	}
+	if len(s.AnyOf) > 0 {
+		s.validateAnyOf(root, value, path, problems)
+	}
+	if s.Type != "" && !jsonSchemaTypeMatches(s.Type, value) {
+		problems.add(path, "must be %s", jsonSchemaTypeName(s.Type))
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				problems.add(path+"."+name, "is required")
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok {
+				property.validate(root, v[name], path+"."+name, problems)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				problems.warn(path+"."+name, "unknown property")
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			problems.add(path, "must have at least %d items", *s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(root, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			problems.add(path, "must not be empty")
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+			problems.add(path, "must be one of %s", strings.Join(s.Enum, ", "))
+		}
+		if s.Format == "color" {
+			if _, err := ParseCssColor(v); err != nil {
+				problems.add(path, "%s", err)
+			}
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			problems.add(path, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			problems.add(path, "must be at most %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			problems.add(path, "must be greater than %v", *s.ExclusiveMinimum)
+		}
+	}
+}
+
+// the schema of the $ref, or the schema itself without $ref. False if the $ref is unknown.
+func (s *jsonSchema) resolve(root *jsonSchema) (*jsonSchema, bool) {
+	if s.Ref == "" {
+		return s, true
+	}
+	def, ok := root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	if !ok {
+		return s, false
+	}
+	return def, true
+}
+
+/*
+Validates the value against the alternatives of anyOf. If no alternative matches, the problems of the closest
+alternative are added: the alternative of the value's type with the fewest problems.
+*/
+func (s *jsonSchema) validateAnyOf(root *jsonSchema, value any, path string, problems *PresetProblems) {
+	var closest PresetProblems
+	var types []string
+	for _, alternative := range s.AnyOf {
+		var alternativeProblems PresetProblems
+		alternative.validate(root, value, path, &alternativeProblems)
+		if len(alternativeProblems) == 0 {
+			return
+		}
+		resolved, _ := alternative.resolve(root)
+		if !slices.Contains(types, resolved.Type) {
+			types = append(types, resolved.Type)
+		}
+		if jsonSchemaTypeMatches(resolved.Type, value) && (closest == nil || len(alternativeProblems) < len(closest)) {
+			closest = alternativeProblems
+		}
+	}
+	if closest == nil {
+		names := make([]string, len(types))
+		for i, schemaType := range types {
+			names[i] = jsonSchemaTypeName(schemaType)
+		}
+		problems.add(path, "must be %s", strings.Join(names, " or "))
+		return
+	}
+	*problems = append(*problems, closest...)
+}
+
+func jsonSchemaTypeMatches(schemaType string, value any) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return schemaType == "object"
+	case []any:
+		return schemaType == "array"
+	case string:
+		return schemaType == "string"
+	case bool:
+		return schemaType == "boolean"
+	case json.Number:
+		if schemaType == "integer" {
+			// stricter than JSON Schema: 1.0 cannot be decoded into an int field
+			_, err := strconv.ParseInt(v.String(), 10, 64)
+			return err == nil
+		}
+		return schemaType == "number"
+	case nil:
+		return schemaType == "null"
+	}
+	return false
+}
+
+func jsonSchemaTypeName(schemaType string) string {
+	switch schemaType {
+	case "object", "array", "integer":
+		return "an " + schemaType
+	}
+	return "a " + schemaType
+}
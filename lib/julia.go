@@ -30,8 +30,10 @@ func (f JuliaFractal) CreatePixelCalcJobFn(startPixX, startPixY, width, height i
 			for pixX := startPixX; pixX < startPixX+width; pixX++ {
 				cx, cy := f.PixelToFractal(pixX, pixY)
 				var fractRes FractFunctionResult
-				fractRes = Julia(cx, cy, f.MaxAbsSquareAmount, f.MaxIterations, f.JuliaKr, f.JuliaKi)
+				tracker := NewJuliaOrbitTracker(f.CommonFractParams, complex(cx, cy), complex(f.JuliaKr, f.JuliaKi), 2)
+				fractRes = Julia(cx, cy, f.MaxAbsSquareAmount, f.MaxIterations, f.JuliaKr, f.JuliaKi, &tracker)
 				setImagePixel(img, pixX, pixY, f.CommonFractParams, fractRes)
+				shadeImagePixel(img, pixX, pixY, f.CommonFractParams, fractRes)
 			}
 		}
 	}
@@ -54,11 +56,14 @@ func (f JuliaFractal) CreatePixelCalcJobFn(startPixX, startPixY, width, height i
   - The number is iterated as long as it is clear that is is either reaching the border |Z^2| > max
   - or the max. number of iterations is reached.
     *
+  - If an orbit tracker is given (may be nil), it is used to detect cycles (= interior points) early
+  - and to collect the orbit information for the interior coloring.
+    *
   - Part of JFractGen - a Julia / Mandelbrot Fractal generator written in Java/Swing.
   - @author Alexander Schenkel, www.alexi.ch
   - (c) 2012 Alexander Schenkel
 */
-func Julia(cx, cy, max_betrag_quadrat float64, maxIter int, julia_r, julia_i float64) FractFunctionResult {
+func Julia(cx, cy, max_betrag_quadrat float64, maxIter int, julia_r, julia_i float64, tracker *OrbitTracker) FractFunctionResult {
 	var betragQuadrat float64 = 0.0
 	var iter int = 0
 	var x, xt float64 = cx, 0.0
@@ -72,10 +77,20 @@ func Julia(cx, cy, max_betrag_quadrat float64, maxIter int, julia_r, julia_i flo
 		y = yt
 		iter += 1
 		betragQuadrat = x*x + y*y
+
+		if tracker != nil && tracker.Step(iter, x, y) {
+			// cycle found: the point is inside the set
+			iter = maxIter
+			break
+		}
 	}
 	result := FractFunctionResult{
 		Iterations:   iter,
 		BailoutValue: betragQuadrat,
+		Interior:     betragQuadrat <= max_betrag_quadrat,
+	}
+	if tracker != nil {
+		tracker.Finish(&result, x, y)
 	}
 	return result
 }
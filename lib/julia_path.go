@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	JULIA_PATH_LINE     = "line"
+	JULIA_PATH_CIRCLE   = "circle"
+	JULIA_PATH_BEZIER   = "bezier"
+	JULIA_PATH_CARDIOID = "cardioid"
+)
+
+// the number of segments a path is approximated with, for its length and for drawing it
+const juliaPathSamples = 4096
+
+/*
+The path of the Julia constant through the complex plane in a Julia morph animation. Parsed from "type:args", with
+the args as comma-separated numbers (the real and imaginary parts of the points):
+  - line:kr1,ki1,kr2,ki2: a straight line from the first to the second point
+  - circle:kr,ki,radius: a circle around the point, counterclockwise, starting right of the center
+  - bezier:kr1,ki1,kr2,ki2,kr3,ki3[,kr4,ki4]: a quadratic or cubic Bezier curve with the control points
+  - cardioid[:scale]: the boundary of the main cardioid of the Mandelbrot set, counterclockwise from its cusp.
+    The Julia sets of its points are on the brink of falling apart. A scale of the multiplier below 1 moves the path
+    into the cardioid, above 1 out of it.
+
+Circles and cardioids are closed paths, so their animations loop.
+*/
+type JuliaPath struct {
+	Type string
+	Args []float64
+}
+
+// Parses a Julia path, see JuliaPath.
+func ParseJuliaPath(s string) (JuliaPath, error) {
+	pathType, args, _ := strings.Cut(s, ":")
+	path := JuliaPath{Type: strings.ToLower(strings.TrimSpace(pathType))}
+	if strings.TrimSpace(args) != "" {
+		for _, arg := range strings.Split(args, ",") {
+			value, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+			if err != nil {
+				return path, fmt.Errorf("invalid Julia path '%s': bad number '%s'", s, arg)
+			}
+			path.Args = append(path.Args, value)
+		}
+	}
+
+	switch path.Type {
+	case JULIA_PATH_LINE:
+		if len(path.Args) != 4 {
+			return path, fmt.Errorf("invalid Julia path '%s': expected line:kr1,ki1,kr2,ki2", s)
+		}
+	case JULIA_PATH_CIRCLE:
+		if len(path.Args) != 3 || path.Args[2] <= 0 {
+			return path, fmt.Errorf("invalid Julia path '%s': expected circle:kr,ki,radius, with a positive radius", s)
+		}
+	case JULIA_PATH_BEZIER:
+		if len(path.Args) != 6 && len(path.Args) != 8 {
+			return path, fmt.Errorf("invalid Julia path '%s': expected 3 or 4 control points kr,ki", s)
+		}
+	case JULIA_PATH_CARDIOID:
+		if len(path.Args) > 1 || (len(path.Args) == 1 && path.Args[0] <= 0) {
+			return path, fmt.Errorf("invalid Julia path '%s': expected cardioid or cardioid:scale, with a positive scale", s)
+		}
+	default:
+		return path, fmt.Errorf("invalid Julia path '%s': unknown type, expected line, circle, bezier or cardioid", s)
+	}
+	return path, nil
+}
+
+// Returns whether the path ends at its start.
+func (p JuliaPath) Closed() bool {
+	return p.Type == JULIA_PATH_CIRCLE || p.Type == JULIA_PATH_CARDIOID
+}
+
+// Returns the point of the path at t (0.0 - 1.0), as Julia constant kr + ki*i.
+func (p JuliaPath) Point(t float64) complex128 {
+	switch p.Type {
+	case JULIA_PATH_LINE:
+		start, end := complex(p.Args[0], p.Args[1]), complex(p.Args[2], p.Args[3])
+		return start + complex(t, 0)*(end-start)
+	case JULIA_PATH_CIRCLE:
+		return complex(p.Args[0], p.Args[1]) + complex(p.Args[2], 0)*cmplx.Exp(complex(0, 2*math.Pi*t))
+	case JULIA_PATH_BEZIER:
+		// de Casteljau: interpolate between the control points until one point is left
+		points := make([]complex128, len(p.Args)/2)
+		for i := range points {
+			points[i] = complex(p.Args[2*i], p.Args[2*i+1])
+		}
+		for n := len(points) - 1; n > 0; n-- {
+			for i := range n {
+				points[i] += complex(t, 0) * (points[i+1] - points[i])
+			}
+		}
+		return points[0]
+	case JULIA_PATH_CARDIOID:
+		scale := 1.0
+		if len(p.Args) == 1 {
+			scale = p.Args[0]
+		}
+		// the points c with a fixed point of multiplier m: c = m/2 - m²/4, with |m| = 1 on the boundary
+		m := complex(scale, 0) * cmplx.Exp(complex(0, 2*math.Pi*t))
+		return m/2 - m*m/4
+	}
+	return 0
+}
+
+// the points of the path at juliaPathSamples + 1 evenly spaced t
+func (p JuliaPath) samples() []complex128 {
+	points := make([]complex128, juliaPathSamples+1)
+	for i := range points {
+		points[i] = p.Point(float64(i) / juliaPathSamples)
+	}
+	return points
+}
+
+/*
+Returns the Julia constants of the nrOfFrames frames of a morph along the path. The constants are evenly spaced by
+the length of the path, so the constant moves at a constant speed. The end of a closed path is left out, as it is the
+start of the next loop.
+*/
+func (p JuliaPath) Points(nrOfFrames int) []complex128 {
+	samples := p.samples()
+	lengths := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		lengths[i] = lengths[i-1] + cmplx.Abs(samples[i]-samples[i-1])
+	}
+	totalLength := lengths[len(lengths)-1]
+
+	points := make([]complex128, max(nrOfFrames, 0))
+	for i := range points {
+		f := 0.0
+		if p.Closed() {
+			f = float64(i) / float64(nrOfFrames)
+		} else if nrOfFrames > 1 {
+			f = float64(i) / float64(nrOfFrames-1)
+		}
+		t := f
+		if totalLength > 0 {
+			// the sample segment containing the length, and the position in it:
+			length := f * totalLength
+			j := sort.SearchFloat64s(lengths, length)
+			switch {
+			case j == 0:
+				t = 0
+			case j >= len(lengths):
+				t = 1
+			default:
+				t = (float64(j-1) + (length-lengths[j-1])/(lengths[j]-lengths[j-1])) / juliaPathSamples
+			}
+		}
+		points[i] = p.Point(t)
+	}
+	return points
+}
+
+/*
+Renders the Mandelbrot set with the path drawn on it, to preview a Julia morph: the view covers the path, whose start
+is marked with a dot. The fractal params define the coloring of the Mandelbrot set; its view and size are set from
+the path and the given width and height.
+*/
+func CalcJuliaPathPreviewImage(params CommonFractParams, width, height int, path JuliaPath, pathColor color.Color) (*FractImage, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+	samples := path.samples()
+	minP, maxP := samples[0], samples[0]
+	for _, point := range samples {
+		minP = complex(min(real(minP), real(point)), min(imag(minP), imag(point)))
+		maxP = complex(max(real(maxP), real(point)), max(imag(maxP), imag(point)))
+	}
+	center := (minP + maxP) / 2
+	params.ImageWidth, params.ImageHeight = width, height
+	params.CenterCX, params.CenterCY = real(center), imag(center)
+	// the path with a margin around it, in the image's aspect ratio:
+	params.DiameterCX = max(0.5, 1.5*max(real(maxP-minP), imag(maxP-minP)*float64(width)/float64(height)))
+
+	fractal, err := NewFractalFromParams(FRACTAL_TYPE_MANDELBROT, params, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	img := CalcFractalImage(fractal)
+	img.RenderParams = nil
+
+	view := fractal.FractParams()
+	radius := max(1.5, float64(width)/400)
+	for i := 1; i < len(samples); i++ {
+		x1, y1 := view.FractalToPixel(real(samples[i-1]), imag(samples[i-1]))
+		x2, y2 := view.FractalToPixel(real(samples[i]), imag(samples[i]))
+		// dots every half pixel along the segment:
+		steps := max(1, int(math.Ceil(2*math.Hypot(x2-x1, y2-y1))))
+		for s := range steps {
+			f := float64(s) / float64(steps)
+			fillDisc(img, x1+f*(x2-x1), y1+f*(y2-y1), radius, pathColor)
+		}
+	}
+	x, y := view.FractalToPixel(real(samples[0]), imag(samples[0]))
+	fillDisc(img, x, y, 3*radius, pathColor)
+	return img, nil
+}
+
+// fills the pixels within the radius around the point
+func fillDisc(img *FractImage, x, y, radius float64, c color.Color) {
+	for py := int(math.Floor(y - radius)); py <= int(math.Ceil(y+radius)); py++ {
+		for px := int(math.Floor(x - radius)); px <= int(math.Ceil(x+radius)); px++ {
+			dx, dy := float64(px)+0.5-x, float64(py)+0.5-y
+			if dx*dx+dy*dy <= radius*radius {
+				img.Set(px, py, c)
+			}
+		}
+	}
+}
@@ -21,8 +21,10 @@ func (f MandelbrotFractal) CreatePixelCalcJobFn(startPixX, startPixY, width, hei
 			for pixX := startPixX; pixX < startPixX+width; pixX++ {
 				cx, cy := f.PixelToFractal(pixX, pixY)
 				var fractRes FractFunctionResult
-				fractRes = Mandelbrot(cx, cy, f.MaxAbsSquareAmount, f.MaxIterations)
+				tracker := NewOrbitTracker(f.CommonFractParams, 0, complex(cx, cy), 2)
+				fractRes = Mandelbrot(cx, cy, f.MaxAbsSquareAmount, f.MaxIterations, &tracker)
 				setImagePixel(img, pixX, pixY, f.CommonFractParams, fractRes)
+				shadeImagePixel(img, pixX, pixY, f.CommonFractParams, fractRes)
 			}
 		}
 	}
@@ -51,10 +53,13 @@ cy = initial imaginary value, calculated from the actual pixel's y position
 The number is iterated as long as it is clear that is is either reaching the border |Z^2| > max
 or the max. number of iterations is reached.
 
+If an orbit tracker is given (may be nil), it is used to detect cycles (= interior points) early
+and to collect the orbit information for the interior coloring.
+
 @author Alexander Schenkel, www.alexi.ch
 (c) 2012-2025 Alexander Schenkel
 */
-func Mandelbrot(cx, cy, max_betrag_quadrat float64, maxIter int) FractFunctionResult {
+func Mandelbrot(cx, cy, max_betrag_quadrat float64, maxIter int, tracker *OrbitTracker) FractFunctionResult {
 	var betragQuadrat float64 = 0.0
 	var iter int = 0
 	// var x, xt float64 = 0.0, 0.0
@@ -81,6 +86,12 @@ func Mandelbrot(cx, cy, max_betrag_quadrat float64, maxIter int) FractFunctionRe
 		betragQuadrat = real(zt)*real(zt) + imag(zt)*imag(zt)
 		// betragQuadrat = x*x + y*y
 
+		if tracker != nil && tracker.Step(iter, real(z), imag(z)) {
+			// cycle found: the point is inside the set
+			iter = maxIter
+			break
+		}
+
 		// point distance to point:
 		// dist = math.Sqrt((x-2)*(x-2) + y*y)
 
@@ -101,9 +112,13 @@ func Mandelbrot(cx, cy, max_betrag_quadrat float64, maxIter int) FractFunctionRe
 	result := FractFunctionResult{
 		Iterations:   iter,
 		BailoutValue: betragQuadrat,
+		Interior:     betragQuadrat <= max_betrag_quadrat,
 		// for orbit trap:
 		// BailoutValue: minDist,
 		// BailoutValue: math.Sqrt(minDist),
 	}
+	if tracker != nil {
+		tracker.Finish(&result, real(z), imag(z))
+	}
 	return result
 }
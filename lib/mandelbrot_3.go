@@ -19,8 +19,10 @@ func (f Mandelbrot3Fractal) CreatePixelCalcJobFn(startPixX, startPixY, width, he
 			for pixX := startPixX; pixX < startPixX+width; pixX++ {
 				cx, cy := f.PixelToFractal(pixX, pixY)
 				var fractRes FractFunctionResult
-				fractRes = Mandelbrot3(cx, cy, f.MaxAbsSquareAmount, f.MaxIterations)
+				tracker := NewOrbitTracker(f.CommonFractParams, 0, complex(cx, cy), 3)
+				fractRes = Mandelbrot3(cx, cy, f.MaxAbsSquareAmount, f.MaxIterations, &tracker)
 				setImagePixel(img, pixX, pixY, f.CommonFractParams, fractRes)
+				shadeImagePixel(img, pixX, pixY, f.CommonFractParams, fractRes)
 			}
 		}
 	}
@@ -49,10 +51,13 @@ cy = initial imaginary value, calculated from the actual pixel's y position
 The number is iterated as long as it is clear that is is either reaching the border |Z^2| > max
 or the max. number of iterations is reached.
 
+If an orbit tracker is given (may be nil), it is used to detect cycles (= interior points) early
+and to collect the orbit information for the interior coloring.
+
 @author Alexander Schenkel, www.alexi.ch
 (c) 2012-2025 Alexander Schenkel
 */
-func Mandelbrot3(cx, cy, max_betrag_quadrat float64, maxIter int) FractFunctionResult {
+func Mandelbrot3(cx, cy, max_betrag_quadrat float64, maxIter int, tracker *OrbitTracker) FractFunctionResult {
 	var betragQuadrat float64 = 0.0
 	var iter int = 0
 	var x, xt float64 = 0.0, 0.0
@@ -67,10 +72,20 @@ func Mandelbrot3(cx, cy, max_betrag_quadrat float64, maxIter int) FractFunctionR
 		y = yt
 		iter += 1
 		betragQuadrat = x*x + y*y
+
+		if tracker != nil && tracker.Step(iter, x, y) {
+			// cycle found: the point is inside the set
+			iter = maxIter
+			break
+		}
 	}
 	result := FractFunctionResult{
 		Iterations:   iter,
 		BailoutValue: betragQuadrat,
+		Interior:     betragQuadrat <= max_betrag_quadrat,
+	}
+	if tracker != nil {
+		tracker.Finish(&result, x, y)
 	}
 	return result
 }
@@ -0,0 +1,245 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+The render params are embedded as JSON into the image files:
+
+  - png: an iTXt chunk with the keyword "fractgen", and a tEXt "Software" chunk with the generator
+  - jpeg: a COM (comment) segment, and an XMP packet (APP1 segment) with the generator as xmp:CreatorTool
+    and the JSON as fractgen:params property, as XMP is kept by most image editors
+*/
+
+const (
+	pngMetadataKeyword = "fractgen"
+	xmpNamespace       = "http://ns.adobe.com/xap/1.0/\x00"
+	fractgenXmpNs      = "https://github.com/bylexus/go-fractgen/ns/1.0/"
+	// max. payload of a jpeg segment: 65535 bytes, minus the 2 length bytes
+	jpegMaxSegmentSize = 65533
+	// max. size of a png iTXt chunk read for the render params: larger chunks are not written by fractgen
+	pngMaxMetadataChunkSize = 16 << 20
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// inserts the metadata chunks after the IHDR chunk of the encoded png
+func embedPngMetadata(data []byte, params RenderParams) ([]byte, error) {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	// the IHDR chunk: 4 bytes length, 4 bytes type, 13 bytes data, 4 bytes crc
+	ihdrEnd := len(pngSignature) + 25
+	if len(data) < ihdrEnd || !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("png: invalid image data")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	writePngChunk(&out, "tEXt", []byte("Software\x00"+params.Generator))
+	// iTXt: keyword, compression flag and method, language tag and translated keyword (both empty), text
+	itxt := append([]byte(pngMetadataKeyword+"\x00\x00\x00\x00\x00"), jsonData...)
+	writePngChunk(&out, "iTXt", itxt)
+	out.Write(data[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+func writePngChunk(w *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	w.WriteString(chunkType)
+	w.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// inserts the metadata segments after the SOI marker and the JFIF APP0 segment of the encoded jpeg.
+// Segments exceeding the max. size of 64KB (e.g. with long palettes) are left out.
+func embedJpegMetadata(data []byte, params RenderParams) ([]byte, error) {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("jpeg: invalid image data")
+	}
+	insertAt := 2
+	if data[2] == 0xff && data[3] == 0xe0 && len(data) >= 6 {
+		insertAt += 2 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, jsonData)
+	var generator bytes.Buffer
+	xml.EscapeText(&generator, []byte(params.Generator))
+	xmp := xmpNamespace + `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:fractgen="` + fractgenXmpNs + `">` +
+		`<xmp:CreatorTool>` + generator.String() + `</xmp:CreatorTool>` +
+		`<fractgen:params>` + escaped.String() + `</fractgen:params>` +
+		`</rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`
+
+	var out bytes.Buffer
+	out.Write(data[:insertAt])
+	if len(xmp) <= jpegMaxSegmentSize {
+		writeJpegSegment(&out, 0xe1, []byte(xmp))
+	}
+	if len(jsonData) <= jpegMaxSegmentSize {
+		writeJpegSegment(&out, 0xfe, jsonData)
+	}
+	out.Write(data[insertAt:])
+	return out.Bytes(), nil
+}
+
+func writeJpegSegment(w *bytes.Buffer, marker byte, data []byte) {
+	w.Write([]byte{0xff, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(data)+2))
+	w.Write(data)
+}
+
+func ReadRenderParamsFile(path string) (RenderParams, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return RenderParams{}, err
+	}
+	defer f.Close()
+	return ReadRenderParams(f)
+}
+
+// Reads the render params embedded in a png or jpeg image.
+func ReadRenderParams(r io.Reader) (RenderParams, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(pngSignature))
+	if err != nil {
+		return RenderParams{}, errors.New("unknown image format")
+	}
+	var jsonData []byte
+	switch {
+	case bytes.Equal(header, pngSignature):
+		jsonData, err = readPngMetadata(br)
+	case header[0] == 0xff && header[1] == 0xd8:
+		jsonData, err = readJpegMetadata(br)
+	default:
+		err = errors.New("unknown image format: only png and jpeg images contain render params")
+	}
+	if err != nil {
+		return RenderParams{}, err
+	}
+	if jsonData == nil {
+		return RenderParams{}, errors.New("the image contains no render params")
+	}
+
+	var params RenderParams
+	if err := json.Unmarshal(jsonData, &params); err != nil {
+		return RenderParams{}, err
+	}
+	return params, nil
+}
+
+func readPngMetadata(r io.Reader) ([]byte, error) {
+	if _, err := io.CopyN(io.Discard, r, int64(len(pngSignature))); err != nil {
+		return nil, err
+	}
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(chunkHeader[:4]))
+		switch string(chunkHeader[4:]) {
+		case "iTXt":
+			if length > pngMaxMetadataChunkSize {
+				return nil, errors.New("invalid png: iTXt chunk too large")
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			keyword, text, _ := bytes.Cut(data, []byte{0})
+			// uncompressed (0, 0), empty language tag and translated keyword:
+			if string(keyword) == pngMetadataKeyword && bytes.HasPrefix(text, []byte{0, 0, 0, 0}) {
+				return text[4:], nil
+			}
+			length = 0
+		case "IDAT", "IEND":
+			// the metadata is written before the image data
+			return nil, nil
+		}
+		// skip the chunk data and the crc:
+		if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reads the COM segment with the render params, or the XMP packet if there is no COM segment
+func readJpegMetadata(r io.Reader) ([]byte, error) {
+	if _, err := io.CopyN(io.Discard, r, 2); err != nil {
+		return nil, err
+	}
+	var xmpParams []byte
+	for {
+		var markerBytes [2]byte
+		if _, err := io.ReadFull(r, markerBytes[:]); err != nil {
+			return nil, err
+		}
+		marker := markerBytes[1]
+		if markerBytes[0] != 0xff || marker == 0xda || marker == 0xd9 {
+			// start of scan: the image data follows
+			return xmpParams, nil
+		}
+		if marker == 0x01 || marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) {
+			// TEM, SOI and RSTn are standalone markers, without a length
+			continue
+		}
+		var lengthBytes [2]byte
+		if _, err := io.ReadFull(r, lengthBytes[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(lengthBytes[:]))
+		if length < 2 {
+			return nil, errors.New("invalid jpeg: bad segment length")
+		}
+		data := make([]byte, length-2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == 0xfe && bytes.Contains(data, []byte(`"generator":"`+generatorName)):
+			return data, nil
+		case marker == 0xe1 && bytes.HasPrefix(data, []byte(xmpNamespace)):
+			xmpParams = readXmpParams(data[len(xmpNamespace):])
+		}
+	}
+}
+
+// returns the content of the fractgen:params property of the XMP packet
+func readXmpParams(xmp []byte) []byte {
+	decoder := xml.NewDecoder(bytes.NewReader(xmp))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		start, ok := token.(xml.StartElement)
+		if ok && start.Name.Space == fractgenXmpNs && start.Name.Local == "params" {
+			var value string
+			if decoder.DecodeElement(&value, &start) != nil {
+				return nil
+			}
+			return []byte(strings.TrimSpace(value))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+/*
+Encoders for the Netpbm formats PPM (binary RGB) and PFM (floating point RGB), e.g. as input for
+image processing pipelines. Both have no alpha channel: transparent pixels are composited over black.
+*/
+
+// Encodes the image as binary PPM (P6): *image.RGBA64 and *image.NRGBA64 images with 16 bit per channel, all others with 8 bit.
+func EncodePpm(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	bytesPerSample := 1
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64:
+		bytesPerSample = 2
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n%d\n", bounds.Dx(), bounds.Dy(), 1<<(8*bytesPerSample)-1)
+	row := make([]byte, bounds.Dx()*3*bytesPerSample)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// the premultiplied color is the color over black:
+			r, g, b, _ := img.At(x, y).RGBA()
+			offset := (x - bounds.Min.X) * 3 * bytesPerSample
+			for i, v := range [3]uint32{r, g, b} {
+				if bytesPerSample == 2 {
+					binary.BigEndian.PutUint16(row[offset+2*i:], uint16(v))
+				} else {
+					row[offset+i] = uint8(v >> 8)
+				}
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Encodes the image as PFM, with linear (gamma-decoded) float values, little-endian and bottom-up as defined by the format.
+func EncodePfm(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+
+	bw := bufio.NewWriter(w)
+	// a negative scale denotes little-endian values:
+	fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", bounds.Dx(), bounds.Dy())
+	row := make([]byte, bounds.Dx()*3*4)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			offset := (x - bounds.Min.X) * 3 * 4
+			for i, v := range [3]uint32{r, g, b} {
+				linear := float32(srgbToLinear(float64(v) / 0xffff))
+				binary.LittleEndian.PutUint32(row[offset+4*i:], math.Float32bits(linear))
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
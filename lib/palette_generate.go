@@ -0,0 +1,387 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+type PaletteGenerator string
+
+const (
+	PALETTE_GENERATOR_COSINE     = "cosine"
+	PALETTE_GENERATOR_HARMONY    = "harmony"
+	PALETTE_GENERATOR_RANDOM     = "random"
+	PALETTE_GENERATOR_KMEANS     = "kmeans"
+	PALETTE_GENERATOR_MEDIAN_CUT = "median-cut"
+)
+
+type ColorHarmony string
+
+const (
+	COLOR_HARMONY_MONOCHROMATIC       = "monochromatic"
+	COLOR_HARMONY_COMPLEMENTARY       = "complementary"
+	COLOR_HARMONY_ANALOGOUS           = "analogous"
+	COLOR_HARMONY_TRIADIC             = "triadic"
+	COLOR_HARMONY_SPLIT_COMPLEMENTARY = "split-complementary"
+	COLOR_HARMONY_TETRADIC            = "tetradic"
+)
+
+const maxGeneratedColors = 256
+
+// the number of pixels sampled from a reference image for the color extraction
+const extractionSampleSize = 20000
+
+type PaletteGenerateOptions struct {
+	Generator PaletteGenerator
+	// number of colors (palette entries) to generate
+	Colors int
+
+	// cosine gradient: color(t) = a + b * cos(2π(c*t + d)), with one value per r, g, b channel
+	CosineA, CosineB, CosineC, CosineD [3]float64
+
+	// harmony: the base hue (degrees), the harmony and the saturation / lightness (0.0 - 1.0) of the base color
+	BaseHue    float64
+	Harmony    ColorHarmony
+	Saturation float64
+	Lightness  float64
+
+	// random palettes, and the initial cluster centers of k-means
+	Seed uint64
+
+	// kmeans, median-cut: the reference image to extract the dominant colors from
+	Image image.Image
+}
+
+// Default options for the cosine gradient: a full rainbow
+func DefaultPaletteGenerateOptions() PaletteGenerateOptions {
+	return PaletteGenerateOptions{
+		Generator:  PALETTE_GENERATOR_COSINE,
+		Colors:     16,
+		CosineA:    [3]float64{0.5, 0.5, 0.5},
+		CosineB:    [3]float64{0.5, 0.5, 0.5},
+		CosineC:    [3]float64{1, 1, 1},
+		CosineD:    [3]float64{0, 0.33, 0.67},
+		Harmony:    COLOR_HARMONY_COMPLEMENTARY,
+		Saturation: 0.8,
+		Lightness:  0.5,
+	}
+}
+
+/*
+Generates a color palette procedurally, or by extracting the dominant colors of an image:
+
+  - cosine: samples the cosine gradient formula a + b * cos(2π(c*t + d)) at evenly spaced t (0.0 - 1.0).
+    With integer c values, the gradient wraps around seamlessly, as the palette does.
+  - harmony: colors from a base hue and its harmonic hues (e.g. complementary: base hue + 180°),
+    each in darker and lighter shades.
+  - random: random colors, reproducible with the same seed.
+  - kmeans: the cluster centers of a k-means clustering of the image pixels, in the OKLab color space.
+  - median-cut: the average colors of the boxes of the median cut algorithm, in the RGB cube.
+
+The extracted colors are sorted by lightness, which gives a smooth gradient from dark to light.
+*/
+func GeneratePalette(opts PaletteGenerateOptions) (ColorPalette, error) {
+	if opts.Colors < 2 || opts.Colors > maxGeneratedColors {
+		return nil, fmt.Errorf("number of colors must be between 2 and %d", maxGeneratedColors)
+	}
+
+	var colors []color.RGBA
+	switch opts.Generator {
+	case PALETTE_GENERATOR_COSINE:
+		colors = cosineColors(opts)
+	case PALETTE_GENERATOR_HARMONY:
+		var err error
+		colors, err = harmonyColors(opts)
+		if err != nil {
+			return nil, err
+		}
+	case PALETTE_GENERATOR_RANDOM:
+		colors = randomColors(opts)
+	case PALETTE_GENERATOR_KMEANS, PALETTE_GENERATOR_MEDIAN_CUT:
+		if opts.Image == nil {
+			return nil, fmt.Errorf("the %s generator needs a reference image", opts.Generator)
+		}
+		pixels := samplePixels(opts.Image, opts.Seed)
+		if len(pixels) == 0 {
+			return nil, errors.New("the reference image has no visible pixels")
+		}
+		if opts.Generator == PALETTE_GENERATOR_KMEANS {
+			colors = kMeansColors(pixels, opts.Colors, opts.Seed)
+		} else {
+			colors = medianCutColors(pixels, opts.Colors)
+		}
+		sortByLightness(colors)
+	default:
+		return nil, errors.New("unknown palette generator")
+	}
+
+	palette := make(ColorPalette, len(colors))
+	for i, c := range colors {
+		palette[i] = PaletteEntry{c, 0}
+	}
+	return palette, nil
+}
+
+func cosineColors(opts PaletteGenerateOptions) []color.RGBA {
+	colors := make([]color.RGBA, opts.Colors)
+	for i := range colors {
+		t := float64(i) / float64(opts.Colors)
+		var rgb colorComponents
+		for ch := range rgb {
+			rgb[ch] = opts.CosineA[ch] + opts.CosineB[ch]*math.Cos(2*math.Pi*(opts.CosineC[ch]*t+opts.CosineD[ch]))
+		}
+		colors[i] = fromColorSpace(rgb, COLOR_SPACE_RGB)
+	}
+	return colors
+}
+
+// the hue offsets (degrees) of the harmonic hues, relative to the base hue
+func harmonyHueOffsets(harmony ColorHarmony) ([]float64, error) {
+	switch harmony {
+	case COLOR_HARMONY_MONOCHROMATIC:
+		return []float64{0}, nil
+	case COLOR_HARMONY_COMPLEMENTARY:
+		return []float64{0, 180}, nil
+	case COLOR_HARMONY_ANALOGOUS:
+		return []float64{-30, 0, 30}, nil
+	case COLOR_HARMONY_TRIADIC:
+		return []float64{0, 120, 240}, nil
+	case COLOR_HARMONY_SPLIT_COMPLEMENTARY:
+		return []float64{0, 150, 210}, nil
+	case COLOR_HARMONY_TETRADIC:
+		return []float64{0, 90, 180, 270}, nil
+	default:
+		return nil, errors.New("unknown color harmony")
+	}
+}
+
+// the colors cycle through the harmonic hues; each round through the hues uses another shade,
+// alternating between darker and lighter than the base lightness.
+func harmonyColors(opts PaletteGenerateOptions) ([]color.RGBA, error) {
+	offsets, err := harmonyHueOffsets(opts.Harmony)
+	if err != nil {
+		return nil, err
+	}
+	rounds := (opts.Colors + len(offsets) - 1) / len(offsets)
+
+	colors := make([]color.RGBA, opts.Colors)
+	for i := range colors {
+		hue := math.Mod(opts.BaseHue+offsets[i%len(offsets)]+360, 360)
+		lightness := opts.Lightness
+		if rounds > 1 {
+			// spread the shades over [lightness - 0.35, lightness + 0.35]:
+			shade := float64(i/len(offsets))/float64(rounds-1)*0.7 - 0.35
+			lightness = math.Max(0.05, math.Min(0.95, opts.Lightness+shade))
+		}
+		colors[i] = fromColorSpace(colorComponents{hue, opts.Saturation, lightness}, COLOR_SPACE_HSL)
+	}
+	return colors, nil
+}
+
+// random colors in HSL: random hue, and saturation / lightness ranges that avoid dull or washed-out colors
+func randomColors(opts PaletteGenerateOptions) []color.RGBA {
+	rnd := rand.New(rand.NewPCG(opts.Seed, opts.Seed))
+	colors := make([]color.RGBA, opts.Colors)
+	for i := range colors {
+		colors[i] = fromColorSpace(colorComponents{
+			rnd.Float64() * 360,
+			0.4 + rnd.Float64()*0.6,
+			0.15 + rnd.Float64()*0.7,
+		}, COLOR_SPACE_HSL)
+	}
+	return colors
+}
+
+// returns (up to extractionSampleSize) non-transparent pixels of the image, in random order
+func samplePixels(img image.Image, seed uint64) []color.RGBA {
+	bounds := img.Bounds()
+	stride := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/extractionSampleSize)))
+	var pixels []color.RGBA
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stride {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stride {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			pixels = append(pixels, color.RGBA{c.R, c.G, c.B, 255})
+		}
+	}
+	rnd := rand.New(rand.NewPCG(seed, seed))
+	rnd.Shuffle(len(pixels), func(i, j int) { pixels[i], pixels[j] = pixels[j], pixels[i] })
+	return pixels
+}
+
+/*
+K-means clustering of the pixels in the OKLab color space, so that the clusters correspond to perceptually
+similar colors. The initial cluster centers are chosen with k-means++: each further center is chosen with a
+probability proportional to the squared distance to the nearest center so far.
+*/
+func kMeansColors(pixels []color.RGBA, k int, seed uint64) []color.RGBA {
+	points := make([]colorComponents, len(pixels))
+	for i, p := range pixels {
+		points[i] = toColorSpace(p, COLOR_SPACE_OKLAB)
+	}
+	k = min(k, len(points))
+	rnd := rand.New(rand.NewPCG(seed, seed+1))
+
+	centers := []colorComponents{points[rnd.IntN(len(points))]}
+	dists := make([]float64, len(points))
+	for len(centers) < k {
+		var sum float64
+		for i, p := range points {
+			dists[i] = math.Inf(1)
+			for _, c := range centers {
+				dists[i] = math.Min(dists[i], componentDistSq(p, c))
+			}
+			sum += dists[i]
+		}
+		if sum == 0 {
+			// less distinct colors than clusters
+			break
+		}
+		target := rnd.Float64() * sum
+		next := len(points) - 1
+		for i, d := range dists {
+			target -= d
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centers = append(centers, points[next])
+	}
+
+	assignment := make([]int, len(points))
+	for iteration := 0; iteration < 30; iteration++ {
+		changed := false
+		for i, p := range points {
+			nearest := 0
+			for c := range centers {
+				if componentDistSq(p, centers[c]) < componentDistSq(p, centers[nearest]) {
+					nearest = c
+				}
+			}
+			if nearest != assignment[i] || iteration == 0 {
+				assignment[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([]colorComponents, len(centers))
+		counts := make([]int, len(centers))
+		for i, p := range points {
+			for ch := range p {
+				sums[assignment[i]][ch] += p[ch]
+			}
+			counts[assignment[i]]++
+		}
+		for c := range centers {
+			if counts[c] == 0 {
+				continue
+			}
+			for ch := range sums[c] {
+				centers[c][ch] = sums[c][ch] / float64(counts[c])
+			}
+		}
+	}
+
+	colors := make([]color.RGBA, len(centers))
+	for i, c := range centers {
+		colors[i] = fromColorSpace(c, COLOR_SPACE_OKLAB)
+	}
+	return colors
+}
+
+func componentDistSq(a, b colorComponents) float64 {
+	return (a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2])
+}
+
+/*
+Median cut: starting with a box containing all pixels, the box with the largest color range is split
+at the median of its widest color channel, until there are as many boxes as colors. The colors are the
+average colors of the boxes.
+*/
+func medianCutColors(pixels []color.RGBA, nrOfColors int) []color.RGBA {
+	boxes := [][]color.RGBA{pixels}
+	for len(boxes) < nrOfColors {
+		widest, widestChannel, widestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			channel, colorRange := widestColorChannel(box)
+			if colorRange > widestRange {
+				widest, widestChannel, widestRange = i, channel, colorRange
+			}
+		}
+		if widest < 0 {
+			// no box can be split any further
+			break
+		}
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool {
+			return colorChannel(box[i], widestChannel) < colorChannel(box[j], widestChannel)
+		})
+		median := len(box) / 2
+		boxes[widest] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	colors := make([]color.RGBA, len(boxes))
+	for i, box := range boxes {
+		var r, g, b int
+		for _, c := range box {
+			r, g, b = r+int(c.R), g+int(c.G), b+int(c.B)
+		}
+		colors[i] = color.RGBA{uint8(r / len(box)), uint8(g / len(box)), uint8(b / len(box)), 255}
+	}
+	return colors
+}
+
+// returns the channel (0 = r, 1 = g, 2 = b) with the largest value range within the pixels, and the range
+func widestColorChannel(pixels []color.RGBA) (int, int) {
+	var widest, widestRange int
+	for channel := 0; channel < 3; channel++ {
+		minV, maxV := 255, 0
+		for _, c := range pixels {
+			v := colorChannel(c, channel)
+			minV, maxV = min(minV, v), max(maxV, v)
+		}
+		if maxV-minV > widestRange {
+			widest, widestRange = channel, maxV-minV
+		}
+	}
+	return widest, widestRange
+}
+
+func colorChannel(c color.RGBA, channel int) int {
+	switch channel {
+	case 0:
+		return int(c.R)
+	case 1:
+		return int(c.G)
+	default:
+		return int(c.B)
+	}
+}
+
+func sortByLightness(colors []color.RGBA) {
+	slices.SortStableFunc(colors, func(a, b color.RGBA) int {
+		la, lb := toColorSpace(a, COLOR_SPACE_OKLAB)[0], toColorSpace(b, COLOR_SPACE_OKLAB)[0]
+		switch {
+		case la < lb:
+			return -1
+		case la > lb:
+			return 1
+		}
+		return 0
+	})
+}
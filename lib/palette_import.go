@@ -0,0 +1,750 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+type PaletteFormat string
+
+const (
+	PALETTE_FORMAT_GGR   = "ggr"
+	PALETTE_FORMAT_GPL   = "gpl"
+	PALETTE_FORMAT_ASE   = "ase"
+	PALETTE_FORMAT_CSS   = "css"
+	PALETTE_FORMAT_UGR   = "ugr"
+	PALETTE_FORMAT_MAP   = "map"
+	PALETTE_FORMAT_IMAGE = "image"
+)
+
+// the total length (sum of the steps) of imported gradients with color stop positions
+const importedPaletteLength = 1024
+
+type PaletteImportOptions struct {
+	// Name of the imported color preset. If empty, the name from the file is used, or the fallback name.
+	Name string
+	// Name used if neither a name is given nor the file contains one, e.g. the file name.
+	FallbackName string
+	// for images: the pixel row to take the colors from. -1 = the middle row.
+	ImageRow int
+	// for images: the number of colors to sample from the pixel row.
+	ImageColors int
+	// for images: the maximum number of pixels (width x height) of the image. 0 = DEFAULT_MAX_IMPORT_IMAGE_PIXELS.
+	MaxImagePixels int
+}
+
+// the default maximum size of imported images: a small compressed image can decode to a huge one
+const DEFAULT_MAX_IMPORT_IMAGE_PIXELS = 100_000_000
+
+// Detects the palette format by the file's extension.
+func PaletteFormatFromFilename(filename string) (PaletteFormat, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ggr":
+		return PALETTE_FORMAT_GGR, nil
+	case ".gpl":
+		return PALETTE_FORMAT_GPL, nil
+	case ".ase":
+		return PALETTE_FORMAT_ASE, nil
+	case ".css", ".txt":
+		return PALETTE_FORMAT_CSS, nil
+	case ".ugr":
+		return PALETTE_FORMAT_UGR, nil
+	case ".map":
+		return PALETTE_FORMAT_MAP, nil
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return PALETTE_FORMAT_IMAGE, nil
+	default:
+		return "", errors.New("unknown palette format")
+	}
+}
+
+/*
+Imports color presets from an external gradient / palette format:
+
+  - ggr: GIMP gradient
+  - gpl: GIMP palette
+  - ase: Adobe Swatch Exchange, one preset per color group
+  - css: a CSS gradient string, e.g. "linear-gradient(90deg, #ff8800 0%, rgb(0, 0, 30) 100%)"
+  - ugr: UltraFractal gradients, one preset per gradient in the file
+  - map: Fractint color map
+  - image: a row of pixels of a png / jpeg / gif image
+
+Gradient formats with color stop positions are converted to palette entries with corresponding steps,
+discrete palettes (gpl, ase, map, image) use the same length for each color.
+*/
+func ImportColorPresets(r io.Reader, format PaletteFormat, opts PaletteImportOptions) (ColorPresets, error) {
+	var presets ColorPresets
+	var err error
+
+	switch format {
+	case PALETTE_FORMAT_GGR:
+		presets, err = importGgr(r)
+	case PALETTE_FORMAT_GPL:
+		presets, err = importGpl(r)
+	case PALETTE_FORMAT_ASE:
+		presets, err = importAse(r)
+	case PALETTE_FORMAT_CSS:
+		presets, err = importCssGradient(r)
+	case PALETTE_FORMAT_UGR:
+		presets, err = importUgr(r)
+	case PALETTE_FORMAT_MAP:
+		presets, err = importFractintMap(r)
+	case PALETTE_FORMAT_IMAGE:
+		presets, err = importImageRow(r, opts.ImageRow, opts.ImageColors, opts.MaxImagePixels)
+	default:
+		return nil, errors.New("unknown palette format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(presets) == 0 {
+		return nil, errors.New("no palette found")
+	}
+
+	for i := range presets {
+		if opts.Name != "" {
+			presets[i].Name = opts.Name
+			if len(presets) > 1 {
+				presets[i].Name = fmt.Sprintf("%s %d", opts.Name, i+1)
+			}
+		}
+		if presets[i].Name == "" {
+			presets[i].Name = opts.FallbackName
+			if len(presets) > 1 {
+				presets[i].Name = fmt.Sprintf("%s %d", opts.FallbackName, i+1)
+			}
+		}
+		presets[i].Ident = PresetIdent(presets[i].Name)
+		if len(presets[i].Palette) < 2 {
+			return nil, fmt.Errorf("palette '%s' has less than 2 colors", presets[i].Name)
+		}
+	}
+	return presets, nil
+}
+
+// creates a preset ident from a name, e.g. "Women's Choice" --> "women-s-choice"
+func PresetIdent(name string) string {
+	var ident strings.Builder
+	var dash = false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && ident.Len() > 0 {
+				ident.WriteRune('-')
+			}
+			ident.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return ident.String()
+}
+
+type colorStop struct {
+	pos   float64
+	color color.RGBA
+}
+
+// converts color stops with positions (0.0 - 1.0) to a palette: the length of the segment between
+// two stops is converted to the steps of the first stop. The palette wraps around, so the last stop's
+// length is the distance to the first stop.
+func paletteFromStops(stops []colorStop) ColorPalette {
+	sort.SliceStable(stops, func(i, j int) bool { return stops[i].pos < stops[j].pos })
+	palette := make(ColorPalette, len(stops))
+	for i, stop := range stops {
+		var length float64
+		if i+1 < len(stops) {
+			length = stops[i+1].pos - stop.pos
+		} else {
+			length = 1 - stop.pos + stops[0].pos
+		}
+		steps := -1
+		if length > 1e-9 {
+			steps = max(1, int(math.Round(length*importedPaletteLength)))
+		}
+		palette[i] = PaletteEntry{stop.color, steps}
+	}
+	return palette
+}
+
+func floatToByte(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+// GIMP gradient: a list of segments with left / middle / right positions and a left and right color (RGBA, 0.0 - 1.0).
+func importGgr(r io.Reader) (ColorPresets, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "GIMP Gradient" {
+		return nil, errors.New("not a GIMP gradient file")
+	}
+	var preset ColorPreset
+	var stops []colorStop
+	var firstColor *color.RGBA
+	var segments [][]float64
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := strings.CutPrefix(line, "Name:"); ok {
+			preset.Name = strings.TrimSpace(name)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 11 {
+			// the segment count, or an empty line
+			continue
+		}
+		values := make([]float64, 11)
+		for i := range values {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gradient segment: %s", line)
+			}
+			values[i] = v
+		}
+		segments = append(segments, values)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, seg := range segments {
+		left := color.RGBA{floatToByte(seg[3]), floatToByte(seg[4]), floatToByte(seg[5]), floatToByte(seg[6])}
+		right := color.RGBA{floatToByte(seg[7]), floatToByte(seg[8]), floatToByte(seg[9]), floatToByte(seg[10])}
+		if firstColor == nil {
+			firstColor = &left
+		}
+		stops = append(stops, colorStop{seg[0], left})
+
+		// a different color at the start of the next segment results in a hard stop:
+		nextLeft := *firstColor
+		if i+1 < len(segments) {
+			next := segments[i+1]
+			nextLeft = color.RGBA{floatToByte(next[3]), floatToByte(next[4]), floatToByte(next[5]), floatToByte(next[6])}
+		}
+		if right != nextLeft {
+			stops = append(stops, colorStop{seg[2], right})
+		}
+	}
+	if len(stops) == 0 {
+		return nil, nil
+	}
+	preset.Palette = paletteFromStops(stops)
+	return ColorPresets{preset}, nil
+}
+
+// GIMP palette: a list of "R G B name" lines
+func importGpl(r io.Reader) (ColorPresets, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "GIMP Palette" {
+		return nil, errors.New("not a GIMP palette file")
+	}
+	var preset ColorPreset
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := strings.CutPrefix(line, "Name:"); ok {
+			preset.Name = strings.TrimSpace(name)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "Columns:") {
+			continue
+		}
+		c, err := parseRgbFields(strings.Fields(line))
+		if err != nil {
+			return nil, err
+		}
+		preset.Palette = append(preset.Palette, PaletteEntry{c, 0})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ColorPresets{preset}, nil
+}
+
+// Fractint map: 256 lines of "R G B", optionally followed by a comment
+func importFractintMap(r io.Reader) (ColorPresets, error) {
+	scanner := bufio.NewScanner(r)
+	var preset ColorPreset
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		c, err := parseRgbFields(fields)
+		if err != nil {
+			return nil, err
+		}
+		preset.Palette = append(preset.Palette, PaletteEntry{c, 0})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ColorPresets{preset}, nil
+}
+
+func parseRgbFields(fields []string) (color.RGBA, error) {
+	if len(fields) < 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", strings.Join(fields, " "))
+	}
+	var rgb [3]uint8
+	for i := range rgb {
+		v, err := strconv.Atoi(fields[i])
+		if err != nil || v < 0 || v > 255 {
+			return color.RGBA{}, fmt.Errorf("invalid color: %s", strings.Join(fields, " "))
+		}
+		rgb[i] = uint8(v)
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}, nil
+}
+
+/*
+Adobe Swatch Exchange: a binary format (big endian):
+
+	"ASEF", version (2x uint16), number of blocks (uint32)
+	blocks: type (uint16), length (uint32), data
+
+Block types are 0xC001 (group start), 0xC002 (group end) and 0x0001 (color entry). Group starts and
+color entries begin with a UTF-16 name (length in uint16 chars, incl. 0-termination). A color entry's
+name is followed by the color model ("RGB ", "CMYK", "LAB ", "Gray") and the float32 color values.
+*/
+func importAse(r io.Reader) (ColorPresets, error) {
+	var header struct {
+		Signature [4]byte
+		Major     uint16
+		Minor     uint16
+		Blocks    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil || string(header.Signature[:]) != "ASEF" {
+		return nil, errors.New("not an Adobe Swatch Exchange file")
+	}
+
+	var presets ColorPresets
+	var ungrouped ColorPreset
+	var group *ColorPreset
+	for i := uint32(0); i < header.Blocks; i++ {
+		var blockType uint16
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &blockType); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		// read into a growing buffer: the length is not trusted, a broken file may end early
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, r, int64(length)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		block := bytes.NewReader(data.Bytes())
+
+		switch blockType {
+		case 0xC001:
+			name, err := readAseName(block)
+			if err != nil {
+				return nil, err
+			}
+			group = &ColorPreset{Name: name}
+		case 0xC002:
+			if group != nil {
+				presets = append(presets, *group)
+			}
+			group = nil
+		case 0x0001:
+			if _, err := readAseName(block); err != nil {
+				return nil, err
+			}
+			c, err := readAseColor(block)
+			if err != nil {
+				return nil, err
+			}
+			if group != nil {
+				group.Palette = append(group.Palette, PaletteEntry{c, 0})
+			} else {
+				ungrouped.Palette = append(ungrouped.Palette, PaletteEntry{c, 0})
+			}
+		}
+	}
+	if len(ungrouped.Palette) > 0 {
+		presets = append(ColorPresets{ungrouped}, presets...)
+	}
+	return presets, nil
+}
+
+func readAseName(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	chars := make([]uint16, length)
+	if err := binary.Read(r, binary.BigEndian, chars); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(utf16.Decode(chars)), "\x00"), nil
+}
+
+func readAseColor(r io.Reader) (color.RGBA, error) {
+	var model [4]byte
+	if err := binary.Read(r, binary.BigEndian, &model); err != nil {
+		return color.RGBA{}, err
+	}
+	var nrOfValues int
+	switch string(model[:]) {
+	case "RGB ", "LAB ":
+		nrOfValues = 3
+	case "CMYK":
+		nrOfValues = 4
+	case "Gray":
+		nrOfValues = 1
+	default:
+		return color.RGBA{}, fmt.Errorf("unknown color model: %s", string(model[:]))
+	}
+	values := make([]float32, nrOfValues)
+	if err := binary.Read(r, binary.BigEndian, values); err != nil {
+		return color.RGBA{}, err
+	}
+
+	switch string(model[:]) {
+	case "RGB ":
+		return color.RGBA{floatToByte(float64(values[0])), floatToByte(float64(values[1])), floatToByte(float64(values[2])), 255}, nil
+	case "LAB ":
+		// L is given as 0.0 - 1.0, a / b as -128 - 127:
+		return fromColorSpace(colorComponents{float64(values[0]) * 100, float64(values[1]), float64(values[2])}, COLOR_SPACE_LAB), nil
+	case "CMYK":
+		k := 1 - float64(values[3])
+		return color.RGBA{
+			floatToByte((1 - float64(values[0])) * k),
+			floatToByte((1 - float64(values[1])) * k),
+			floatToByte((1 - float64(values[2])) * k),
+			255,
+		}, nil
+	default:
+		gray := floatToByte(float64(values[0]))
+		return color.RGBA{gray, gray, gray, 255}, nil
+	}
+}
+
+/*
+UltraFractal gradients: a file can contain several gradients:
+
+	name {
+	gradient:
+	  title="..." smooth=yes
+	  index=0 color=8716287
+	  index=200 color=16777215
+	}
+
+The indexes range from 0 to 399, the colors are decimal BGR values (r + 256 * g + 65536 * b).
+*/
+var ugrIndexColorRegexp = regexp.MustCompile(`index=(-?\d+)\s+color=(\d+)`)
+var ugrTitleRegexp = regexp.MustCompile(`title="([^"]*)"`)
+
+func importUgr(r io.Reader) (ColorPresets, error) {
+	scanner := bufio.NewScanner(r)
+	var presets ColorPresets
+	var preset *ColorPreset
+	var stops []colorStop
+	var inOpacity = false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasSuffix(line, "{"):
+			preset = &ColorPreset{Name: strings.TrimSpace(strings.TrimSuffix(line, "{"))}
+			stops = nil
+			inOpacity = false
+		case line == "}":
+			if preset != nil && len(stops) > 0 {
+				preset.Palette = paletteFromStops(stops)
+				presets = append(presets, *preset)
+			}
+			preset = nil
+		case strings.HasPrefix(line, "opacity:"):
+			inOpacity = true
+		case strings.HasPrefix(line, "gradient:"):
+			inOpacity = false
+		case preset != nil && !inOpacity:
+			if m := ugrTitleRegexp.FindStringSubmatch(line); m != nil && m[1] != "" {
+				preset.Name = m[1]
+			}
+			for _, m := range ugrIndexColorRegexp.FindAllStringSubmatch(line, -1) {
+				index, _ := strconv.Atoi(m[1])
+				bgr, _ := strconv.Atoi(m[2])
+				c := color.RGBA{uint8(bgr & 0xff), uint8((bgr >> 8) & 0xff), uint8((bgr >> 16) & 0xff), 255}
+				pos := math.Mod(float64(index)/400.0+1, 1)
+				stops = append(stops, colorStop{pos, c})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// CSS gradient, e.g. "linear-gradient(to right, red, #ff8800 40%, rgb(0 0 30) 100%)"
+func importCssGradient(r io.Reader) (ColorPresets, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	gradient := strings.TrimSpace(string(data))
+	gradient = strings.TrimSuffix(gradient, ";")
+	start := strings.Index(gradient, "(")
+	if colon := strings.Index(gradient, ":"); colon >= 0 && colon < start {
+		// a css declaration, e.g. "background: linear-gradient(...)"
+		gradient = strings.TrimSpace(gradient[colon+1:])
+		start = strings.Index(gradient, "(")
+	}
+	end := strings.LastIndex(gradient, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("not a CSS gradient")
+	}
+
+	var colors []color.RGBA
+	var positions []float64
+	for i, arg := range splitCssArgs(gradient[start+1 : end]) {
+		colorPart, posPart := splitCssColorStop(arg)
+		c, err := ParseCssColor(colorPart)
+		if err != nil {
+			if i == 0 {
+				// direction / angle / shape, e.g. "to right", "90deg", "circle at center"
+				continue
+			}
+			return nil, err
+		}
+		pos := math.NaN()
+		if posPart != "" {
+			p, err := strconv.ParseFloat(strings.TrimSuffix(posPart, "%"), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid color stop position: %s", posPart)
+			}
+			pos = p / 100
+		}
+		colors = append(colors, c)
+		positions = append(positions, pos)
+	}
+	if len(colors) == 0 {
+		return nil, nil
+	}
+
+	// missing positions: the first / last stop are at 0% / 100%, the others are distributed evenly in-between
+	if math.IsNaN(positions[0]) {
+		positions[0] = 0
+	}
+	if math.IsNaN(positions[len(positions)-1]) {
+		positions[len(positions)-1] = 1
+	}
+	for i := 1; i < len(positions); i++ {
+		if !math.IsNaN(positions[i]) {
+			continue
+		}
+		j := i
+		for math.IsNaN(positions[j]) {
+			j++
+		}
+		for k := i; k < j; k++ {
+			positions[k] = positions[i-1] + (positions[j]-positions[i-1])*float64(k-i+1)/float64(j-i+1)
+		}
+	}
+
+	stops := make([]colorStop, len(colors))
+	for i := range colors {
+		stops[i] = colorStop{positions[i], colors[i]}
+	}
+	return ColorPresets{{Palette: paletteFromStops(stops)}}, nil
+}
+
+// splits a comma-separated argument list, respecting parentheses
+func splitCssArgs(s string) []string {
+	var args []string
+	var depth, start int
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// splits a color stop like "rgb(0 0 30) 40%" into the color and the position
+func splitCssColorStop(stop string) (string, string) {
+	idx := strings.LastIndex(stop, " ")
+	if idx < 0 || strings.LastIndex(stop, ")") > idx {
+		return stop, ""
+	}
+	return strings.TrimSpace(stop[:idx]), strings.TrimSpace(stop[idx+1:])
+}
+
+var cssNamedColors = map[string]color.RGBA{
+	"black":       {0, 0, 0, 255},
+	"white":       {255, 255, 255, 255},
+	"red":         {255, 0, 0, 255},
+	"lime":        {0, 255, 0, 255},
+	"green":       {0, 128, 0, 255},
+	"blue":        {0, 0, 255, 255},
+	"yellow":      {255, 255, 0, 255},
+	"cyan":        {0, 255, 255, 255},
+	"aqua":        {0, 255, 255, 255},
+	"magenta":     {255, 0, 255, 255},
+	"fuchsia":     {255, 0, 255, 255},
+	"gray":        {128, 128, 128, 255},
+	"grey":        {128, 128, 128, 255},
+	"silver":      {192, 192, 192, 255},
+	"maroon":      {128, 0, 0, 255},
+	"olive":       {128, 128, 0, 255},
+	"purple":      {128, 0, 128, 255},
+	"teal":        {0, 128, 128, 255},
+	"navy":        {0, 0, 128, 255},
+	"orange":      {255, 165, 0, 255},
+	"transparent": {0, 0, 0, 0},
+}
+
+/*
+Parses a CSS color value:
+
+  - hex notation: #rgb, #rgba, #rrggbb, #rrggbbaa
+  - functional notation: rgb(255, 136, 0), rgba(255, 136, 0, 0.5), rgb(255 136 0 / 50%), hsl(32, 100%, 50%)
+  - basic named colors, e.g. "orange"
+*/
+func ParseCssColor(s string) (color.RGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := cssNamedColors[s]; ok {
+		return c, nil
+	}
+	if hex, ok := strings.CutPrefix(s, "#"); ok {
+		return parseHexColor(hex)
+	}
+
+	start := strings.Index(s, "(")
+	if start < 0 || !strings.HasSuffix(s, ")") {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	fn := s[:start]
+	args := strings.FieldsFunc(s[start+1:len(s)-1], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '/'
+	})
+	if len(args) < 3 || len(args) > 4 {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSuffix(arg, "%"), "deg"), 64)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+		}
+		if strings.HasSuffix(arg, "%") {
+			v /= 100
+			if (fn == "rgb" || fn == "rgba") && i < 3 {
+				v *= 255
+			}
+		}
+		values[i] = v
+	}
+	alpha := uint8(255)
+	if len(values) == 4 {
+		alpha = floatToByte(values[3])
+	}
+
+	switch fn {
+	case "rgb", "rgba":
+		return color.RGBA{
+			uint8(math.Round(math.Max(0, math.Min(255, values[0])))),
+			uint8(math.Round(math.Max(0, math.Min(255, values[1])))),
+			uint8(math.Round(math.Max(0, math.Min(255, values[2])))),
+			alpha,
+		}, nil
+	case "hsl", "hsla":
+		c := fromColorSpace(colorComponents{math.Mod(values[0]+360, 360), values[1], values[2]}, COLOR_SPACE_HSL)
+		c.A = alpha
+		return c, nil
+	default:
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+}
+
+func parseHexColor(hex string) (color.RGBA, error) {
+	invalidErr := fmt.Errorf("invalid color: #%s", hex)
+	if len(hex) == 3 || len(hex) == 4 {
+		var expanded strings.Builder
+		for _, r := range hex {
+			expanded.WriteRune(r)
+			expanded.WriteRune(r)
+		}
+		hex = expanded.String()
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.RGBA{}, invalidErr
+	}
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// samples the given number of colors from a pixel row of an image, which must not exceed maxPixels
+func importImageRow(r io.Reader, row int, nrOfColors int, maxPixels int) (ColorPresets, error) {
+	if maxPixels <= 0 {
+		maxPixels = DEFAULT_MAX_IMPORT_IMAGE_PIXELS
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// the size is checked before decoding the image:
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width > 0 && config.Height > maxPixels/config.Width {
+		return nil, fmt.Errorf("the image is too large: %d x %d pixels exceed the limit of %d pixels", config.Width, config.Height, maxPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if row < 0 {
+		row = bounds.Dy() / 2
+	}
+	if row >= bounds.Dy() {
+		return nil, fmt.Errorf("image row %d out of range", row)
+	}
+	if nrOfColors <= 1 || nrOfColors > bounds.Dx() {
+		nrOfColors = min(32, bounds.Dx())
+	}
+
+	var preset ColorPreset
+	for i := 0; i < nrOfColors; i++ {
+		x := bounds.Min.X + i*(bounds.Dx()-1)/max(1, nrOfColors-1)
+		// palette colors are not premultiplied:
+		c := color.NRGBAModel.Convert(img.At(x, bounds.Min.Y+row)).(color.NRGBA)
+		preset.Palette = append(preset.Palette, PaletteEntry{color.RGBA(c), 0})
+	}
+	return ColorPresets{preset}, nil
+}
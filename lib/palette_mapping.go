@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"slices"
+	"sort"
+
+	"github.com/bylexus/go-stdlib/ethreads"
+)
+
+type PaletteMapping string
+
+const (
+	PALETTE_MAPPING_LINEAR    = "linear"
+	PALETTE_MAPPING_LOG       = "log"
+	PALETTE_MAPPING_SQRT      = "sqrt"
+	PALETTE_MAPPING_POWER     = "power"
+	PALETTE_MAPPING_HISTOGRAM = "histogram"
+	PALETTE_MAPPING_RANK      = "rank"
+)
+
+// returns true if the palette mapping needs all pixel values of the image before
+// the pixels can be colored.
+func (f CommonFractParams) needsGlobalPaletteMapping() bool {
+	return f.ColorPaletteMapping == PALETTE_MAPPING_HISTOGRAM || f.ColorPaletteMapping == PALETTE_MAPPING_RANK
+}
+
+/*
+Maps the linear palette ratio (iteration value / max iterations, 0.0 - 1.0) non-linearly
+onto the palette, and rotates the palette by the palette offset.
+
+Deep zooms need a high max. iteration count, while most pixels only use a small range of iterations:
+with a linear mapping, only a tiny slice of the palette is used. The non-linear mappings
+stretch the lower iteration values:
+
+  - log: log(1 + iter) / log(1 + maxIter)
+  - sqrt: ratio^0.5
+  - power: ratio^exponent
+
+The global mappings (histogram, rank) are calculated before, in applyGlobalPaletteMapping,
+so they are not handled here.
+*/
+func mapPaletteRatio(ratio float64, maxIterations float64, repeat int, fractParams CommonFractParams) float64 {
+	switch fractParams.ColorPaletteMapping {
+	case PALETTE_MAPPING_LOG:
+		ratio = math.Log1p(ratio*maxIterations) / math.Log1p(maxIterations)
+	case PALETTE_MAPPING_SQRT:
+		ratio = math.Sqrt(math.Max(ratio, 0))
+	case PALETTE_MAPPING_POWER:
+		ratio = math.Pow(math.Max(ratio, 0), fractParams.ColorPaletteMappingExponent)
+	}
+	if fractParams.ColorPaletteOffset != 0 {
+		// the offset is given in units of a single (non-repeated) palette:
+		ratio = math.Mod(ratio+fractParams.ColorPaletteOffset/float64(repeat), 1.0)
+		if ratio < 0 {
+			ratio += 1.0
+		}
+	}
+	return ratio
+}
+
+// Prepares the image to defer the coloring: the pixel values are stored instead of colored,
+// until all pixels are calculated. NaN marks pixels that are already colored (e.g. interior pixels).
+func (img *FractImage) deferColoring() {
+	size := img.Rect.Dx() * img.Rect.Dy()
+	img.deferredValues = make([]float32, size)
+	img.deferredShades = make([]float32, size)
+	for i := range img.deferredValues {
+		img.deferredValues[i] = float32(math.NaN())
+		img.deferredShades[i] = 1
+	}
+}
+
+func (img *FractImage) deferredIndex(x, y int) (int, bool) {
+	if !image.Pt(x, y).In(img.Rect) {
+		return 0, false
+	}
+	return (y-img.Rect.Min.Y)*img.Rect.Dx() + (x - img.Rect.Min.X), true
+}
+
+/*
+Colors the deferred pixels of the image with a global palette mapping:
+
+  - histogram: histogram equalization. The values are counted in bins of one iteration each.
+    The cumulative distribution of the bins is used as palette ratio, so each color of the
+    palette covers roughly the same number of pixels. Within a bin, the ratio is interpolated
+    by the fractional part of the value, which keeps the smooth coloring.
+  - rank: the rank of a pixel value within all sorted values, divided by the number of pixels.
+*/
+func applyGlobalPaletteMapping(img *FractImage, fractParams CommonFractParams) {
+	values := make([]float64, 0, len(img.deferredValues))
+	for _, v := range img.deferredValues {
+		if !math.IsNaN(float64(v)) {
+			values = append(values, float64(v))
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	total := float64(len(values))
+
+	var mapValue func(v float64) float64
+	switch fractParams.ColorPaletteMapping {
+	case PALETTE_MAPPING_RANK:
+		slices.Sort(values)
+		mapValue = func(v float64) float64 {
+			return float64(sort.SearchFloat64s(values, v)) / total
+		}
+	default:
+		nrOfBins := fractParams.MaxIterations + 1
+		histogram := make([]float64, nrOfBins)
+		for _, v := range values {
+			histogram[histogramBin(v, nrOfBins)]++
+		}
+		cumulative := make([]float64, nrOfBins+1)
+		for i, count := range histogram {
+			cumulative[i+1] = cumulative[i] + count
+		}
+		mapValue = func(v float64) float64 {
+			bin := histogramBin(v, nrOfBins)
+			frac := math.Max(0, math.Min(1, v-float64(bin)))
+			return (cumulative[bin] + histogram[bin]*frac) / total
+		}
+	}
+
+	// the palette mapping itself is done, so use linear mapping for the coloring:
+	colorParams := fractParams
+	colorParams.ColorPaletteMapping = PALETTE_MAPPING_LINEAR
+	// the palette spans the max iterations, or the fixed palette length:
+	scale := float64(fractParams.MaxIterations)
+	if fractParams.ColorPaletteLength > 0 {
+		scale = float64(fractParams.ColorPaletteLength)
+	}
+	width := img.Rect.Dx()
+
+	tp := ethreads.NewThreadPool(runtime.NumCPU()*2, nil)
+	tp.Start()
+	var rowsPerJob = 64
+	for startY := 0; startY < img.Rect.Dy(); startY += rowsPerJob {
+		tp.AddJobFn(func(id ethreads.ThreadId) {
+			for y := startY; y < min(startY+rowsPerJob, img.Rect.Dy()); y++ {
+				for x := 0; x < width; x++ {
+					idx := y*width + x
+					v := float64(img.deferredValues[idx])
+					if math.IsNaN(v) {
+						continue
+					}
+					px, py := x+img.Rect.Min.X, y+img.Rect.Min.Y
+					SetPaletteColor(img, px, py, mapValue(v)*scale, colorParams, FractFunctionResult{})
+					img.shadePixel(px, py, float64(img.deferredShades[idx]))
+				}
+			}
+		})
+	}
+	tp.Shutdown()
+
+	img.deferredValues = nil
+	img.deferredShades = nil
+}
+
+func histogramBin(v float64, nrOfBins int) int {
+	return max(0, min(nrOfBins-1, int(math.Floor(v))))
+}
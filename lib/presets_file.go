@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/*
+Updates the presets file: the file is locked, read (an empty presets file if it does not exist yet), changed by the
+update function and written back atomically. The previous version of the file is kept as backup (<file>.bak).
+If the update function returns an error, the file is left unchanged.
+
+The lock (on <file>.lock) is held for the whole update, so concurrent updates - from several requests, servers or
+the palette command - do not overwrite each other's changes.
+*/
+func UpdatePresetJson(filePath string, update func(presets *Presets) error) (Presets, error) {
+	var presets Presets
+	unlock, err := lockFile(filePath + ".lock")
+	if err != nil {
+		return presets, err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(filePath); err == nil {
+		presets, err = ReadPresetJson(filePath, nil)
+		if err != nil {
+			return presets, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return presets, err
+	}
+	if err := update(&presets); err != nil {
+		return presets, err
+	}
+	if err := backupFile(filePath, filePath+".bak"); err != nil {
+		return presets, err
+	}
+	return presets, WritePresetJson(filePath, presets)
+}
+
+/*
+Writes the presets in the format of the file extension (see PresetsFormatOfFile): YAML and TOML files with compact
+palette colors. The presets are written to a temporary file, which then replaces the file: readers see either the old
+or the new file.
+*/
+func WritePresetJson(filePath string, presets Presets) error {
+	format := PresetsFormatOfFile(filePath)
+	data, err := EncodePresets(presets, format, format != PRESETS_FORMAT_JSON)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(tmpFile.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filePath)
+}
+
+// copies the file to the backup file, if it exists
+func backupFile(filePath, backupPath string) error {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(backupPath, data, 0644)
+}
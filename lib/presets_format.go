@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// the formats of the presets files
+const (
+	PRESETS_FORMAT_JSON = "json"
+	PRESETS_FORMAT_YAML = "yaml"
+	PRESETS_FORMAT_TOML = "toml"
+)
+
+// The format of a presets file by its file extension: .yaml / .yml, .toml, or json for all other files.
+func PresetsFormatOfFile(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return PRESETS_FORMAT_YAML
+	case ".toml":
+		return PRESETS_FORMAT_TOML
+	}
+	return PRESETS_FORMAT_JSON
+}
+
+// whether the file is a presets file in one of the supported formats, by its file extension
+func isPresetFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+/*
+Converts the content of a YAML or TOML presets file to JSON, the presets are then decoded and validated like a
+JSON presets file. JSON is returned unchanged.
+*/
+func presetsToJson(data []byte, format string) ([]byte, error) {
+	var value any
+	switch format {
+	case PRESETS_FORMAT_YAML:
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	case PRESETS_FORMAT_TOML:
+		if err := toml.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+	default:
+		return data, nil
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", strings.ToUpper(format), err)
+	}
+	return jsonData, nil
+}
+
+// Decodes the presets of a presets file in the given format (PRESETS_FORMAT_*), without validating them.
+func DecodePresets(data []byte, format string) (Presets, error) {
+	presets := Presets{
+		ColorPresets:   make([]ColorPreset, 0),
+		FractalPresets: make([]FractalPreset, 0),
+	}
+	jsonData, err := presetsToJson(data, format)
+	if err != nil {
+		return presets, err
+	}
+	err = json.Unmarshal(jsonData, &presets)
+	return presets, err
+}
+
+/*
+Encodes the presets in the given format (PRESETS_FORMAT_*). With compactColors, the palette colors are written as
+CSS hex colors ("#ff8800", or {"color": "#ff8800", "steps": 128} with steps) instead of R/G/B/A objects.
+The keys of TOML tables are sorted.
+*/
+func EncodePresets(presets Presets, format string, compactColors bool) ([]byte, error) {
+	var value any = presets
+	if compactColors {
+		value = newCompactPresets(presets)
+	}
+	jsonData, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case PRESETS_FORMAT_YAML:
+		// JSON is YAML: the node keeps the order of the keys
+		var node yaml.Node
+		if err := yaml.Unmarshal(jsonData, &node); err != nil {
+			return nil, err
+		}
+		clearYamlStyle(&node)
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), encoder.Close()
+	case PRESETS_FORMAT_TOML:
+		decoder := json.NewDecoder(bytes.NewReader(jsonData))
+		decoder.UseNumber()
+		var tree any
+		if err := decoder.Decode(&tree); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		encoder := toml.NewEncoder(&buf)
+		encoder.Indent = ""
+		if err := encoder.Encode(tomlNumbers(tree)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return append(jsonData, '\n'), nil
+}
+
+// writes the nodes in the block style, instead of the JSON style (flow style, quoted strings) they are decoded from
+func clearYamlStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYamlStyle(child)
+	}
+}
+
+// replaces the json.Numbers of the decoded JSON by integers or floats, which are TOML integers / floats
+func tomlNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = tomlNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = tomlNumbers(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// the presets of a presets file with compact palette colors, see EncodePresets
+type compactPresets struct {
+	Include            []string             `json:"include,omitempty"`
+	ColorPresets       []compactColorPreset `json:"colorPresets"`
+	FractalPresets     FractalPresets       `json:"fractalPresets"`
+	CompositionPresets CompositionPresets   `json:"compositionPresets,omitempty"`
+}
+
+type compactColorPreset struct {
+	ColorPreset
+	// replaces the palette of the color preset: hex colors, or objects with the color and steps
+	Palette []any `json:"colors"`
+}
+
+func newCompactPresets(presets Presets) compactPresets {
+	compact := compactPresets{
+		Include:            presets.Include,
+		ColorPresets:       make([]compactColorPreset, len(presets.ColorPresets)),
+		FractalPresets:     presets.FractalPresets,
+		CompositionPresets: presets.CompositionPresets,
+	}
+	for i, colorPreset := range presets.ColorPresets {
+		palette := make([]any, len(colorPreset.Palette))
+		for j, entry := range colorPreset.Palette {
+			if entry.Steps == 0 {
+				palette[j] = paletteHexColor(entry.RGBA)
+			} else {
+				palette[j] = map[string]any{"color": paletteHexColor(entry.RGBA), "steps": entry.Steps}
+			}
+		}
+		compact.ColorPresets[i] = compactColorPreset{ColorPreset: colorPreset, Palette: palette}
+	}
+	return compact
+}
+
+// formats the palette color as CSS hex color, with alpha if not opaque. Unlike formatHexColor, the color is written as
+// it is: ParseCssColor reads it back unchanged.
+func paletteHexColor(c color.RGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
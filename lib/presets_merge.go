@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// the origin of the embedded presets
+const PRESETS_ORIGIN_EMBEDDED = "embedded"
+
+// Where a preset was read from: the presets file (PRESETS_ORIGIN_EMBEDDED for the embedded presets), and its index in the file.
+type PresetOrigin struct {
+	File  string
+	Index int
+}
+
+// The origins of the presets, by the lower-case ident / name of the presets.
+type PresetOrigins struct {
+	ColorPresets       map[string]PresetOrigin
+	FractalPresets     map[string]PresetOrigin
+	CompositionPresets map[string]PresetOrigin
+}
+
+// the origin of the fractal preset, or its index in the presets if the origin is unknown
+func (o PresetOrigins) fractalPreset(name string, index int) PresetOrigin {
+	return presetOrigin(o.FractalPresets, name, index)
+}
+
+func (o PresetOrigins) compositionPreset(name string, index int) PresetOrigin {
+	return presetOrigin(o.CompositionPresets, name, index)
+}
+
+func presetOrigin(origins map[string]PresetOrigin, key string, index int) PresetOrigin {
+	if origin, ok := origins[strings.ToLower(key)]; ok {
+		return origin
+	}
+	return PresetOrigin{Index: index}
+}
+
+/*
+Reads the presets: the embedded presets, overridden by the presets files in the given order. A directory adds its
+presets files (*.json, *.yaml, *.yml, *.toml), sorted by name. A preset replaces an earlier preset with the same name (fractal and
+composition presets) or ident (color presets). The files listed in the "include" of a presets file are read before
+the file itself, relative to it.
+
+Each file is validated (see ValidatePresetJson), then the references between the merged presets are checked. If
+there are errors, the problems of all files are returned as PresetProblems; warnings alone do not fail, they are
+returned in Presets.Warnings.
+*/
+func ReadPresetFiles(paths []string, embeddedPresets []byte) (Presets, error) {
+	r, err := readPresetFiles(paths, embeddedPresets)
+	if err != nil {
+		return Presets{}, err
+	}
+	return r.result()
+}
+
+/*
+Reads the presets like ReadPresetFiles, but with the given presets instead of the content of the last presets file:
+they are validated as if they were read from the file (e.g. changed presets before they are saved to the file),
+with their includes.
+*/
+func ReadPresetFilesReplacingLast(paths []string, embeddedPresets []byte, presets Presets) (Presets, error) {
+	filePath := paths[len(paths)-1]
+	r, err := readPresetFiles(paths[:len(paths)-1], embeddedPresets)
+	if err != nil {
+		return Presets{}, err
+	}
+	jsonData, err := json.Marshal(presets)
+	if err != nil {
+		return Presets{}, err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return Presets{}, err
+	}
+	r.addSource(filePath)
+	r.reading = append(r.reading, absPath)
+	r.readJson(filePath, jsonData)
+	return r.result()
+}
+
+// reads the embedded presets and the presets files, without checking the references between the presets
+func readPresetFiles(paths []string, embeddedPresets []byte) (*presetReader, error) {
+	r := &presetReader{presets: Presets{
+		ColorPresets:   make(ColorPresets, 0),
+		FractalPresets: make(FractalPresets, 0),
+		Origins: PresetOrigins{
+			ColorPresets:       make(map[string]PresetOrigin),
+			FractalPresets:     make(map[string]PresetOrigin),
+			CompositionPresets: make(map[string]PresetOrigin),
+		},
+	}}
+	r.readData(PRESETS_ORIGIN_EMBEDDED, embeddedPresets)
+	for _, path := range paths {
+		if err := r.readPath(path); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// reads and merges presets files, see ReadPresetFiles
+type presetReader struct {
+	presets  Presets
+	problems PresetProblems
+	// the files being read, to detect include cycles
+	reading []string
+}
+
+// the merged presets with the warnings of all files, or all problems if there are errors
+func (r *presetReader) result() (Presets, error) {
+	validatePresetReferences(r.presets, &r.problems)
+	if len(r.problems.Errors()) > 0 {
+		return Presets{}, r.problems
+	}
+	r.presets.Warnings = r.problems
+	return r.presets, nil
+}
+
+// reads a presets file, or the presets files of a directory
+func (r *presetReader) readPath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return r.readFile(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	r.addSource(path)
+	// os.ReadDir sorts the entries by name:
+	for _, entry := range entries {
+		if entry.IsDir() || !isPresetFileName(entry.Name()) {
+			continue
+		}
+		if err := r.readFile(filepath.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *presetReader) readFile(filePath string) error {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(r.reading, absPath) {
+		return errors.New("include cycle")
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	r.addSource(filePath)
+	r.reading = append(r.reading, absPath)
+	defer func() { r.reading = r.reading[:len(r.reading)-1] }()
+	r.readData(filePath, data)
+	return nil
+}
+
+func (r *presetReader) addSource(path string) {
+	if !slices.Contains(r.presets.Sources, path) {
+		r.presets.Sources = append(r.presets.Sources, path)
+	}
+}
+
+// validates the content of the presets file (in the format of its file extension), reads its includes and merges its presets
+func (r *presetReader) readData(filePath string, data []byte) {
+	jsonData, err := presetsToJson(data, PresetsFormatOfFile(filePath))
+	if err != nil {
+		r.problems = append(r.problems, PresetProblem{File: filePath, Path: "$", Message: err.Error()})
+		return
+	}
+	r.readJson(filePath, jsonData)
+}
+
+func (r *presetReader) readJson(filePath string, jsonData []byte) {
+	problems := ValidatePresetJson(jsonData)
+	for i := range problems {
+		problems[i].File = filePath
+	}
+	r.problems = append(r.problems, problems...)
+
+	var presets Presets
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(jsonData, &presets); err != nil && !errors.As(err, &typeErr) {
+		// the syntax error is reported already
+		return
+	}
+	for i, include := range presets.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filePath), include)
+		}
+		if err := r.readPath(include); err != nil {
+			r.problems = append(r.problems, PresetProblem{File: filePath, Path: fmt.Sprintf("$.include[%d]", i), Message: err.Error()})
+		}
+	}
+	r.merge(filePath, presets)
+}
+
+// adds the presets of the file, replacing the existing presets with the same name / ident
+func (r *presetReader) merge(filePath string, presets Presets) {
+	// duplicate names / idents within a file are kept (a warning): the first one is found by name / ident
+	nrOfColorPresets := len(r.presets.ColorPresets)
+	for i, preset := range presets.ColorPresets {
+		r.presets.ColorPresets = replaceOrAppendPreset(r.presets.ColorPresets, nrOfColorPresets, preset, func(p ColorPreset) string { return p.Ident })
+		setPresetOrigin(r.presets.Origins.ColorPresets, strings.ToLower(preset.Ident), PresetOrigin{File: filePath, Index: i})
+	}
+	nrOfFractalPresets := len(r.presets.FractalPresets)
+	for i, preset := range presets.FractalPresets {
+		r.presets.FractalPresets = replaceOrAppendPreset(r.presets.FractalPresets, nrOfFractalPresets, preset, func(p FractalPreset) string { return p.Name })
+		setPresetOrigin(r.presets.Origins.FractalPresets, strings.ToLower(preset.Name), PresetOrigin{File: filePath, Index: i})
+	}
+	nrOfCompositionPresets := len(r.presets.CompositionPresets)
+	for i, preset := range presets.CompositionPresets {
+		r.presets.CompositionPresets = replaceOrAppendPreset(r.presets.CompositionPresets, nrOfCompositionPresets, preset, func(p CompositionPreset) string { return p.Name })
+		setPresetOrigin(r.presets.Origins.CompositionPresets, strings.ToLower(preset.Name), PresetOrigin{File: filePath, Index: i})
+	}
+}
+
+// replaces the preset with the same key (compared case-insensitively) among the first n presets (the presets of
+// the previous files), or appends the preset
+func replaceOrAppendPreset[T any](presets []T, n int, preset T, key func(T) string) []T {
+	i := slices.IndexFunc(presets[:n], func(p T) bool { return strings.EqualFold(key(p), key(preset)) })
+	if i >= 0 {
+		presets[i] = preset
+		return presets
+	}
+	return append(presets, preset)
+}
+
+// sets the origin of a preset, unless it is a duplicate of a preset of the same file
+func setPresetOrigin(origins map[string]PresetOrigin, key string, origin PresetOrigin) {
+	if existing, ok := origins[key]; ok && existing.File == origin.File {
+		return
+	}
+	origins[key] = origin
+}
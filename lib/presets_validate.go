@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The JSON Schema of the presets files
+//
+//go:embed presets.schema.json
+var PresetsSchema []byte
+
+// A problem of a presets file: an invalid value, or a reference to an unknown preset
+type PresetProblem struct {
+	// the presets file, see PresetOrigin.File
+	File string `json:"file,omitempty"`
+	// JSON path of the invalid value, e.g. $.fractalPresets[3].maxIterations
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	// an unknown property or a duplicate name, which does not prevent using the presets: the presets are loaded
+	// anyway, only `presets validate --strict` fails
+	Warning bool `json:"warning,omitempty"`
+}
+
+func (p PresetProblem) String() string {
+	message := p.Message
+	if p.Warning {
+		message = "warning: " + message
+	}
+	if p.File != "" {
+		return p.File + ": " + p.Path + ": " + message
+	}
+	return p.Path + ": " + message
+}
+
+// All problems of the presets files
+type PresetProblems []PresetProblem
+
+// the errors of the problems, without the warnings
+func (p PresetProblems) Error() string {
+	var messages []string
+	for _, problem := range p.Errors() {
+		messages = append(messages, problem.String())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (p *PresetProblems) add(path, format string, args ...any) {
+	*p = append(*p, PresetProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *PresetProblems) warn(path, format string, args ...any) {
+	*p = append(*p, PresetProblem{Path: path, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Returns the problems which are no warnings.
+func (p PresetProblems) Errors() PresetProblems {
+	var errs PresetProblems
+	for _, problem := range p {
+		if !problem.Warning {
+			errs = append(errs, problem)
+		}
+	}
+	return errs
+}
+
+// adds a problem of the preset with the given origin, the path is relative to the preset
+func (p *PresetProblems) addAt(origin PresetOrigin, list, path, format string, args ...any) {
+	*p = append(*p, PresetProblem{
+		File:    origin.File,
+		Path:    fmt.Sprintf("$.%s[%d]%s", list, origin.Index, path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+/*
+Validates the content of a presets file: the JSON is checked against the presets schema (PresetsSchema), then the
+presets are checked for duplicate names / idents and invalid colors. The references to other presets are checked
+after merging the presets files, by ReadPresetFiles. Returns all problems, nil if the file is valid.
+
+Unknown properties and duplicate names / idents are warnings, as the presets can be used anyway: the other problems
+(invalid JSON, values of the wrong type, out of range or not allowed, ...) are errors.
+*/
+func ValidatePresetJson(jsonData []byte) PresetProblems {
+	var problems PresetProblems
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := textPosition(jsonData, syntaxErr.Offset)
+			problems.add("$", "invalid JSON at line %d, column %d: %s", line, column, err)
+		} else {
+			problems.add("$", "invalid JSON: %s", err)
+		}
+		return problems
+	}
+
+	var schema jsonSchema
+	if err := json.Unmarshal(PresetsSchema, &schema); err != nil {
+		problems.add("$", "invalid presets schema: %s", err)
+		return problems
+	}
+	schema.validate(&schema, value, "$", &problems)
+
+	// the decoding continues after values of the wrong type, which are reported by the schema already:
+	var presets Presets
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(jsonData, &presets); err != nil && !errors.As(err, &typeErr) {
+		return problems
+	}
+	validatePresetValues(presets, &problems)
+	return problems
+}
+
+// checks duplicate names / idents and the colors of the presets of a presets file
+func validatePresetValues(presets Presets, problems *PresetProblems) {
+	colorIdents := make(map[string]int)
+	for i, colorPreset := range presets.ColorPresets {
+		ident := strings.ToLower(colorPreset.Ident)
+		if first, ok := colorIdents[ident]; ok {
+			problems.warn(fmt.Sprintf("$.colorPresets[%d].ident", i), "duplicate ident '%s', also used by $.colorPresets[%d]", colorPreset.Ident, first)
+		} else {
+			colorIdents[ident] = i
+		}
+	}
+
+	fractalNames := make(map[string]int)
+	for i, fractalPreset := range presets.FractalPresets {
+		path := fmt.Sprintf("$.fractalPresets[%d]", i)
+		name := strings.ToLower(fractalPreset.Name)
+		if first, ok := fractalNames[name]; ok {
+			problems.warn(path+".name", "duplicate name '%s', also used by $.fractalPresets[%d]", fractalPreset.Name, first)
+		} else {
+			fractalNames[name] = i
+		}
+		if fractalPreset.InteriorColor != "" {
+			if _, err := ParseCssColor(fractalPreset.InteriorColor); err != nil {
+				problems.add(path+".interiorColor", "%s", err)
+			}
+		}
+	}
+
+	compositionNames := make(map[string]int)
+	for i, composition := range presets.CompositionPresets {
+		path := fmt.Sprintf("$.compositionPresets[%d]", i)
+		name := strings.ToLower(composition.Name)
+		if first, ok := compositionNames[name]; ok {
+			problems.warn(path+".name", "duplicate name '%s', also used by $.compositionPresets[%d]", composition.Name, first)
+		} else {
+			compositionNames[name] = i
+		}
+		if composition.Background != "" {
+			if _, err := ParseCssColor(composition.Background); err != nil {
+				problems.add(path+".background", "%s", err)
+			}
+		}
+	}
+}
+
+// checks the references between the (merged) presets, the problems are reported in the presets files of the presets
+func validatePresetReferences(presets Presets, problems *PresetProblems) {
+	for i, fractalPreset := range presets.FractalPresets {
+		origin := presets.Origins.fractalPreset(fractalPreset.Name, i)
+		// the idents are looked up as written, see ColorPresets.GetByIdent:
+		if _, err := presets.ColorPresets.GetByIdent(fractalPreset.ColorPreset); err != nil && fractalPreset.ColorPreset != "" {
+			problems.addAt(origin, "fractalPresets", ".colorPreset", "unknown color preset '%s'", fractalPreset.ColorPreset)
+		}
+		if _, err := presets.ColorPresets.GetByIdent(fractalPreset.InteriorColorPreset); err != nil && fractalPreset.InteriorColorPreset != "" {
+			problems.addAt(origin, "fractalPresets", ".interiorColorPreset", "unknown color preset '%s'", fractalPreset.InteriorColorPreset)
+		}
+	}
+
+	for i, composition := range presets.CompositionPresets {
+		origin := presets.Origins.compositionPreset(composition.Name, i)
+		for j, layer := range composition.Layers {
+			if _, err := presets.FractalPresets.GetByName(layer.FractalPreset); err != nil && layer.FractalPreset != "" {
+				problems.addAt(origin, "compositionPresets", fmt.Sprintf(".layers[%d].fractalPreset", j), "unknown fractal preset '%s'", layer.FractalPreset)
+			}
+			if layer.Mask != nil && layer.Mask.FractalPreset != "" {
+				if _, err := presets.FractalPresets.GetByName(layer.Mask.FractalPreset); err != nil {
+					problems.addAt(origin, "compositionPresets", fmt.Sprintf(".layers[%d].mask.fractalPreset", j), "unknown fractal preset '%s'", layer.Mask.FractalPreset)
+				}
+			}
+		}
+	}
+}
+
+// the line and column (1-based) of the byte offset in the text
+func textPosition(text []byte, offset int64) (int, int) {
+	offset = min(offset, int64(len(text)))
+	before := text[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
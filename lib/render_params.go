@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"slices"
+)
+
+// the idents of the palettes in the render params of fractals not rendered from presets
+const (
+	renderParamsPaletteIdent         = "palette"
+	renderParamsInteriorPaletteIdent = "interior-palette"
+)
+
+/*
+All parameters needed to render an image again: either a fractal or a composition, with the presets they refer to.
+The render params are embedded as metadata into png and jpeg images (see metadata.go), so an image can be
+reproduced without the presets file it was made with.
+*/
+type RenderParams struct {
+	// the tool (and version) the image was rendered with
+	Generator string `json:"generator"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+
+	Fractal     *FractalPreset     `json:"fractal,omitempty"`
+	Composition *CompositionPreset `json:"composition,omitempty"`
+	// the color presets used by the fractal, and the fractal and color presets of the composition's layers
+	Presets Presets `json:"presets"`
+}
+
+// Returns the render params of the fractal: the palettes are included as color presets.
+func NewFractalRenderParams(fractal Fractal) (RenderParams, error) {
+	params := fractal.FractParams()
+	preset := FractalPreset{
+		DiameterCX:            params.DiameterCX,
+		CenterCX:              params.CenterCX,
+		CenterCY:              params.CenterCY,
+		ColorPreset:           renderParamsPaletteIdent,
+		MaxIterations:         params.MaxIterations,
+		ColorPaletteLength:    params.ColorPaletteLength,
+		ColorPaletteRepeat:    params.ColorPaletteRepeat,
+		ColorPaletteReverse:   params.ColorPaletteReverse,
+		ColorPaletteHardStops: params.ColorPaletteHardStops,
+
+		ColorPaletteMapping:         string(params.ColorPaletteMapping),
+		ColorPaletteMappingExponent: params.ColorPaletteMappingExponent,
+		ColorPaletteOffset:          params.ColorPaletteOffset,
+
+		InteriorMode:         string(params.InteriorMode),
+		DisablePeriodCheck:   !params.PeriodCheck,
+		DistanceMode:         string(params.DistanceMode),
+		DistanceOutlineWidth: params.DistanceOutlineWidth,
+		LightAngle:           params.LightAngle,
+		LightHeight:          params.LightHeight,
+		ColoringAlgorithm:    string(params.ColoringAlgorithm),
+		ColoringAlgorithm2:   string(params.ColoringAlgorithm2),
+		ColoringBlendMode:    string(params.ColoringBlendMode),
+		ColoringBlendFactor:  params.ColoringBlendFactor,
+		ColoringDensity:      params.ColoringDensity,
+	}
+	switch f := fractal.(type) {
+	case MandelbrotFractal:
+		preset.IterFunc = FRACTAL_TYPE_MANDELBROT
+	case Mandelbrot3Fractal:
+		preset.IterFunc = FRACTAL_TYPE_MANDELBROT3
+	case Mandelbrot4Fractal:
+		preset.IterFunc = FRACTAL_TYPE_MANDELBROT4
+	case JuliaFractal:
+		preset.IterFunc = FRACTAL_TYPE_JULIA
+		preset.JuliaKr, preset.JuliaKi = f.JuliaKr, f.JuliaKi
+	default:
+		return RenderParams{}, errors.New("unknown fractal function")
+	}
+	if params.InteriorColor != nil {
+		preset.InteriorColor = formatHexColor(params.InteriorColor)
+	}
+
+	colorPresets := ColorPresets{{
+		Name:          "Palette",
+		Ident:         renderParamsPaletteIdent,
+		Palette:       params.ColorPalette,
+		Interpolation: params.ColorPaletteSpace,
+		Curve:         params.ColorPaletteCurve,
+	}}
+	// the interior palette defaults to the color palette:
+	if len(params.InteriorColorPalette) > 0 && !slices.Equal(params.InteriorColorPalette, params.ColorPalette) {
+		preset.InteriorColorPreset = renderParamsInteriorPaletteIdent
+		colorPresets = append(colorPresets, ColorPreset{
+			Name:    "Interior palette",
+			Ident:   renderParamsInteriorPaletteIdent,
+			Palette: params.InteriorColorPalette,
+		})
+	}
+
+	return RenderParams{
+		Generator: Generator(),
+		Width:     params.ImageWidth,
+		Height:    params.ImageHeight,
+		Fractal:   &preset,
+		Presets:   Presets{ColorPresets: colorPresets, FractalPresets: FractalPresets{}},
+	}, nil
+}
+
+// Returns the render params of the composition, with the fractal and color presets used by its layers.
+func NewCompositionRenderParams(width, height int, presets Presets, composition CompositionPreset) (RenderParams, error) {
+	used := Presets{ColorPresets: ColorPresets{}, FractalPresets: FractalPresets{}}
+	addFractalPreset := func(name string) error {
+		fractalPreset, err := presets.FractalPresets.GetByName(name)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, name)
+		}
+		used.FractalPresets = append(used.FractalPresets, fractalPreset)
+		for _, ident := range []string{fractalPreset.ColorPreset, fractalPreset.InteriorColorPreset} {
+			if ident == "" {
+				continue
+			}
+			colorPreset, err := presets.ColorPresets.GetByIdent(ident)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, ident)
+			}
+			used.AddColorPresets(colorPreset)
+		}
+		return nil
+	}
+	for _, layer := range composition.Layers {
+		if err := addFractalPreset(layer.FractalPreset); err != nil {
+			return RenderParams{}, err
+		}
+		if layer.Mask != nil {
+			if err := addFractalPreset(layer.Mask.FractalPreset); err != nil {
+				return RenderParams{}, err
+			}
+		}
+	}
+
+	return RenderParams{
+		Generator:   Generator(),
+		Width:       width,
+		Height:      height,
+		Composition: &composition,
+		Presets:     used,
+	}, nil
+}
+
+// adds the color presets referenced by the fractal which are not in the render params' presets
+func (p *RenderParams) addReferencedColorPresets(colorPresets ColorPresets) error {
+	for _, ident := range []string{p.Fractal.ColorPreset, p.Fractal.InteriorColorPreset} {
+		if ident == "" {
+			continue
+		}
+		if _, err := p.Presets.ColorPresets.GetByIdent(ident); err == nil {
+			continue
+		}
+		colorPreset, err := colorPresets.GetByIdent(ident)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, ident)
+		}
+		p.Presets.ColorPresets = append(p.Presets.ColorPresets, colorPreset)
+	}
+	return nil
+}
+
+/*
+Renders the image again, with the given size. A width or height of 0 keeps the original size; if only one of them
+is given, the other one is calculated from the original aspect ratio, so the image shows exactly the same view.
+*/
+func (p RenderParams) Render(width, height int) (*FractImage, error) {
+	width, height = p.Size(width, height)
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+
+	if p.Composition != nil {
+		return CalcCompositionImage(width, height, p.Presets, *p.Composition)
+	}
+	if p.Fractal == nil {
+		return nil, errors.New("the render params contain no fractal")
+	}
+	fractal, err := NewFractalFromPresets(width, height, p.Presets.ColorPresets, *p.Fractal)
+	if err != nil {
+		return nil, err
+	}
+	return CalcFractalImage(fractal), nil
+}
+
+// The size of an image rendered with the given width and height, see Render.
+func (p RenderParams) Size(width, height int) (int, int) {
+	switch {
+	case width <= 0 && height <= 0:
+		width, height = p.Width, p.Height
+	case height <= 0 && p.Width > 0:
+		height = max(1, width*p.Height/p.Width)
+	case width <= 0 && p.Height > 0:
+		width = max(1, height*p.Width/p.Height)
+	}
+	return width, height
+}
+
+// formats a color as CSS hex color, e.g. #ff8800, with alpha if not opaque
+func formatHexColor(c color.Color) string {
+	nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if nc.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", nc.R, nc.G, nc.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", nc.R, nc.G, nc.B, nc.A)
+}
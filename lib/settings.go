@@ -1,9 +1,7 @@
 package lib
 
 import (
-	"encoding/json"
 	"errors"
-	"io"
 	"log"
 	"os"
 	"strings"
@@ -13,13 +11,23 @@ type ColorPresets []ColorPreset
 type FractalPresets []FractalPreset
 
 type Presets struct {
-	ColorPresets   ColorPresets   `json:"colorPresets"`
-	FractalPresets FractalPresets `json:"fractalPresets"`
+	ColorPresets       ColorPresets       `json:"colorPresets"`
+	FractalPresets     FractalPresets     `json:"fractalPresets"`
+	CompositionPresets CompositionPresets `json:"compositionPresets,omitempty"`
+	// presets files (or directories) to read before this file, relative to it, see ReadPresetFiles
+	Include []string `json:"include,omitempty"`
+
+	// the files and directories the presets were read from, see ReadPresetFiles. Not part of the presets file.
+	Sources []string `json:"-"`
+	// where each preset was read from. Not part of the presets file.
+	Origins PresetOrigins `json:"-"`
+	// the warnings of the presets files, see PresetProblem.Warning. Not part of the presets file.
+	Warnings PresetProblems `json:"-"`
 }
 
 func (p ColorPresets) GetByIdent(ident string) (ColorPreset, error) {
 	for _, preset := range p {
-		if strings.ToLower(preset.Ident) == ident {
+		if strings.EqualFold(preset.Ident, ident) {
 			return preset, nil
 		}
 	}
@@ -40,6 +48,9 @@ type ColorPreset struct {
 	Name    string       `json:"name"`
 	Ident   string       `json:"ident"`
 	Palette ColorPalette `json:"colors"`
+	// color space and curve for the interpolation between the colors, see interpolateColor:
+	Interpolation ColorSpace         `json:"interpolation,omitempty"`
+	Curve         InterpolationCurve `json:"curve,omitempty"`
 }
 
 type FractalPreset struct {
@@ -56,6 +67,26 @@ type FractalPreset struct {
 	ColorPaletteRepeat    int     `json:"colorPaletteRepeat"`
 	ColorPaletteReverse   bool    `json:"colorPaletteReverse"`
 	ColorPaletteHardStops bool    `json:"colorPaletteHardStops"`
+
+	ColorPaletteMapping         string  `json:"colorPaletteMapping,omitempty"`
+	ColorPaletteMappingExponent float64 `json:"colorPaletteMappingExponent,omitempty"`
+	ColorPaletteOffset          float64 `json:"colorPaletteOffset,omitempty"`
+
+	InteriorMode         string  `json:"interiorMode,omitempty"`
+	InteriorColorPreset  string  `json:"interiorColorPreset,omitempty"`
+	InteriorColor        string  `json:"interiorColor,omitempty"`
+	DisablePeriodCheck   bool    `json:"disablePeriodCheck,omitempty"`
+	DistanceMode         string  `json:"distanceMode,omitempty"`
+	DistanceOutlineWidth float64 `json:"distanceOutlineWidth,omitempty"`
+	// nil = 45, to tell a light angle of 0 from a missing one
+	LightAngle         *float64 `json:"lightAngle,omitempty"`
+	LightHeight        float64  `json:"lightHeight,omitempty"`
+	ColoringAlgorithm  string   `json:"coloringAlgorithm,omitempty"`
+	ColoringAlgorithm2 string   `json:"coloringAlgorithm2,omitempty"`
+	ColoringBlendMode  string   `json:"coloringBlendMode,omitempty"`
+	// nil = 0.5, to tell a blend factor of 0 from a missing one
+	ColoringBlendFactor *float64 `json:"coloringBlendFactor,omitempty"`
+	ColoringDensity     float64  `json:"coloringDensity,omitempty"`
 }
 
 func (f FractalPreset) FractalFunction() (FractalType, error) {
@@ -75,32 +106,39 @@ func (f FractalPreset) FractalFunction() (FractalType, error) {
 	}
 }
 
-func ReadPresetJson(filePath string, embeddedPresets []byte) (Presets, error) {
-	var presets Presets = Presets{
-		ColorPresets:   make([]ColorPreset, 0),
-		FractalPresets: make([]FractalPreset, 0),
+// Adds the color presets to the presets, replacing existing presets with the same ident.
+func (p *Presets) AddColorPresets(colorPresets ...ColorPreset) {
+	for _, colorPreset := range colorPresets {
+		replaced := false
+		for i, existing := range p.ColorPresets {
+			if strings.EqualFold(existing.Ident, colorPreset.Ident) {
+				p.ColorPresets[i] = colorPreset
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			p.ColorPresets = append(p.ColorPresets, colorPreset)
+		}
 	}
+}
 
-	var jsonData []byte
-
+/*
+Reads the presets of a presets file, in the format of its file extension (see PresetsFormatOfFile), or the embedded
+presets if the file path is empty. The presets are not validated, see ReadPresetFiles.
+*/
+func ReadPresetJson(filePath string, embeddedPresets []byte) (Presets, error) {
+	data := embeddedPresets
 	if filePath != "" {
-		jsonFile, err := os.Open(filePath)
-		if err != nil {
-			log.Println(err)
-			return presets, err
-		}
-		defer jsonFile.Close()
-
-		jsonData, err = io.ReadAll(jsonFile)
+		var err error
+		data, err = os.ReadFile(filePath)
 		if err != nil {
 			log.Println(err)
-			return presets, err
+			return Presets{ColorPresets: make([]ColorPreset, 0), FractalPresets: make([]FractalPreset, 0)}, err
 		}
-	} else {
-		jsonData = embeddedPresets
 	}
 
-	err := json.Unmarshal(jsonData, &presets)
+	presets, err := DecodePresets(data, PresetsFormatOfFile(filePath))
 	if err != nil {
 		log.Println(err)
 		return presets, err
@@ -0,0 +1,363 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+A render spec is a compact, URL-safe string of the render params of a fractal, e.g. for permalinks:
+
+	1AAGABQKQAwQBBc0LBQaGAgUHAgMI6AcLEnBhdGNod29yawwBDQIQPxEKARMrFV0XNxgCABoeARsZHSEeCgEfCgA
+
+The first character is the format version, followed by the base64 (URL alphabet, no padding) encoded data:
+a flag byte (1 = the data is deflate compressed), then the non-zero fields of the fractal, each as a
+tag byte followed by the value:
+
+  - ints: zigzag varint
+  - floats: the shortest decimal representation as zigzag varint mantissa and exponent, which is lossless
+  - strings: a varint: the length * 2 for a string literal, followed by the bytes, or index * 2 + 1 for a word of specWords
+  - bools: only the tag, for true
+  - color presets: ident, name, interpolation and curve as strings, the number of colors, and per color
+    the r, g, b, a bytes and the steps
+
+Color presets are referenced by their ident, or contained in the spec (inline) if they are not part of the presets.
+The tags and specWords of a version must never change: new fields get new tags, new words are appended.
+*/
+
+const specVersion = '1'
+
+const (
+	specFlagDeflate = 1
+
+	specTagColorPreset = 32
+)
+
+type specKind int
+
+const (
+	specInt specKind = iota
+	specFloat
+	specString
+	specBool
+)
+
+type specField struct {
+	tag  byte
+	kind specKind
+	// returns a pointer to the field's value: *int, *float64, **float64 (optional), *string or *bool
+	value func(p *RenderParams) any
+}
+
+var specFields = []specField{
+	{1, specInt, func(p *RenderParams) any { return &p.Width }},
+	{2, specInt, func(p *RenderParams) any { return &p.Height }},
+	{3, specString, func(p *RenderParams) any { return &p.Fractal.Name }},
+	{4, specString, func(p *RenderParams) any { return &p.Fractal.IterFunc }},
+	{5, specFloat, func(p *RenderParams) any { return &p.Fractal.CenterCX }},
+	{6, specFloat, func(p *RenderParams) any { return &p.Fractal.CenterCY }},
+	{7, specFloat, func(p *RenderParams) any { return &p.Fractal.DiameterCX }},
+	{8, specInt, func(p *RenderParams) any { return &p.Fractal.MaxIterations }},
+	{9, specFloat, func(p *RenderParams) any { return &p.Fractal.JuliaKr }},
+	{10, specFloat, func(p *RenderParams) any { return &p.Fractal.JuliaKi }},
+	{11, specString, func(p *RenderParams) any { return &p.Fractal.ColorPreset }},
+	{12, specInt, func(p *RenderParams) any { return &p.Fractal.ColorPaletteLength }},
+	{13, specInt, func(p *RenderParams) any { return &p.Fractal.ColorPaletteRepeat }},
+	{14, specBool, func(p *RenderParams) any { return &p.Fractal.ColorPaletteReverse }},
+	{15, specBool, func(p *RenderParams) any { return &p.Fractal.ColorPaletteHardStops }},
+	{16, specString, func(p *RenderParams) any { return &p.Fractal.ColorPaletteMapping }},
+	{17, specFloat, func(p *RenderParams) any { return &p.Fractal.ColorPaletteMappingExponent }},
+	{18, specFloat, func(p *RenderParams) any { return &p.Fractal.ColorPaletteOffset }},
+	{19, specString, func(p *RenderParams) any { return &p.Fractal.InteriorMode }},
+	{20, specString, func(p *RenderParams) any { return &p.Fractal.InteriorColorPreset }},
+	{21, specString, func(p *RenderParams) any { return &p.Fractal.InteriorColor }},
+	{22, specBool, func(p *RenderParams) any { return &p.Fractal.DisablePeriodCheck }},
+	{23, specString, func(p *RenderParams) any { return &p.Fractal.DistanceMode }},
+	{24, specFloat, func(p *RenderParams) any { return &p.Fractal.DistanceOutlineWidth }},
+	{25, specFloat, func(p *RenderParams) any { return &p.Fractal.LightAngle }},
+	{26, specFloat, func(p *RenderParams) any { return &p.Fractal.LightHeight }},
+	{27, specString, func(p *RenderParams) any { return &p.Fractal.ColoringAlgorithm }},
+	{28, specString, func(p *RenderParams) any { return &p.Fractal.ColoringAlgorithm2 }},
+	{29, specString, func(p *RenderParams) any { return &p.Fractal.ColoringBlendMode }},
+	{30, specFloat, func(p *RenderParams) any { return &p.Fractal.ColoringBlendFactor }},
+	{31, specFloat, func(p *RenderParams) any { return &p.Fractal.ColoringDensity }},
+}
+
+// frequent string values, encoded as index
+var specWords = []string{
+	FRACTAL_TYPE_MANDELBROT, FRACTAL_TYPE_MANDELBROT3, FRACTAL_TYPE_MANDELBROT4, FRACTAL_TYPE_JULIA,
+	renderParamsPaletteIdent, renderParamsInteriorPaletteIdent, "Palette", "Interior palette",
+	"escape-time", "smooth", "triangle-inequality", "curvature", "stripe", "binary-decomposition", "field-lines", "final-angle",
+	"mix", "add", "multiply", "screen", "difference",
+	"black", "period", "final-abs", "angle", "multiplier", "atom-domain",
+	"off", "outline", "color", "shading",
+	"linear", "log", "sqrt", "power", "histogram", "rank",
+	"rgb", "linear-rgb", "hsl", "hsv", "lab", "oklab", "oklch", "smoothstep", "spline",
+	"#000000", "#00000000", "transparent",
+}
+
+/*
+Encodes the render params of a fractal as render spec. Palettes that are equal to one of the given color presets
+are referenced by the preset's ident, all others are contained in the spec.
+*/
+func EncodeRenderSpec(params RenderParams, colorPresets ColorPresets) (string, error) {
+	if params.Fractal == nil {
+		return "", errors.New("only fractals can be encoded as render spec, no compositions")
+	}
+	fractal := *params.Fractal
+	params.Fractal = &fractal
+
+	var inline ColorPresets
+	for _, ident := range []*string{&params.Fractal.ColorPreset, &params.Fractal.InteriorColorPreset} {
+		if *ident == "" {
+			continue
+		}
+		colorPreset, err := params.Presets.ColorPresets.GetByIdent(*ident)
+		if err != nil {
+			// not contained in the params: a reference to the color presets
+			if _, err := colorPresets.GetByIdent(*ident); err != nil {
+				return "", fmt.Errorf("%w: %s", err, *ident)
+			}
+			continue
+		}
+		if known, ok := findColorPreset(colorPresets, colorPreset); ok {
+			*ident = known.Ident
+		} else if !slices.ContainsFunc(inline, func(p ColorPreset) bool { return p.Ident == colorPreset.Ident }) {
+			inline = append(inline, colorPreset)
+		}
+	}
+
+	var data bytes.Buffer
+	for _, field := range specFields {
+		switch v := field.value(&params).(type) {
+		case *int:
+			if *v != 0 {
+				data.WriteByte(field.tag)
+				data.Write(binary.AppendVarint(nil, int64(*v)))
+			}
+		case *float64:
+			if *v != 0 {
+				data.WriteByte(field.tag)
+				writeSpecFloat(&data, *v)
+			}
+		case **float64:
+			if *v != nil {
+				data.WriteByte(field.tag)
+				writeSpecFloat(&data, **v)
+			}
+		case *string:
+			if *v != "" {
+				data.WriteByte(field.tag)
+				writeSpecString(&data, *v)
+			}
+		case *bool:
+			if *v {
+				data.WriteByte(field.tag)
+			}
+		}
+	}
+	for _, colorPreset := range inline {
+		data.WriteByte(specTagColorPreset)
+		writeSpecColorPreset(&data, colorPreset)
+	}
+
+	payload := append([]byte{0}, data.Bytes()...)
+	var compressed bytes.Buffer
+	zw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	zw.Write(data.Bytes())
+	zw.Close()
+	if compressed.Len()+1 < len(payload) {
+		payload = append([]byte{specFlagDeflate}, compressed.Bytes()...)
+	}
+	return string(specVersion) + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// returns the color preset with the same palette, interpolation and curve
+func findColorPreset(colorPresets ColorPresets, colorPreset ColorPreset) (ColorPreset, bool) {
+	for _, p := range colorPresets {
+		if slices.Equal(p.Palette, colorPreset.Palette) && p.Interpolation == colorPreset.Interpolation && p.Curve == colorPreset.Curve {
+			return p, true
+		}
+	}
+	return ColorPreset{}, false
+}
+
+/*
+Decodes a render spec. Color presets referenced by the spec are taken from the given color presets, so the
+returned render params contain all presets needed for rendering.
+*/
+func DecodeRenderSpec(spec string, colorPresets ColorPresets) (RenderParams, error) {
+	params := RenderParams{Fractal: &FractalPreset{}, Presets: Presets{ColorPresets: ColorPresets{}, FractalPresets: FractalPresets{}}}
+	if len(spec) < 2 || spec[0] != specVersion {
+		return params, errors.New("invalid render spec: unknown version")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(spec[1:])
+	if err != nil || len(payload) == 0 {
+		return params, errors.New("invalid render spec: bad encoding")
+	}
+	data := payload[1:]
+	if payload[0]&specFlagDeflate != 0 {
+		data, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), 1<<20))
+		if err != nil {
+			return params, errors.New("invalid render spec: bad compression")
+		}
+	}
+
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		tag, _ := r.ReadByte()
+		if tag == specTagColorPreset {
+			colorPreset, err := readSpecColorPreset(r)
+			if err != nil {
+				return params, err
+			}
+			params.Presets.AddColorPresets(colorPreset)
+			continue
+		}
+		i := slices.IndexFunc(specFields, func(f specField) bool { return f.tag == tag })
+		if i < 0 {
+			return params, fmt.Errorf("invalid render spec: unknown field %d", tag)
+		}
+		switch v := specFields[i].value(&params).(type) {
+		case *int:
+			n, err := binary.ReadVarint(r)
+			if err != nil {
+				return params, errors.New("invalid render spec: bad number")
+			}
+			*v = int(n)
+		case *float64:
+			*v, err = readSpecFloat(r)
+		case **float64:
+			var f float64
+			f, err = readSpecFloat(r)
+			*v = &f
+		case *string:
+			*v, err = readSpecString(r)
+		case *bool:
+			*v = true
+		}
+		if err != nil {
+			return params, err
+		}
+	}
+
+	err = params.addReferencedColorPresets(colorPresets)
+	return params, err
+}
+
+// writes the float as decimal mantissa and exponent, e.g. -0.25 as -25, -2
+func writeSpecFloat(w *bytes.Buffer, v float64) {
+	mantissa, exponent := decimalParts(v)
+	w.Write(binary.AppendVarint(nil, mantissa))
+	w.Write(binary.AppendVarint(nil, exponent))
+}
+
+func decimalParts(v float64) (int64, int64) {
+	// the shortest representation that parses back to the same float, e.g. "-2.5e-01":
+	s := strconv.FormatFloat(v, 'e', -1, 64)
+	digits, exp, _ := strings.Cut(s, "e")
+	exponent, _ := strconv.ParseInt(exp, 10, 64)
+	intPart, fraction, _ := strings.Cut(digits, ".")
+	mantissa, _ := strconv.ParseInt(intPart+fraction, 10, 64)
+	exponent -= int64(len(fraction))
+	// remove trailing zeros of the mantissa:
+	for mantissa != 0 && mantissa%10 == 0 {
+		mantissa /= 10
+		exponent++
+	}
+	return mantissa, exponent
+}
+
+func readSpecFloat(r *bytes.Reader) (float64, error) {
+	mantissa, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, errors.New("invalid render spec: bad number")
+	}
+	exponent, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, errors.New("invalid render spec: bad number")
+	}
+	v, err := strconv.ParseFloat(fmt.Sprintf("%de%d", mantissa, exponent), 64)
+	if err != nil && !math.IsInf(v, 0) {
+		return 0, errors.New("invalid render spec: bad number")
+	}
+	return v, nil
+}
+
+func writeSpecString(w *bytes.Buffer, s string) {
+	if i := slices.Index(specWords, s); i >= 0 {
+		w.Write(binary.AppendUvarint(nil, uint64(i*2+1)))
+		return
+	}
+	w.Write(binary.AppendUvarint(nil, uint64(len(s)*2)))
+	w.WriteString(s)
+}
+
+func readSpecString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", errors.New("invalid render spec: bad string")
+	}
+	if n%2 == 1 {
+		if n/2 >= uint64(len(specWords)) {
+			return "", errors.New("invalid render spec: unknown word")
+		}
+		return specWords[n/2], nil
+	}
+	if n/2 > uint64(r.Len()) {
+		return "", errors.New("invalid render spec: bad string")
+	}
+	s := make([]byte, n/2)
+	r.Read(s)
+	return string(s), nil
+}
+
+func writeSpecColorPreset(w *bytes.Buffer, colorPreset ColorPreset) {
+	for _, s := range []string{colorPreset.Ident, colorPreset.Name, string(colorPreset.Interpolation), string(colorPreset.Curve)} {
+		writeSpecString(w, s)
+	}
+	w.Write(binary.AppendUvarint(nil, uint64(len(colorPreset.Palette))))
+	for _, entry := range colorPreset.Palette {
+		w.Write([]byte{entry.R, entry.G, entry.B, entry.A})
+		w.Write(binary.AppendVarint(nil, int64(entry.Steps)))
+	}
+}
+
+func readSpecColorPreset(r *bytes.Reader) (ColorPreset, error) {
+	var fields [4]string
+	for i := range fields {
+		s, err := readSpecString(r)
+		if err != nil {
+			return ColorPreset{}, err
+		}
+		fields[i] = s
+	}
+	colorPreset := ColorPreset{Ident: fields[0], Name: fields[1], Interpolation: ColorSpace(fields[2]), Curve: InterpolationCurve(fields[3])}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return ColorPreset{}, errors.New("invalid render spec: bad palette")
+	}
+	colorPreset.Palette = make(ColorPalette, n)
+	for i := range colorPreset.Palette {
+		var rgba [4]byte
+		if _, err := io.ReadFull(r, rgba[:]); err != nil {
+			return ColorPreset{}, errors.New("invalid render spec: bad palette")
+		}
+		steps, err := binary.ReadVarint(r)
+		if err != nil {
+			return ColorPreset{}, errors.New("invalid render spec: bad palette")
+		}
+		colorPreset.Palette[i] = PaletteEntry{RGBA: color.RGBA{R: rgba[0], G: rgba[1], B: rgba[2], A: rgba[3]}, Steps: int(steps)}
+	}
+	return colorPreset, nil
+}
@@ -0,0 +1,267 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+A fractal preset field varied by a parameter sweep, from a start to an end value in a number of steps:
+parsed from "name=from:to:steps", e.g. "juliaKr=-1:1:9", with the JSON name of the field. With the suffix ":log",
+the values are spaced logarithmically (e.g. for maxIterations or diameterCX).
+*/
+type SweepParam struct {
+	Name  string
+	From  float64
+	To    float64
+	Steps int
+	Log   bool
+}
+
+// Parses a sweep param, see SweepParam.
+func ParseSweepParam(s string) (SweepParam, error) {
+	name, valueRange, ok := strings.Cut(s, "=")
+	if !ok {
+		return SweepParam{}, fmt.Errorf("invalid sweep param '%s': expected name=from:to:steps", s)
+	}
+	param := SweepParam{Name: strings.TrimSpace(name)}
+	if _, err := fractalPresetField(&FractalPreset{}, param.Name); err != nil {
+		return param, err
+	}
+	parts := strings.Split(valueRange, ":")
+	if len(parts) == 4 && parts[3] == "log" {
+		param.Log = true
+		parts = parts[:3]
+	}
+	if len(parts) != 3 {
+		return param, fmt.Errorf("invalid sweep param '%s': expected name=from:to:steps", s)
+	}
+	var err error
+	if param.From, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return param, fmt.Errorf("invalid sweep param '%s': bad start value", s)
+	}
+	if param.To, err = strconv.ParseFloat(parts[1], 64); err != nil {
+		return param, fmt.Errorf("invalid sweep param '%s': bad end value", s)
+	}
+	if param.Steps, err = strconv.Atoi(parts[2]); err != nil || param.Steps < 1 {
+		return param, fmt.Errorf("invalid sweep param '%s': the steps must be at least 1", s)
+	}
+	if param.Log && (param.From <= 0 || param.To <= 0) {
+		return param, fmt.Errorf("invalid sweep param '%s': a logarithmic range must be positive", s)
+	}
+	return param, nil
+}
+
+// Returns the values of the steps, from the start to the end value.
+func (p SweepParam) Values() []float64 {
+	values := make([]float64, p.Steps)
+	for i := range values {
+		t := 0.0
+		if p.Steps > 1 {
+			t = float64(i) / float64(p.Steps-1)
+		}
+		if p.Log {
+			values[i] = math.Exp(math.Log(p.From) + t*(math.Log(p.To)-math.Log(p.From)))
+		} else {
+			values[i] = p.From + t*(p.To-p.From)
+		}
+	}
+	return values
+}
+
+// the numeric field of the fractal preset with the given JSON name (case-insensitive): an int, float64 or *float64
+func fractalPresetField(preset *FractalPreset, name string) (reflect.Value, error) {
+	v := reflect.ValueOf(preset).Elem()
+	for i := range v.NumField() {
+		jsonName, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if !strings.EqualFold(jsonName, name) {
+			continue
+		}
+		switch v.Field(i).Kind() {
+		case reflect.Float64, reflect.Int:
+			return v.Field(i), nil
+		case reflect.Pointer:
+			if v.Field(i).Type().Elem().Kind() == reflect.Float64 {
+				return v.Field(i), nil
+			}
+		}
+		return reflect.Value{}, fmt.Errorf("the fractal preset field '%s' is not a number", name)
+	}
+	return reflect.Value{}, fmt.Errorf("unknown fractal preset field '%s'", name)
+}
+
+// Returns the fractal preset with the field of the param set to the value (rounded for integer fields).
+func (p SweepParam) Apply(preset FractalPreset, value float64) (FractalPreset, error) {
+	field, err := fractalPresetField(&preset, p.Name)
+	if err != nil {
+		return preset, err
+	}
+	switch field.Kind() {
+	case reflect.Int:
+		field.SetInt(int64(math.Round(value)))
+	case reflect.Pointer:
+		// a new value: the preset's copies share the pointer
+		field.Set(reflect.ValueOf(&value))
+	default:
+		field.SetFloat(value)
+	}
+	return preset, nil
+}
+
+// formats the value of the param as caption, e.g. "juliaKr=-0.25"
+func (p SweepParam) caption(preset FractalPreset) string {
+	field, _ := fractalPresetField(&preset, p.Name)
+	if field.Kind() == reflect.Int {
+		return fmt.Sprintf("%s=%d", p.Name, field.Int())
+	}
+	if field.Kind() == reflect.Pointer {
+		field = field.Elem()
+	}
+	return fmt.Sprintf("%s=%s", p.Name, strconv.FormatFloat(field.Float(), 'g', 5, 64))
+}
+
+/*
+Returns the cells of a sweep grid: the values of the first param vary along the columns, the values of the optional
+second param along the rows. The cells are captioned with the values. Returns the number of columns.
+*/
+func SweepGrid(preset FractalPreset, params []SweepParam) ([]ContactSheetCell, int, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, 0, errors.New("a sweep varies one or two params")
+	}
+	rowParam := SweepParam{Steps: 1}
+	if len(params) == 2 {
+		rowParam = params[1]
+	}
+	var cells []ContactSheetCell
+	for j := range rowParam.Steps {
+		for _, x := range params[0].Values() {
+			cellPreset, err := params[0].Apply(preset, x)
+			if err != nil {
+				return nil, 0, err
+			}
+			caption := params[0].caption(cellPreset)
+			if len(params) == 2 {
+				if cellPreset, err = rowParam.Apply(cellPreset, rowParam.Values()[j]); err != nil {
+					return nil, 0, err
+				}
+				caption += ", " + rowParam.caption(cellPreset)
+			}
+			cells = append(cells, ContactSheetCell{Caption: caption, Fractal: cellPreset})
+		}
+	}
+	return cells, params[0].Steps, nil
+}
+
+/*
+Returns the fractal presets of the frames of a sweep animation: all params change together from their start to
+their end value, so they must have the same number of steps (the number of frames).
+*/
+func SweepFrames(preset FractalPreset, params []SweepParam) ([]FractalPreset, error) {
+	if len(params) == 0 {
+		return nil, errors.New("a sweep varies at least one param")
+	}
+	frames := make([]FractalPreset, params[0].Steps)
+	for i := range frames {
+		frames[i] = preset
+	}
+	for _, param := range params {
+		if param.Steps != len(frames) {
+			return nil, errors.New("the params of an animation must have the same number of steps")
+		}
+		for i, value := range param.Values() {
+			var err error
+			if frames[i], err = param.Apply(frames[i], value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return frames, nil
+}
+
+/*
+Renders the Julia sets of a grid of constants over the Mandelbrot set: each Julia set thumbnail is placed at the point
+of its constant in the Mandelbrot plane, showing how the Julia sets change with the constant. The Julia constants
+are the values of the juliaKr (columns) and juliaKi (rows) params, with at least 2 steps each; the Mandelbrot view
+covers their range. Each grid cell is cellWidth pixels wide (the height follows from the spacing of the values), the
+thumbnails are scaled by thumbScale (0.0 - 1.0) in their cells.
+
+The Julia sets are rendered with the fractal preset (its view is the view of each thumbnail), the Mandelbrot set with
+the coloring of the fractal preset. The progress is reported in rendered thumbnails (may be nil).
+*/
+func CalcJuliaMapImage(ctx context.Context, colorPresets ColorPresets, preset FractalPreset, kr, ki SweepParam, cellWidth int, thumbScale float64, progress ProgressFunc) (*FractImage, error) {
+	if !strings.EqualFold(kr.Name, "juliaKr") || !strings.EqualFold(ki.Name, "juliaKi") {
+		return nil, errors.New("a Julia map needs the juliaKr and juliaKi params")
+	}
+	if kr.Steps < 2 || ki.Steps < 2 || kr.Log || ki.Log || kr.From == kr.To || ki.From == ki.To {
+		return nil, errors.New("the juliaKr and juliaKi params of a Julia map need at least 2 steps, on a linear scale")
+	}
+	// the spacing of the grid in the plane, and the scale of the image:
+	dx, dy := math.Abs(kr.To-kr.From)/float64(kr.Steps-1), math.Abs(ki.To-ki.From)/float64(ki.Steps-1)
+	cellHeight := max(1, int(math.Round(float64(cellWidth)*dy/dx)))
+	width, height := kr.Steps*cellWidth, ki.Steps*cellHeight
+
+	mandelbrotPreset := preset
+	mandelbrotPreset.IterFunc = FRACTAL_TYPE_MANDELBROT
+	mandelbrotPreset.CenterCX = (kr.From + kr.To) / 2
+	mandelbrotPreset.CenterCY = (ki.From + ki.To) / 2
+	mandelbrotPreset.DiameterCX = float64(kr.Steps) * dx
+	mandelbrot, err := NewFractalFromPresets(width, height, colorPresets, mandelbrotPreset)
+	if err != nil {
+		return nil, err
+	}
+	img, err := CalcFractalImageContext(ctx, mandelbrot, nil)
+	if err != nil {
+		return nil, err
+	}
+	img.RenderParams = nil
+
+	thumbWidth := max(1, int(float64(cellWidth)*min(max(thumbScale, 0.05), 1)))
+	thumbHeight := max(1, thumbWidth*cellHeight/cellWidth)
+	juliaPreset := preset
+	juliaPreset.IterFunc = FRACTAL_TYPE_JULIA
+	var julias []Fractal
+	var origins []image.Point
+	for j, juliaKi := range ki.Values() {
+		// the rows from the top, where the imaginary part is the largest:
+		row := j
+		if ki.From < ki.To {
+			row = ki.Steps - 1 - j
+		}
+		for i, juliaKr := range kr.Values() {
+			column := i
+			if kr.From > kr.To {
+				column = kr.Steps - 1 - i
+			}
+			juliaPreset.JuliaKr, juliaPreset.JuliaKi = juliaKr, juliaKi
+			julia, err := NewFractalFromPresets(thumbWidth, thumbHeight, colorPresets, juliaPreset)
+			if err != nil {
+				return nil, err
+			}
+			julias = append(julias, julia)
+			origins = append(origins, image.Point{
+				X: column*cellWidth + (cellWidth-thumbWidth)/2,
+				Y: row*cellHeight + (cellHeight-thumbHeight)/2,
+			})
+		}
+	}
+
+	done := 0
+	err = renderFractals(ctx, julias, func(i int, thumb *FractImage) {
+		draw.Draw(img.RGBA64, image.Rectangle{Min: origins[i], Max: origins[i].Add(thumb.Rect.Size())}, thumb.RGBA64, image.Point{}, draw.Over)
+		done++
+		if progress != nil {
+			progress(done, len(julias))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
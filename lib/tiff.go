@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+/*
+A baseline TIFF encoder for RGBA images with 8 or 16 bit per channel: *image.RGBA64 and *image.NRGBA64 images are
+written with 16 bit, all others with 8 bit. The colors are stored non-premultiplied (unassociated alpha),
+deflate-compressed with the horizontal differencing predictor.
+*/
+
+const (
+	tiffTagImageWidth      = 256
+	tiffTagImageLength     = 257
+	tiffTagBitsPerSample   = 258
+	tiffTagCompression     = 259
+	tiffTagPhotometric     = 262
+	tiffTagStripOffsets    = 273
+	tiffTagSamplesPerPixel = 277
+	tiffTagRowsPerStrip    = 278
+	tiffTagStripByteCounts = 279
+	tiffTagPlanarConfig    = 284
+	tiffTagPredictor       = 317
+	tiffTagExtraSamples    = 338
+
+	tiffTypeShort = 3
+	tiffTypeLong  = 4
+
+	tiffCompressionDeflate  = 8
+	tiffPhotometricRGB      = 2
+	tiffPredictorHorizontal = 2
+	tiffUnassociatedAlpha   = 2
+
+	// approximate uncompressed size of a strip
+	tiffStripSize = 64 * 1024
+)
+
+type tiffEntry struct {
+	tag, fieldType uint16
+	values         []uint32
+}
+
+func EncodeTiff(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	bytesPerSample := 1
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64:
+		bytesPerSample = 2
+	}
+	rowSize := width * 4 * bytesPerSample
+	rowsPerStrip := max(1, min(height, tiffStripSize/max(1, rowSize)))
+
+	// the header is followed by the strips, then by the image file directory (IFD):
+	var data bytes.Buffer
+	data.Write([]byte{'I', 'I', 42, 0, 0, 0, 0, 0})
+	var stripOffsets, stripByteCounts []uint32
+
+	row := make([]byte, rowSize)
+	for stripY := 0; stripY < height; stripY += rowsPerStrip {
+		stripOffsets = append(stripOffsets, uint32(data.Len()))
+		start := data.Len()
+		zw := zlib.NewWriter(&data)
+		for y := stripY; y < min(stripY+rowsPerStrip, height); y++ {
+			tiffRow(img, bounds.Min.Y+y, bytesPerSample, row)
+			if _, err := zw.Write(row); err != nil {
+				return err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		stripByteCounts = append(stripByteCounts, uint32(data.Len()-start))
+	}
+
+	bitsPerSample := uint32(bytesPerSample * 8)
+	entries := []tiffEntry{
+		{tiffTagImageWidth, tiffTypeLong, []uint32{uint32(width)}},
+		{tiffTagImageLength, tiffTypeLong, []uint32{uint32(height)}},
+		{tiffTagBitsPerSample, tiffTypeShort, []uint32{bitsPerSample, bitsPerSample, bitsPerSample, bitsPerSample}},
+		{tiffTagCompression, tiffTypeShort, []uint32{tiffCompressionDeflate}},
+		{tiffTagPhotometric, tiffTypeShort, []uint32{tiffPhotometricRGB}},
+		{tiffTagStripOffsets, tiffTypeLong, stripOffsets},
+		{tiffTagSamplesPerPixel, tiffTypeShort, []uint32{4}},
+		{tiffTagRowsPerStrip, tiffTypeLong, []uint32{uint32(rowsPerStrip)}},
+		{tiffTagStripByteCounts, tiffTypeLong, stripByteCounts},
+		{tiffTagPlanarConfig, tiffTypeShort, []uint32{1}},
+		{tiffTagPredictor, tiffTypeShort, []uint32{tiffPredictorHorizontal}},
+		{tiffTagExtraSamples, tiffTypeShort, []uint32{tiffUnassociatedAlpha}},
+	}
+	if data.Len()%2 == 1 {
+		// the IFD must start on a word boundary
+		data.WriteByte(0)
+	}
+	writeTiffIFD(&data, entries)
+
+	_, err := w.Write(data.Bytes())
+	return err
+}
+
+// writes a row of non-premultiplied RGBA samples, as differences to the previous pixel (horizontal predictor)
+func tiffRow(img image.Image, y, bytesPerSample int, row []byte) {
+	bounds := img.Bounds()
+	var prev [4]uint16
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+		samples := [4]uint16{c.R, c.G, c.B, c.A}
+		offset := (x - bounds.Min.X) * 4 * bytesPerSample
+		for i, s := range samples {
+			if bytesPerSample == 2 {
+				binary.LittleEndian.PutUint16(row[offset+2*i:], s-prev[i])
+			} else {
+				s >>= 8
+				row[offset+i] = uint8(s - prev[i])
+			}
+			prev[i] = s
+		}
+	}
+}
+
+// writes the image file directory at the end of the data, and sets its offset in the header.
+// Values that do not fit into the 4 bytes of an entry are written after the IFD.
+func writeTiffIFD(data *bytes.Buffer, entries []tiffEntry) {
+	ifdOffset := data.Len()
+	ifdSize := 2 + len(entries)*12 + 4
+	binary.LittleEndian.PutUint32(data.Bytes()[4:], uint32(ifdOffset))
+
+	var ifd, external bytes.Buffer
+	binary.Write(&ifd, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		var values bytes.Buffer
+		for _, v := range e.values {
+			if e.fieldType == tiffTypeShort {
+				binary.Write(&values, binary.LittleEndian, uint16(v))
+			} else {
+				binary.Write(&values, binary.LittleEndian, v)
+			}
+		}
+		binary.Write(&ifd, binary.LittleEndian, e.tag)
+		binary.Write(&ifd, binary.LittleEndian, e.fieldType)
+		binary.Write(&ifd, binary.LittleEndian, uint32(len(e.values)))
+		if values.Len() <= 4 {
+			ifd.Write(values.Bytes())
+			ifd.Write(make([]byte, 4-values.Len()))
+		} else {
+			binary.Write(&ifd, binary.LittleEndian, uint32(ifdOffset+ifdSize+external.Len()))
+			external.Write(values.Bytes())
+		}
+	}
+	binary.Write(&ifd, binary.LittleEndian, uint32(0)) // no next IFD
+	data.Write(ifd.Bytes())
+	data.Write(external.Bytes())
+}
@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"runtime/debug"
+)
+
+// The version of the tool. Can be set at build time, e.g. with
+// `go build -ldflags "-X github.com/bylexus/go-fract/lib.Version=1.2.3"`. If not set, the module version
+// of the build info (the git tag or a pseudo version of the commit) is used.
+var Version = ""
+
+const generatorName = "go-fractgen"
+
+func AppVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// the name and version of the tool, as written into the image metadata
+func Generator() string {
+	return generatorName + " " + AppVersion()
+}
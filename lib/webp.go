@@ -0,0 +1,335 @@
+package lib
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+/*
+A lossless WebP (VP8L) encoder, as the standard library only comes with a WebP decoder (golang.org/x/image/webp).
+
+The encoder is kept simple: it uses the subtract-green transform and entropy-codes the pixels with
+one set of prefix (Huffman) codes, without backward references or color cache. This is enough for
+images with an alpha channel, at a size between PNG and uncompressed.
+
+See https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+*/
+
+const (
+	vp8lSignature       = 0x2f
+	vp8lMaxDimension    = 1 << 14
+	vp8lMaxCodeLength   = 15
+	vp8lMaxCLCodeLength = 7
+	vp8lGreenAlphabet   = 256 + 24
+	vp8lDistAlphabet    = 40
+)
+
+// the order in which the code length code lengths are written
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func EncodeWebp(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return errors.New("webp: image size must be between 1 and 16384 pixels")
+	}
+
+	// the pixels as non-premultiplied ARGB channels, with green subtracted from red and blue:
+	var alphaUsed bool
+	pixels := make([][4]uint8, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			alphaUsed = alphaUsed || c.A != 255
+			pixels = append(pixels, [4]uint8{c.G, c.R - c.G, c.B - c.G, c.A})
+		}
+	}
+
+	// the prefix codes for green, red, blue and alpha:
+	var codes [4]vp8lPrefixCode
+	for ch := range codes {
+		counts := make([]int, 256)
+		if ch == 0 {
+			counts = make([]int, vp8lGreenAlphabet)
+		}
+		for _, p := range pixels {
+			counts[p[ch]]++
+		}
+		codes[ch] = newVp8lPrefixCode(counts, vp8lMaxCodeLength)
+	}
+
+	bw := &lsbBitWriter{}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	bw.writeBool(alphaUsed)
+	bw.writeBits(0, 3) // version
+
+	bw.writeBool(true) // transform present:
+	bw.writeBits(2, 2) // subtract green
+	bw.writeBool(false)
+
+	bw.writeBool(false) // no color cache
+	bw.writeBool(false) // no meta prefix codes
+	for _, code := range codes {
+		code.writeTo(bw)
+	}
+	// distance code: not used, as there are no backward references
+	newVp8lPrefixCode(make([]int, vp8lDistAlphabet), vp8lMaxCodeLength).writeTo(bw)
+
+	for _, p := range pixels {
+		for ch, code := range codes {
+			code.writeSymbol(bw, int(p[ch]))
+		}
+	}
+	bw.flush()
+
+	data := bw.buf
+	padding := len(data) % 2
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padding > 0 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// writes bits least significant bit first, as used by the VP8L bitstream
+type lsbBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (w *lsbBitWriter) writeBits(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nBits -= 8
+	}
+}
+
+func (w *lsbBitWriter) writeBool(b bool) {
+	if b {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+func (w *lsbBitWriter) flush() {
+	if w.nBits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nBits = 0, 0
+	}
+}
+
+// a canonical prefix code: the code lengths and the (bit-reversed) codes per symbol
+type vp8lPrefixCode struct {
+	lengths []uint8
+	codes   []uint16
+	// symbols in use: a code with a single symbol needs no bits at all
+	symbols []int
+}
+
+func newVp8lPrefixCode(counts []int, maxLength int) vp8lPrefixCode {
+	code := vp8lPrefixCode{lengths: huffmanCodeLengths(counts, maxLength)}
+	for symbol, length := range code.lengths {
+		if length > 0 {
+			code.symbols = append(code.symbols, symbol)
+		}
+	}
+	if len(code.symbols) == 0 {
+		// an unused code still needs a symbol:
+		code.lengths[0] = 1
+		code.symbols = []int{0}
+	}
+	code.codes = canonicalCodes(code.lengths)
+	return code
+}
+
+func (c vp8lPrefixCode) writeSymbol(w *lsbBitWriter, symbol int) {
+	if len(c.symbols) > 1 {
+		w.writeBits(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+	}
+}
+
+func (c vp8lPrefixCode) writeTo(w *lsbBitWriter) {
+	if len(c.symbols) <= 2 && c.symbols[len(c.symbols)-1] < 256 {
+		// simple code: 1 or 2 symbols, each with a code length of 1
+		w.writeBool(true)
+		w.writeBits(uint32(len(c.symbols)-1), 1)
+		if c.symbols[0] < 2 {
+			w.writeBool(false)
+			w.writeBits(uint32(c.symbols[0]), 1)
+		} else {
+			w.writeBool(true)
+			w.writeBits(uint32(c.symbols[0]), 8)
+		}
+		if len(c.symbols) == 2 {
+			w.writeBits(uint32(c.symbols[1]), 8)
+		}
+		return
+	}
+
+	// normal code: the code lengths are themselves prefix coded, with the code length code
+	// (0 - 15: the length, 17 / 18: a run of zeros)
+	type token struct{ symbol, extraBits, extra int }
+	var tokens []token
+	for i := 0; i < len(c.lengths); {
+		if c.lengths[i] != 0 {
+			tokens = append(tokens, token{int(c.lengths[i]), 0, 0})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, token{18, 7, run - 11})
+		case run >= 3:
+			tokens = append(tokens, token{17, 3, run - 3})
+		default:
+			run = 1
+			tokens = append(tokens, token{0, 0, 0})
+		}
+		i += run
+	}
+
+	clCounts := make([]int, len(vp8lCodeLengthOrder))
+	for _, t := range tokens {
+		clCounts[t.symbol]++
+	}
+	clCode := newVp8lPrefixCode(clCounts, vp8lMaxCLCodeLength)
+	nrOfCLCodes := len(vp8lCodeLengthOrder)
+	for nrOfCLCodes > 4 && clCode.lengths[vp8lCodeLengthOrder[nrOfCLCodes-1]] == 0 {
+		nrOfCLCodes--
+	}
+
+	w.writeBool(false)
+	w.writeBits(uint32(nrOfCLCodes-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:nrOfCLCodes] {
+		w.writeBits(uint32(clCode.lengths[symbol]), 3)
+	}
+	w.writeBool(false) // code lengths for all symbols follow
+	for _, t := range tokens {
+		clCode.writeSymbol(w, t.symbol)
+		w.writeBits(uint32(t.extra), uint(t.extraBits))
+	}
+}
+
+/*
+Calculates the Huffman code lengths for the symbol counts, limited to maxLength bits.
+If the tree gets too deep, the small counts are raised (to a doubling minimum) until the code lengths fit.
+*/
+func huffmanCodeLengths(counts []int, maxLength int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	for countMin := 1; ; countMin *= 2 {
+		nodes := huffmanNodeHeap{}
+		for symbol, count := range counts {
+			if count > 0 {
+				nodes.nodes = append(nodes.nodes, &huffmanNode{weight: max(count, countMin), symbol: symbol})
+			}
+		}
+		if len(nodes.nodes) == 0 {
+			return lengths
+		}
+		if len(nodes.nodes) == 1 {
+			lengths[nodes.nodes[0].symbol] = 1
+			return lengths
+		}
+		heap.Init(&nodes)
+		for nodes.Len() > 1 {
+			a := heap.Pop(&nodes).(*huffmanNode)
+			b := heap.Pop(&nodes).(*huffmanNode)
+			heap.Push(&nodes, &huffmanNode{weight: a.weight + b.weight, symbol: -1, left: a, right: b})
+		}
+
+		tooDeep := false
+		var walk func(n *huffmanNode, depth int)
+		walk = func(n *huffmanNode, depth int) {
+			if n.left == nil {
+				lengths[n.symbol] = uint8(min(depth, 255))
+				tooDeep = tooDeep || depth > maxLength
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk(nodes.nodes[0], 0)
+		if !tooDeep {
+			return lengths
+		}
+	}
+}
+
+type huffmanNode struct {
+	weight      int
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanNodeHeap struct {
+	nodes []*huffmanNode
+}
+
+func (h huffmanNodeHeap) Len() int { return len(h.nodes) }
+func (h huffmanNodeHeap) Less(i, j int) bool {
+	return h.nodes[i].weight < h.nodes[j].weight
+}
+func (h huffmanNodeHeap) Swap(i, j int) { h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i] }
+func (h *huffmanNodeHeap) Push(x any)   { h.nodes = append(h.nodes, x.(*huffmanNode)) }
+func (h *huffmanNodeHeap) Pop() any {
+	n := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return n
+}
+
+// assigns the canonical codes to the code lengths (ordered by length, then by symbol), bit-reversed
+// for the LSB-first bit writer
+func canonicalCodes(lengths []uint8) []uint16 {
+	symbols := make([]int, 0, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool { return lengths[symbols[i]] < lengths[symbols[j]] })
+
+	codes := make([]uint16, len(lengths))
+	var code uint32
+	var prevLength uint8
+	for i, symbol := range symbols {
+		length := lengths[symbol]
+		if i > 0 {
+			code = (code + 1) << (length - prevLength)
+		}
+		prevLength = length
+
+		var reversed uint16
+		for b := uint8(0); b < length; b++ {
+			reversed |= uint16((code>>b)&1) << (length - 1 - b)
+		}
+		codes[symbol] = reversed
+	}
+	return codes
+}
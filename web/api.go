@@ -0,0 +1,253 @@
+package web
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+const maxRenderRequestSize = 1 << 20
+
+// the OpenAPI document of all endpoints: keep it in sync with the handlers
+//
+//go:embed openapi.json
+var openApiDocument []byte
+
+/*
+The body of POST /api/v1/render: a fractal, with the same schema as a fractal preset, or a composition.
+The presets in the request are added to the server's presets (replacing presets with the same ident / name),
+so a fractal can use an inline palette:
+
+	{
+	  "width": 1920, "height": 1200,
+	  "iterFunc": "mandelbrot", "centerCX": -0.7, "diameterCX": 4, "maxIterations": 500, "colorPreset": "my-palette",
+	  "colorPresets": [{"ident": "my-palette", "name": "My palette", "colors": [{"R": 0, "G": 0, "B": 0, "A": 255, "steps": 1}]}],
+	  "output": {"format": "png"}
+	}
+*/
+type RenderRequest struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	lib.FractalPreset
+	// renders this composition instead of the fractal
+	Composition *lib.CompositionPreset `json:"composition,omitempty"`
+
+	ColorPresets   lib.ColorPresets   `json:"colorPresets,omitempty"`
+	FractalPresets lib.FractalPresets `json:"fractalPresets,omitempty"`
+
+	Output RenderOutput `json:"output"`
+}
+
+// The image format and encoder options of a render request, see the fractal-image query params
+type RenderOutput struct {
+	// an image format name, or "auto" (default) to select it by the Accept header
+	Format            string `json:"format,omitempty"`
+	Quality           int    `json:"quality,omitempty"`
+	ChromaSubsampling string `json:"chromaSubsampling,omitempty"`
+	PngCompression    string `json:"pngCompression,omitempty"`
+	GifColors         int    `json:"gifColors,omitempty"`
+	Dither            string `json:"dither,omitempty"`
+	// embed the render params into png / jpeg images, default true
+	Metadata *bool `json:"metadata,omitempty"`
+}
+
+/*
+Renders the fractal or composition of the JSON body (RenderRequest), and returns the image. With the header
+"Prefer: respond-async", the render is queued as job instead, like POST /api/v1/jobs/render: the job is returned
+(202 Accepted), its image can be downloaded when it is done.
+*/
+func (s *WebServer) handleApiRender(w http.ResponseWriter, r *http.Request) {
+	var req RenderRequest
+	if err := decodeJsonBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	v := newParamValidator(nil)
+	out, err := s.renderOutput(r.Header.Get("Accept"), v, req.Output)
+	if err != nil {
+		writeImageOutputError(w, err)
+		return
+	}
+	presets := s.prepareRender(v, req)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if prefersAsync(r) {
+		// the format is negotiated now, the job runs without the request:
+		req.Output.Format = out.format.Name
+		w.Header().Set("Preference-Applied", "respond-async")
+		s.submitJob(w, Job{Type: JOB_TYPE_RENDER, Render: &req})
+		return
+	}
+
+	img, err := renderRequestImage(r.Context(), req, presets, nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	encodeImage(img, out, w)
+}
+
+// whether the request has the preference respond-async (RFC 7240), e.g. "Prefer: respond-async, wait=10"
+func prefersAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(preference, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodes the JSON body of the request, unknown fields are an error
+func decodeJsonBody(w http.ResponseWriter, r *http.Request, body any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRenderRequestSize)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(body); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return nil
+}
+
+/*
+Validates the render request, invalid fields are recorded in the validator. Returns the server's presets
+with the request's presets added.
+*/
+func (s *WebServer) prepareRender(v *paramValidator, req RenderRequest) lib.Presets {
+	current := s.currentPresets()
+	presets := lib.Presets{
+		ColorPresets:   slices.Clone(current.ColorPresets),
+		FractalPresets: slices.Clone(current.FractalPresets),
+	}
+	presets.AddColorPresets(req.ColorPresets...)
+	for _, fractalPreset := range req.FractalPresets {
+		i := slices.IndexFunc(presets.FractalPresets, func(p lib.FractalPreset) bool { return strings.EqualFold(p.Name, fractalPreset.Name) })
+		if i >= 0 {
+			presets.FractalPresets[i] = fractalPreset
+		} else {
+			presets.FractalPresets = append(presets.FractalPresets, fractalPreset)
+		}
+	}
+	for i, colorPreset := range req.ColorPresets {
+		validateColorPreset(v, fmt.Sprintf("colorPresets[%d].", i), colorPreset)
+	}
+	for i, fractalPreset := range req.FractalPresets {
+		prefix := fmt.Sprintf("fractalPresets[%d].", i)
+		v.check(strings.TrimSpace(fractalPreset.Name) != "", prefix+"name", "is required")
+		validateFractalPreset(v, prefix, fractalPreset, presets.ColorPresets)
+		v.checkIterations(s.limits, prefix+"maxIterations", fractalPreset.MaxIterations)
+	}
+
+	if req.Composition != nil {
+		maxIterations := 0
+		for i, layer := range req.Composition.Layers {
+			fractalPreset, err := presets.FractalPresets.GetByName(layer.FractalPreset)
+			if err != nil {
+				v.fail(fmt.Sprintf("composition.layers[%d].fractalPreset", i), "unknown fractal preset")
+			}
+			maxIterations = max(maxIterations, fractalPreset.MaxIterations)
+			if layer.Mask != nil {
+				maskPreset, err := presets.FractalPresets.GetByName(layer.Mask.FractalPreset)
+				if err != nil {
+					v.fail(fmt.Sprintf("composition.layers[%d].mask.fractalPreset", i), "unknown fractal preset")
+				}
+				maxIterations = max(maxIterations, maskPreset.MaxIterations)
+			}
+		}
+		v.check(len(req.Composition.Layers) > 0, "composition.layers", "must not be empty")
+		v.checkLimits(s.limits, req.Width, req.Height, maxIterations)
+	} else {
+		validateFractalPreset(v, "", req.FractalPreset, presets.ColorPresets)
+		v.checkLimits(s.limits, req.Width, req.Height, req.MaxIterations)
+	}
+	return presets
+}
+
+// renders the fractal or composition of the (validated) render request, reporting the progress (may be nil)
+func renderRequestImage(ctx context.Context, req RenderRequest, presets lib.Presets, progress lib.ProgressFunc) (*lib.FractImage, error) {
+	if req.Composition != nil {
+		return lib.CalcCompositionImageContext(ctx, req.Width, req.Height, presets, *req.Composition, progress)
+	}
+	fractal, err := lib.NewFractalFromPresets(req.Width, req.Height, presets.ColorPresets, req.FractalPreset)
+	if err != nil {
+		return nil, err
+	}
+	return lib.CalcFractalImageContext(ctx, fractal, progress)
+}
+
+// validates the fields of a fractal preset (in the JSON body, the field names with the prefix), like the fractal-image
+// query params
+func validateFractalPreset(v *paramValidator, prefix string, f lib.FractalPreset, colorPresets lib.ColorPresets) {
+	v.checkEnum(prefix+"iterFunc", f.IterFunc, fractalTypes)
+	v.check(f.IterFunc != "", prefix+"iterFunc", "is required")
+	v.check(f.DiameterCX > 0, prefix+"diameterCX", "must be greater than 0")
+	v.check(f.MaxIterations >= 1, prefix+"maxIterations", "must be at least 1")
+	v.check(f.ColorPaletteRepeat >= 0, prefix+"colorPaletteRepeat", "must be at least 0")
+	v.check(f.ColorPaletteLength >= -1, prefix+"colorPaletteLength", "must be at least -1")
+	if _, err := colorPresets.GetByIdent(f.ColorPreset); err != nil {
+		v.fail(prefix+"colorPreset", "unknown color preset")
+	}
+	if f.InteriorColorPreset != "" {
+		if _, err := colorPresets.GetByIdent(f.InteriorColorPreset); err != nil {
+			v.fail(prefix+"interiorColorPreset", "unknown color preset")
+		}
+	}
+	if f.InteriorColor != "" {
+		if _, err := lib.ParseCssColor(f.InteriorColor); err != nil {
+			v.fail(prefix+"interiorColor", "%s", err)
+		}
+	}
+	v.checkEnum(prefix+"colorPaletteMapping", f.ColorPaletteMapping, paletteMappings)
+	v.checkEnum(prefix+"coloringAlgorithm", f.ColoringAlgorithm, coloringAlgorithms)
+	v.checkEnum(prefix+"coloringAlgorithm2", f.ColoringAlgorithm2, coloringAlgorithms)
+	v.checkEnum(prefix+"coloringBlendMode", f.ColoringBlendMode, blendModes)
+	v.checkEnum(prefix+"interiorMode", f.InteriorMode, interiorModes)
+	v.checkEnum(prefix+"distanceMode", f.DistanceMode, distanceModes)
+}
+
+// the image output of the render request's output options, invalid options are recorded in the validator
+func (s *WebServer) renderOutput(accept string, v *paramValidator, o RenderOutput) (imageOutput, error) {
+	format := o.Format
+	if format == "" {
+		format = "auto"
+	}
+	out, err := selectImageFormat(accept, v, "output.format", format)
+	if err != nil {
+		return out, err
+	}
+	v.check(o.Quality == 0 || (o.Quality >= 1 && o.Quality <= 100), "output.quality", "must be between 1 and 100")
+	v.check(o.GifColors == 0 || (o.GifColors >= 2 && o.GifColors <= 256), "output.gifColors", "must be between 2 and 256")
+	out.opts = lib.EncodeOptions{
+		JpegQuality:       o.Quality,
+		ChromaSubsampling: lib.ChromaSubsampling(v.checkEnum("output.chromaSubsampling", o.ChromaSubsampling, chromaSubsamplings)),
+		PngCompression:    lib.PngCompression(v.checkEnum("output.pngCompression", o.PngCompression, pngCompressions)),
+		GifColors:         o.GifColors,
+		OmitMetadata:      o.Metadata != nil && !*o.Metadata,
+	}
+	out.dither = lib.DitherMode(v.checkEnum("output.dither", o.Dither, ditherModes))
+	return out, nil
+}
+
+// Serves the OpenAPI document of the HTTP API, with the app version.
+func (s *WebServer) handleOpenApi(w http.ResponseWriter, r *http.Request) {
+	var doc map[string]any
+	if err := json.Unmarshal(openApiDocument, &doc); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.New("invalid OpenAPI document"))
+		return
+	}
+	doc["info"].(map[string]any)["version"] = lib.AppVersion()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,290 @@
+package web
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+/*
+The body of POST /api/v1/jobs/flight: a flight from the fractal's view (centerCX, centerCY, diameterCX) to the end view,
+like the flight command. Compositions are not supported.
+*/
+type FlightRequest struct {
+	RenderRequest
+	EndCenterCX   float64 `json:"endCenterCX"`
+	EndCenterCY   float64 `json:"endCenterCY"`
+	EndDiameterCX float64 `json:"endDiameterCX"`
+	// duration of the flight, in seconds
+	Duration int `json:"duration"`
+	// frames per second
+	Fps int `json:"fps"`
+}
+
+// the number of frames of the flight, without the start frame
+func (f FlightRequest) nrOfFrames() int {
+	return f.Duration * f.Fps
+}
+
+// Queues the render request of the JSON body (RenderRequest) as job, and returns the job (202 Accepted).
+func (s *WebServer) handleApiSubmitRenderJob(w http.ResponseWriter, r *http.Request) {
+	var req RenderRequest
+	if err := decodeJsonBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	v := newParamValidator(nil)
+	out, err := s.renderOutput(r.Header.Get("Accept"), v, req.Output)
+	if err != nil {
+		writeImageOutputError(w, err)
+		return
+	}
+	// the format is negotiated now, the job runs without the request:
+	req.Output.Format = out.format.Name
+	s.prepareRender(v, req)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.submitJob(w, Job{Type: JOB_TYPE_RENDER, Render: &req})
+}
+
+// Queues the flight request of the JSON body (FlightRequest) as job, and returns the job (202 Accepted).
+func (s *WebServer) handleApiSubmitFlightJob(w http.ResponseWriter, r *http.Request) {
+	var req FlightRequest
+	if err := decodeJsonBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	v := newParamValidator(nil)
+	out, err := s.renderOutput(r.Header.Get("Accept"), v, req.Output)
+	if err != nil {
+		writeImageOutputError(w, err)
+		return
+	}
+	req.Output.Format = out.format.Name
+	s.prepareFlight(v, req)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.submitJob(w, Job{Type: JOB_TYPE_FLIGHT, Flight: &req})
+}
+
+func (s *WebServer) submitJob(w http.ResponseWriter, job Job) {
+	job, err := s.jobs.Submit(job)
+	if errors.Is(err, errJobQueueFull) {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	writeJson(w, http.StatusAccepted, job)
+}
+
+// Returns all jobs, the newest first.
+func (s *WebServer) handleApiListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, s.jobs.List())
+}
+
+// Returns the job with its status and progress.
+func (s *WebServer) handleApiGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJson(w, http.StatusOK, job)
+}
+
+// Cancels a queued or running job. A running job is canceled asynchronously: its status changes shortly after.
+func (s *WebServer) handleApiCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJson(w, http.StatusOK, job)
+}
+
+// Removes a finished job and its results.
+func (s *WebServer) handleApiDeleteJob(w http.ResponseWriter, r *http.Request) {
+	err := s.jobs.Delete(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusConflict, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Downloads a result image of a finished job.
+func (s *WebServer) handleApiJobResult(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	path, ok := s.jobs.ResultPath(job, r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("result not found"))
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// Downloads all result images of a finished job as zip archive.
+func (s *WebServer) handleApiJobResultsZip(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if job.Status != JOB_STATUS_DONE {
+		writeError(w, http.StatusConflict, fmt.Errorf("the job is %s", job.Status))
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", job.ID))
+	archive := zip.NewWriter(w)
+	for _, name := range job.Results {
+		path, _ := s.jobs.ResultPath(job, name)
+		// the images are compressed already:
+		if err := addZipFile(archive, path, name, zip.Store); err != nil {
+			// the response is already started:
+			return
+		}
+	}
+	archive.Close()
+}
+
+func addZipFile(archive *zip.Writer, path, name string, method uint16) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+/*
+Validates the flight request, invalid fields are recorded in the validator. Returns the server's presets
+with the request's presets added.
+*/
+func (s *WebServer) prepareFlight(v *paramValidator, req FlightRequest) lib.Presets {
+	v.check(req.Composition == nil, "composition", "is not supported for flights")
+	presets := s.prepareRender(v, req.RenderRequest)
+	v.check(req.EndDiameterCX > 0, "endDiameterCX", "must be greater than 0")
+	v.check(req.Duration >= 1, "duration", "must be at least 1")
+	v.check(req.Fps >= 1, "fps", "must be at least 1")
+	if req.Duration >= 1 && req.Fps >= 1 && req.Duration > s.limits.MaxFrames/req.Fps {
+		v.fail("duration", "the flight is too long: %d frames exceed the limit of %d frames", req.nrOfFrames(), s.limits.MaxFrames)
+	}
+	return presets
+}
+
+// runs a render or flight job, see jobRunFunc
+func (s *WebServer) runJob(ctx context.Context, job Job, dir string, progress func(float64)) ([]string, error) {
+	switch job.Type {
+	case JOB_TYPE_RENDER:
+		return s.runRenderJob(ctx, *job.Render, dir, progress)
+	case JOB_TYPE_FLIGHT:
+		return s.runFlightJob(ctx, *job.Flight, dir, progress)
+	}
+	return nil, fmt.Errorf("unknown job type: %s", job.Type)
+}
+
+func (s *WebServer) runRenderJob(ctx context.Context, req RenderRequest, dir string, progress func(float64)) ([]string, error) {
+	// the request is validated again: the server's presets or limits may have changed since a restart
+	v := newParamValidator(nil)
+	out, _ := s.renderOutput("", v, req.Output)
+	presets := s.prepareRender(v, req)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	img, err := renderRequestImage(ctx, req, presets, func(done, total int) {
+		progress(float64(done) / float64(total))
+	})
+	if err != nil {
+		return nil, err
+	}
+	name := "image." + out.format.Extension()
+	return []string{name}, writeImageFile(filepath.Join(dir, name), img, out)
+}
+
+func (s *WebServer) runFlightJob(ctx context.Context, req FlightRequest, dir string, progress func(float64)) ([]string, error) {
+	v := newParamValidator(nil)
+	out, _ := s.renderOutput("", v, req.Output)
+	presets := s.prepareFlight(v, req)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	views := lib.FlightViews(
+		lib.FlightView{CenterCX: req.CenterCX, CenterCY: req.CenterCY, DiameterCX: req.DiameterCX},
+		lib.FlightView{CenterCX: req.EndCenterCX, CenterCY: req.EndCenterCY, DiameterCX: req.EndDiameterCX},
+		req.nrOfFrames(),
+	)
+	results := make([]string, 0, len(views))
+	for i, view := range views {
+		preset := req.FractalPreset
+		preset.CenterCX, preset.CenterCY, preset.DiameterCX = view.CenterCX, view.CenterCY, view.DiameterCX
+		fractal, err := lib.NewFractalFromPresets(req.Width, req.Height, presets.ColorPresets, preset)
+		if err != nil {
+			return nil, err
+		}
+		img, err := lib.CalcFractalImageContext(ctx, fractal, func(done, total int) {
+			progress((float64(i) + float64(done)/float64(total)) / float64(len(views)))
+		})
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%08d.%s", i, out.format.Extension())
+		if err := writeImageFile(filepath.Join(dir, name), img, out); err != nil {
+			return nil, err
+		}
+		results = append(results, name)
+	}
+	return results, nil
+}
+
+func writeImageFile(path string, img *lib.FractImage, out imageOutput) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	img.Dither = out.dither
+	if err := out.format.Encode(file, img, out.opts); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+	"image/color"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+// reads the optional coloring query params (palette mapping, coloring algorithms, interior coloring, distance estimation) into the given fractal params
+func (s *WebServer) applyColoringParams(v *paramValidator, params *lib.CommonFractParams) {
+	if ident := v.query.Get("interiorColorPreset"); ident != "" {
+		interiorPreset, err := s.currentPresets().ColorPresets.GetByIdent(ident)
+		if err != nil {
+			v.fail("interiorColorPreset", "unknown color preset")
+		}
+		params.InteriorColorPalette = interiorPreset.Palette
+	}
+	if interiorColor := v.query.Get("interiorColor"); interiorColor != "" {
+		c, err := lib.ParseCssColor(interiorColor)
+		if err != nil {
+			v.fail("interiorColor", "%s", err)
+		}
+		params.InteriorColor = color.NRGBA(c)
+	}
+	params.ColorPaletteMapping = lib.PaletteMapping(v.enumParam("colorPaletteMapping", "", paletteMappings))
+	params.ColorPaletteMappingExponent = v.floatParam("colorPaletteMappingExponent", 0)
+	params.ColorPaletteOffset = v.floatParam("colorPaletteOffset", 0)
+
+	params.ColoringAlgorithm = lib.ColoringAlgorithm(v.enumParam("coloringAlgorithm", "", coloringAlgorithms))
+	params.ColoringAlgorithm2 = lib.ColoringAlgorithm(v.enumParam("coloringAlgorithm2", "", coloringAlgorithms))
+	params.ColoringBlendMode = lib.BlendMode(v.enumParam("coloringBlendMode", "", blendModes))
+	if v.has("coloringBlendFactor") {
+		blendFactor := v.floatParam("coloringBlendFactor", 0)
+		params.ColoringBlendFactor = &blendFactor
+	}
+	params.ColoringDensity = v.floatParam("coloringDensity", 0)
+
+	params.InteriorMode = lib.InteriorMode(v.enumParam("interiorMode", "", interiorModes))
+	params.PeriodCheck = v.boolParam("periodCheck", true)
+
+	params.DistanceMode = lib.DistanceMode(v.enumParam("distanceMode", "", distanceModes))
+	params.DistanceOutlineWidth = v.floatParam("distanceOutlineWidth", 0)
+	if v.has("lightAngle") {
+		lightAngle := v.floatParam("lightAngle", 0)
+		params.LightAngle = &lightAngle
+	}
+	params.LightHeight = v.floatParam("lightHeight", 0)
+}
@@ -14,5 +14,12 @@ func NewCorsHandler(handler http.Handler) *CorsHandler {
 
 func (l *CorsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// preflight requests of POST requests with a JSON body and PUT / DELETE requests:
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	l.internalHandler.ServeHTTP(w, r)
 }
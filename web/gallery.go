@@ -0,0 +1,80 @@
+package web
+
+import (
+	"image/color"
+	"net/http"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+/*
+Renders the contact sheet of all fractal presets, e.g. /gallery.jpg?cellWidth=160&cellHeight=100: the extension
+selects the image format. With fractalPreset, the sheet shows all color presets applied to that fractal preset.
+The cells are in the order of the presets of /presets.json, in rows from the top left; with captions=false, gap=0 and
+columns=1 the sheet can be used as CSS sprite. The image output params (quality, dither, ...) are the same as for
+/fractal-image.
+*/
+func (s *WebServer) handleGalleryImage(w http.ResponseWriter, r *http.Request) {
+	v := newParamValidator(r.URL.Query())
+	format, err := lib.ImageFormatFromFilename(r.URL.Path)
+	if err != nil {
+		v.fail("format", "%s", err)
+	}
+	out, err := readImageOutput(r, v, format.Name)
+	if err != nil {
+		writeImageOutputError(w, err)
+		return
+	}
+	presets := s.currentPresets()
+	opts := lib.ContactSheetOptions{
+		CellWidth:  v.intParam("cellWidth", 160, 1, 4096),
+		CellHeight: v.intParam("cellHeight", 100, 1, 4096),
+		Columns:    v.intParam("columns", 0, 0, 1000),
+		Gap:        v.intParam("gap", 8, 0, 1000),
+		Captions:   v.boolParam("captions", true),
+	}
+	background := "#202020"
+	if v.has("background") {
+		background = v.query.Get("background")
+	}
+	if c, err := lib.ParseCssColor(background); err != nil {
+		v.fail("background", "%s", err)
+	} else {
+		opts.Background = color.NRGBA(c)
+	}
+
+	cells := lib.FractalPresetsContactSheet(presets)
+	if v.has("fractalPreset") {
+		fractalPreset, err := presets.FractalPresets.GetByName(v.query.Get("fractalPreset"))
+		if err != nil {
+			v.fail("fractalPreset", "unknown fractal preset")
+		}
+		cells = lib.ColorPresetsContactSheet(presets, fractalPreset)
+	}
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	width, height := opts.Size(len(cells))
+	maxIterations := 0
+	for _, cell := range cells {
+		maxIterations = max(maxIterations, cell.Fractal.MaxIterations)
+	}
+	v.checkLimits(s.limits, width, height, maxIterations)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	img, err := lib.CalcContactSheetImage(r.Context(), presets.ColorPresets, cells, opts, nil)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// the client is gone
+			return
+		}
+		// the presets of the server are invalid:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	encodeImage(img, out, w)
+}
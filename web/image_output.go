@@ -0,0 +1,93 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+var errNotAcceptable = errors.New("none of the accepted image formats is supported")
+
+// the output format and encoder options of an image request
+type imageOutput struct {
+	format lib.ImageFormat
+	opts   lib.EncodeOptions
+	dither lib.DitherMode
+	// the format was chosen by the Accept header: the response varies by it
+	negotiated bool
+}
+
+/*
+Reads the image format and the optional encoder query params (dither, quality, chromaSubsampling, pngCompression, gifColors,
+metadata). Invalid params are recorded in the validator.
+The format "auto" selects the format by the request's Accept header, defaulting to jpeg; if none of the accepted
+formats is supported, errNotAcceptable is returned.
+*/
+func readImageOutput(r *http.Request, v *paramValidator, formatName string) (imageOutput, error) {
+	out, err := selectImageFormat(r.Header.Get("Accept"), v, "format", formatName)
+	if err != nil {
+		return out, err
+	}
+	out.opts = lib.EncodeOptions{
+		JpegQuality:       v.intParam("quality", 0, 1, 100),
+		ChromaSubsampling: lib.ChromaSubsampling(v.enumParam("chromaSubsampling", "", chromaSubsamplings)),
+		PngCompression:    lib.PngCompression(v.enumParam("pngCompression", "", pngCompressions)),
+		GifColors:         v.intParam("gifColors", 0, 2, 256),
+		OmitMetadata:      !v.boolParam("metadata", true),
+	}
+	out.dither = lib.DitherMode(v.enumParam("dither", "", ditherModes))
+	return out, nil
+}
+
+// the image output with the named format, or the format selected by the accept header value for "auto"
+func selectImageFormat(accept string, v *paramValidator, field, formatName string) (imageOutput, error) {
+	out := imageOutput{}
+	jpegFormat, _ := lib.GetImageFormat("jpeg")
+	if strings.ToLower(formatName) == "auto" {
+		format, ok := lib.NegotiateImageFormat(accept, jpegFormat)
+		if !ok {
+			return out, errNotAcceptable
+		}
+		out.format, out.negotiated = format, true
+		return out, nil
+	}
+	format, err := lib.GetImageFormat(formatName)
+	if err != nil {
+		v.fail(field, "must be one of auto, %s", strings.Join(lib.ImageFormatNames(), ", "))
+	}
+	out.format = format
+	return out, nil
+}
+
+// writes the error of readImageOutput: 406 if no accepted format is supported
+func writeImageOutputError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotAcceptable) {
+		writeError(w, http.StatusNotAcceptable, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+// the images refer to presets, which can be changed by the preset API or a reload of the presets files: they are
+// only cached for a short time
+const imageCacheControl = "public, max-age=300"
+
+// encodes the image: as the image is encoded before sending it, encoder errors are reported as 500
+func encodeImage(img *lib.FractImage, out imageOutput, w http.ResponseWriter) {
+	img.Dither = out.dither
+	var buf bytes.Buffer
+	if err := out.format.Encode(&buf, img, out.opts); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("encoding the %s image failed: %w", out.format.Name, err))
+		return
+	}
+	w.Header().Set("Cache-Control", imageCacheControl)
+	w.Header().Set("Content-Type", out.format.MimeType)
+	if out.negotiated {
+		w.Header().Set("Vary", "Accept")
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,345 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	JOB_TYPE_RENDER = "render"
+	JOB_TYPE_FLIGHT = "flight"
+)
+
+const (
+	JOB_STATUS_QUEUED   = "queued"
+	JOB_STATUS_RUNNING  = "running"
+	JOB_STATUS_DONE     = "done"
+	JOB_STATUS_FAILED   = "failed"
+	JOB_STATUS_CANCELED = "canceled"
+)
+
+const jobFileName = "job.json"
+
+var (
+	errJobNotFound  = errors.New("job not found")
+	errJobQueueFull = errors.New("too many queued jobs, try again later")
+)
+
+/*
+An asynchronous render or flight job. The job and its results are stored in its own directory
+of the job queue, so finished jobs survive a server restart.
+*/
+type Job struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	// 0.0 - 1.0
+	Progress float64    `json:"progress"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// the file names of the rendered images of a done job, see GET /api/v1/jobs/{id}/results/{name}
+	Results []string `json:"results,omitempty"`
+
+	Render *RenderRequest `json:"render,omitempty"`
+	Flight *FlightRequest `json:"flight,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JOB_STATUS_DONE || j.Status == JOB_STATUS_FAILED || j.Status == JOB_STATUS_CANCELED
+}
+
+/*
+Runs a job: renders the job's images into the given directory, and returns their file names.
+The progress (0.0 - 1.0) is reported while rendering.
+*/
+type jobRunFunc func(ctx context.Context, job Job, dir string, progress func(float64)) ([]string, error)
+
+// interval to remove the expired jobs
+const jobExpireInterval = time.Minute
+
+/*
+The queue of the asynchronous jobs: the jobs are run in the order of their submission by a fixed number of workers.
+Each job is stored in a sub directory of the queue's directory; unfinished jobs of a previous run are queued again
+on startup. Finished jobs are removed after the retention time.
+*/
+type jobQueue struct {
+	dir string
+	run jobRunFunc
+	// max. number of pending jobs, 0 = unlimited
+	maxQueued int
+	// how long finished jobs are kept, 0 = until they are deleted
+	retention time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    map[string]*Job
+	pending []*Job
+	cancels map[string]context.CancelFunc
+}
+
+func newJobQueue(dir string, workers int, maxQueued int, retention time.Duration, run jobRunFunc) (*jobQueue, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	q := &jobQueue{
+		dir:       dir,
+		run:       run,
+		maxQueued: maxQueued,
+		retention: retention,
+		jobs:      make(map[string]*Job),
+		cancels:   make(map[string]context.CancelFunc),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	for range max(1, workers) {
+		go q.work()
+	}
+	if retention > 0 {
+		q.removeExpired()
+		go func() {
+			for range time.Tick(jobExpireInterval) {
+				q.removeExpired()
+			}
+		}()
+	}
+	return q, nil
+}
+
+// removes the jobs finished longer than the retention time ago, with their results
+func (q *jobQueue) removeExpired() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	expired := time.Now().Add(-q.retention)
+	for id, job := range q.jobs {
+		if !job.finished() || job.Finished == nil || job.Finished.After(expired) {
+			continue
+		}
+		if err := os.RemoveAll(q.jobDir(id)); err != nil {
+			log.Printf("Removing the expired job %s failed: %v\n", id, err)
+			continue
+		}
+		delete(q.jobs, id)
+	}
+}
+
+// loads the stored jobs, and queues the unfinished jobs again
+func (q *jobQueue) load() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, entry.Name(), jobFileName))
+		if err != nil {
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || job.ID != entry.Name() {
+			log.Printf("Ignoring invalid job %s: %v\n", entry.Name(), err)
+			continue
+		}
+		q.jobs[job.ID] = &job
+		if !job.finished() {
+			job.Status, job.Progress, job.Started, job.Results = JOB_STATUS_QUEUED, 0, nil, nil
+			q.removeResults(job.ID)
+			q.pending = append(q.pending, &job)
+		}
+	}
+	slices.SortFunc(q.pending, func(a, b *Job) int { return a.Created.Compare(b.Created) })
+	return nil
+}
+
+// adds a new job to the queue, and returns it. Fails with errJobQueueFull if too many jobs are queued.
+func (q *jobQueue) Submit(job Job) (Job, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	job.ID = hex.EncodeToString(id)
+	job.Status = JOB_STATUS_QUEUED
+	job.Created = time.Now().UTC()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxQueued > 0 && len(q.pending) >= q.maxQueued {
+		return job, errJobQueueFull
+	}
+	if err := os.MkdirAll(q.jobDir(job.ID), os.ModePerm); err != nil {
+		return job, err
+	}
+	if err := q.save(&job); err != nil {
+		os.RemoveAll(q.jobDir(job.ID))
+		return job, err
+	}
+	q.jobs[job.ID] = &job
+	q.pending = append(q.pending, &job)
+	q.cond.Signal()
+	return job, nil
+}
+
+// returns a copy of the job
+func (q *jobQueue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	return *job, nil
+}
+
+// returns all jobs, the newest first
+func (q *jobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, *job)
+	}
+	slices.SortFunc(jobs, func(a, b Job) int { return b.Created.Compare(a.Created) })
+	return jobs
+}
+
+/*
+Cancels a queued or running job. A running job stops at the next pixel block, its partial results are removed.
+Returns the job after canceling it.
+*/
+func (q *jobQueue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	switch job.Status {
+	case JOB_STATUS_QUEUED:
+		q.pending = slices.DeleteFunc(q.pending, func(j *Job) bool { return j == job })
+		q.finish(job, JOB_STATUS_CANCELED, nil)
+	case JOB_STATUS_RUNNING:
+		// the worker finishes the job:
+		q.cancels[id]()
+	}
+	return *job, nil
+}
+
+// removes a finished job and its results
+func (q *jobQueue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return errJobNotFound
+	}
+	if !job.finished() {
+		return fmt.Errorf("the job is %s: cancel it first", job.Status)
+	}
+	if err := os.RemoveAll(q.jobDir(id)); err != nil {
+		return err
+	}
+	delete(q.jobs, id)
+	return nil
+}
+
+// the path of a result file of the job, if the job has a result with this name
+func (q *jobQueue) ResultPath(job Job, name string) (string, bool) {
+	if !slices.Contains(job.Results, name) {
+		return "", false
+	}
+	return filepath.Join(q.jobDir(job.ID), name), true
+}
+
+func (q *jobQueue) jobDir(id string) string {
+	return filepath.Join(q.dir, id)
+}
+
+// runs the pending jobs, one after another
+func (q *jobQueue) work() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		ctx, cancel := context.WithCancel(context.Background())
+		q.cancels[job.ID] = cancel
+		started := time.Now().UTC()
+		job.Status, job.Started = JOB_STATUS_RUNNING, &started
+		q.save(job)
+		jobCopy := *job
+		q.mu.Unlock()
+
+		results, err := q.run(ctx, jobCopy, q.jobDir(job.ID), func(progress float64) {
+			q.mu.Lock()
+			// the progress is reported from several goroutines, not necessarily in order:
+			job.Progress = max(job.Progress, progress)
+			q.mu.Unlock()
+		})
+
+		q.mu.Lock()
+		delete(q.cancels, job.ID)
+		switch {
+		case ctx.Err() != nil:
+			q.removeResults(job.ID)
+			q.finish(job, JOB_STATUS_CANCELED, nil)
+		case err != nil:
+			q.removeResults(job.ID)
+			q.finish(job, JOB_STATUS_FAILED, err)
+		default:
+			job.Results, job.Progress = results, 1
+			q.finish(job, JOB_STATUS_DONE, nil)
+		}
+		q.mu.Unlock()
+		cancel()
+	}
+}
+
+// sets the final status of the job, the caller holds the lock
+func (q *jobQueue) finish(job *Job, status string, err error) {
+	finished := time.Now().UTC()
+	job.Status, job.Finished = status, &finished
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if err := q.save(job); err != nil {
+		log.Printf("Saving job %s failed: %v\n", job.ID, err)
+	}
+}
+
+// removes all files of the job except the job file
+func (q *jobQueue) removeResults(id string) {
+	entries, _ := os.ReadDir(q.jobDir(id))
+	for _, entry := range entries {
+		if entry.Name() != jobFileName {
+			os.RemoveAll(filepath.Join(q.jobDir(id), entry.Name()))
+		}
+	}
+}
+
+// stores the job file atomically: a crash while writing does not leave a broken job file
+func (q *jobQueue) save(job *Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.jobDir(job.ID), jobFileName)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+// creates the palette generator options from the query params, for previewing generated palettes
+// in the palette viewer. Only the procedural generators are supported, as no reference image is available.
+func paletteGenerateOptions(query url.Values) (lib.PaletteGenerateOptions, error) {
+	opts := lib.DefaultPaletteGenerateOptions()
+	opts.Generator = lib.PaletteGenerator(strings.ToLower(query.Get("generator")))
+	switch opts.Generator {
+	case lib.PALETTE_GENERATOR_COSINE, lib.PALETTE_GENERATOR_HARMONY, lib.PALETTE_GENERATOR_RANDOM:
+	default:
+		return opts, fmt.Errorf("unsupported palette generator: %s", opts.Generator)
+	}
+
+	var err error
+	if colors := query.Get("colors"); colors != "" {
+		if opts.Colors, err = strconv.Atoi(colors); err != nil {
+			return opts, fmt.Errorf("invalid colors: %s", colors)
+		}
+	}
+	for _, cosineParam := range []struct {
+		name   string
+		target *[3]float64
+	}{
+		{"cosineA", &opts.CosineA},
+		{"cosineB", &opts.CosineB},
+		{"cosineC", &opts.CosineC},
+		{"cosineD", &opts.CosineD},
+	} {
+		value := query.Get(cosineParam.name)
+		if value == "" {
+			continue
+		}
+		parts := strings.Split(value, ",")
+		if len(parts) != 3 {
+			return opts, fmt.Errorf("%s needs 3 values (r,g,b)", cosineParam.name)
+		}
+		for i, part := range parts {
+			if cosineParam.target[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				return opts, fmt.Errorf("invalid %s: %s", cosineParam.name, value)
+			}
+		}
+	}
+	for _, floatParam := range []struct {
+		name   string
+		target *float64
+	}{
+		{"baseHue", &opts.BaseHue},
+		{"saturation", &opts.Saturation},
+		{"lightness", &opts.Lightness},
+	} {
+		if value := query.Get(floatParam.name); value != "" {
+			if *floatParam.target, err = strconv.ParseFloat(value, 64); err != nil {
+				return opts, fmt.Errorf("invalid %s: %s", floatParam.name, value)
+			}
+		}
+	}
+	if harmony := query.Get("harmony"); harmony != "" {
+		opts.Harmony = lib.ColorHarmony(strings.ToLower(harmony))
+	}
+	if seed := query.Get("seed"); seed != "" {
+		if opts.Seed, err = strconv.ParseUint(seed, 10, 64); err != nil {
+			return opts, fmt.Errorf("invalid seed: %s", seed)
+		}
+	}
+	return opts, nil
+}
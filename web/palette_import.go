@@ -0,0 +1,70 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+const maxPaletteUploadSize = 10 << 20
+
+/*
+Imports a palette file, uploaded as multipart form (field "file"), and returns the
+resulting color presets as JSON, in the same format as /presets.json's colorPresets.
+
+The format is taken from the "format" query param, or detected from the uploaded file's name.
+The presets are not stored on the server: the client can use them directly.
+*/
+func (s *WebServer) handlePaletteImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPaletteUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing or invalid upload file: %w", err))
+		return
+	}
+	defer file.Close()
+
+	format := lib.PaletteFormat(strings.ToLower(r.URL.Query().Get("format")))
+	if format == "" {
+		format, err = lib.PaletteFormatFromFilename(header.Filename)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	imageRow, err := strconv.Atoi(r.URL.Query().Get("imageRow"))
+	if err != nil {
+		imageRow = -1
+	}
+	imageColors, _ := strconv.Atoi(r.URL.Query().Get("imageColors"))
+	if imageColors <= 0 {
+		imageColors = 32
+	}
+
+	colorPresets, err := lib.ImportColorPresets(file, format, lib.PaletteImportOptions{
+		Name:           r.URL.Query().Get("name"),
+		FallbackName:   strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename)),
+		ImageRow:       imageRow,
+		ImageColors:    imageColors,
+		MaxImagePixels: s.limits.MaxPixels,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	jsonStream, err := json.Marshal(colorPresets)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonStream)
+}
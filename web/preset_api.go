@@ -0,0 +1,277 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+var (
+	errPresetNotFound  = errors.New("preset not found")
+	errPresetExists    = errors.New("a preset with this name already exists")
+	errPresetInUse     = errors.New("the preset is in use")
+	errPresetsReadOnly = errors.New("the presets are read-only: start the server with a --presets-file (the last one must be a file) to change them")
+	errPresetReadOnly  = errors.New("the preset is not defined in the server's presets file")
+)
+
+// Returns all fractal presets.
+func (s *WebServer) handleApiListFractalPresets(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, s.currentPresets().FractalPresets)
+}
+
+// Returns the fractal preset with the given name.
+func (s *WebServer) handleApiGetFractalPreset(w http.ResponseWriter, r *http.Request) {
+	preset, err := s.currentPresets().FractalPresets.GetByName(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, errPresetNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, preset)
+}
+
+// Creates the fractal preset of the JSON body (201 Created), or 409 Conflict if a preset with its name exists.
+func (s *WebServer) handleApiCreateFractalPreset(w http.ResponseWriter, r *http.Request) {
+	var preset lib.FractalPreset
+	if err := decodeJsonBody(w, r, &preset); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.saveFractalPreset(w, "", preset)
+}
+
+// Replaces the fractal preset with the given name by the JSON body, or creates it. The body's name may be omitted.
+func (s *WebServer) handleApiPutFractalPreset(w http.ResponseWriter, r *http.Request) {
+	var preset lib.FractalPreset
+	if err := decodeJsonBody(w, r, &preset); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.saveFractalPreset(w, r.PathValue("name"), preset)
+}
+
+// saves the fractal preset: the preset with the given name is replaced, an empty name creates a new preset
+func (s *WebServer) saveFractalPreset(w http.ResponseWriter, name string, preset lib.FractalPreset) {
+	if name != "" && preset.Name == "" {
+		preset.Name = name
+	}
+	status := http.StatusOK
+	ok := s.updatePresets(w, func(presets *lib.Presets) error {
+		v := newParamValidator(nil)
+		v.check(strings.TrimSpace(preset.Name) != "", "name", "is required")
+		v.check(name == "" || strings.EqualFold(preset.Name, name), "name", "must match the name of the URL")
+		// the color preset may be defined in the presets file, or in another presets file:
+		validateFractalPreset(v, "", preset, slices.Concat(presets.ColorPresets, s.currentPresets().ColorPresets))
+		// saved presets are rendered by name, without iteration params of the request:
+		v.checkIterations(s.limits, "maxIterations", preset.MaxIterations)
+		if err := v.Err(); err != nil {
+			return err
+		}
+		i := slices.IndexFunc(presets.FractalPresets, func(p lib.FractalPreset) bool { return strings.EqualFold(p.Name, preset.Name) })
+		// a preset of another presets file can only be overridden by PUT:
+		_, existsErr := s.currentPresets().FractalPresets.GetByName(preset.Name)
+		switch {
+		case (i >= 0 || existsErr == nil) && name == "":
+			return errPresetExists
+		case i >= 0:
+			presets.FractalPresets[i] = preset
+		default:
+			presets.FractalPresets = append(presets.FractalPresets, preset)
+			status = http.StatusCreated
+		}
+		return nil
+	})
+	if ok {
+		w.Header().Set("Location", "/api/v1/presets/fractals/"+url.PathEscape(preset.Name))
+		writeJson(w, status, preset)
+	}
+}
+
+// Deletes the fractal preset with the given name, or 409 Conflict if a composition uses it.
+func (s *WebServer) handleApiDeleteFractalPreset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ok := s.updatePresets(w, func(presets *lib.Presets) error {
+		i := slices.IndexFunc(presets.FractalPresets, func(p lib.FractalPreset) bool { return strings.EqualFold(p.Name, name) })
+		if i < 0 {
+			return presetNotInFileError(s.currentPresets().Origins.FractalPresets, name)
+		}
+		for _, composition := range presets.CompositionPresets {
+			for _, layer := range composition.Layers {
+				if strings.EqualFold(layer.FractalPreset, name) || (layer.Mask != nil && strings.EqualFold(layer.Mask.FractalPreset, name)) {
+					return fmt.Errorf("%w: composition '%s' uses it", errPresetInUse, composition.Name)
+				}
+			}
+		}
+		presets.FractalPresets = slices.Delete(presets.FractalPresets, i, i+1)
+		return nil
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Returns all color presets.
+func (s *WebServer) handleApiListColorPresets(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, s.currentPresets().ColorPresets)
+}
+
+// Returns the color preset with the given ident.
+func (s *WebServer) handleApiGetColorPreset(w http.ResponseWriter, r *http.Request) {
+	preset, err := s.currentPresets().ColorPresets.GetByIdent(strings.ToLower(r.PathValue("ident")))
+	if err != nil {
+		writeError(w, http.StatusNotFound, errPresetNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, preset)
+}
+
+// Creates the color preset of the JSON body (201 Created), or 409 Conflict if a preset with its ident exists.
+func (s *WebServer) handleApiCreateColorPreset(w http.ResponseWriter, r *http.Request) {
+	var preset lib.ColorPreset
+	if err := decodeJsonBody(w, r, &preset); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.saveColorPreset(w, "", preset)
+}
+
+// Replaces the color preset with the given ident by the JSON body, or creates it. The body's ident may be omitted.
+func (s *WebServer) handleApiPutColorPreset(w http.ResponseWriter, r *http.Request) {
+	var preset lib.ColorPreset
+	if err := decodeJsonBody(w, r, &preset); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.saveColorPreset(w, r.PathValue("ident"), preset)
+}
+
+// saves the color preset: the preset with the given ident is replaced, an empty ident creates a new preset
+func (s *WebServer) saveColorPreset(w http.ResponseWriter, ident string, preset lib.ColorPreset) {
+	if ident != "" && preset.Ident == "" {
+		preset.Ident = ident
+	}
+	status := http.StatusOK
+	ok := s.updatePresets(w, func(presets *lib.Presets) error {
+		v := newParamValidator(nil)
+		v.check(strings.TrimSpace(preset.Name) != "", "name", "is required")
+		v.check(ident == "" || strings.EqualFold(preset.Ident, ident), "ident", "must match the ident of the URL")
+		validateColorPreset(v, "", preset)
+		if err := v.Err(); err != nil {
+			return err
+		}
+		i := slices.IndexFunc(presets.ColorPresets, func(p lib.ColorPreset) bool { return strings.EqualFold(p.Ident, preset.Ident) })
+		// a preset of another presets file can only be overridden by PUT:
+		_, existsErr := s.currentPresets().ColorPresets.GetByIdent(strings.ToLower(preset.Ident))
+		switch {
+		case (i >= 0 || existsErr == nil) && ident == "":
+			return errPresetExists
+		case i >= 0:
+			presets.ColorPresets[i] = preset
+		default:
+			presets.ColorPresets = append(presets.ColorPresets, preset)
+			status = http.StatusCreated
+		}
+		return nil
+	})
+	if ok {
+		w.Header().Set("Location", "/api/v1/presets/colors/"+url.PathEscape(preset.Ident))
+		writeJson(w, status, preset)
+	}
+}
+
+// Deletes the color preset with the given ident, or 409 Conflict if a fractal preset uses it.
+func (s *WebServer) handleApiDeleteColorPreset(w http.ResponseWriter, r *http.Request) {
+	ident := r.PathValue("ident")
+	ok := s.updatePresets(w, func(presets *lib.Presets) error {
+		i := slices.IndexFunc(presets.ColorPresets, func(p lib.ColorPreset) bool { return strings.EqualFold(p.Ident, ident) })
+		if i < 0 {
+			return presetNotInFileError(s.currentPresets().Origins.ColorPresets, ident)
+		}
+		for _, fractalPreset := range presets.FractalPresets {
+			if strings.EqualFold(fractalPreset.ColorPreset, ident) || strings.EqualFold(fractalPreset.InteriorColorPreset, ident) {
+				return fmt.Errorf("%w: fractal preset '%s' uses it", errPresetInUse, fractalPreset.Name)
+			}
+		}
+		presets.ColorPresets = slices.Delete(presets.ColorPresets, i, i+1)
+		return nil
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+/*
+Changes the presets with the update function, and saves them to the presets file. The update function gets the
+current content of the presets file (changed by others since the server read it); the server uses the saved presets,
+merged over the other presets files again. A preset of another presets file is overridden by a preset with the same
+name in the presets file.
+Writes the error response and returns false if the presets are read-only or the update fails.
+*/
+func (s *WebServer) updatePresets(w http.ResponseWriter, update func(presets *lib.Presets) error) bool {
+	if s.presetsFile == "" {
+		writeError(w, http.StatusForbidden, errPresetsReadOnly)
+		return false
+	}
+	s.presetsMu.Lock()
+	defer s.presetsMu.Unlock()
+	var merged lib.Presets
+	_, err := lib.UpdatePresetJson(s.presetsFile, func(presets *lib.Presets) error {
+		if err := update(presets); err != nil {
+			return err
+		}
+		// the saved file must be valid, to be loaded again:
+		var err error
+		merged, err = lib.ReadPresetFilesReplacingLast(s.presetsFiles, s.embeddedPresets, *presets)
+		var problems lib.PresetProblems
+		if errors.As(err, &problems) {
+			// warnings do not prevent loading the saved presets:
+			problems = problems.Errors()
+			validationErr := make(ValidationError, len(problems))
+			for i, problem := range problems {
+				validationErr[i] = FieldError{Field: problem.Path, Message: problem.Message}
+				if problem.File != s.presetsFile {
+					validationErr[i].Field = problem.File + ": " + problem.Path
+				}
+			}
+			return validationErr
+		}
+		return err
+	})
+	var validationErr ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, errPresetNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, errPresetReadOnly):
+		writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, errPresetExists), errors.Is(err, errPresetInUse):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("saving the presets failed: %w", err))
+	default:
+		s.presets.Store(&merged)
+		return true
+	}
+	return false
+}
+
+// the error for a preset which is not in the presets file: not found, or defined in another presets file
+func presetNotInFileError(origins map[string]lib.PresetOrigin, key string) error {
+	if origin, ok := origins[strings.ToLower(key)]; ok {
+		return fmt.Errorf("%w: it is defined in %s", errPresetReadOnly, origin.File)
+	}
+	return errPresetNotFound
+}
+
+// validates the fields of a color preset, the field names are prefixed with the given prefix
+func validateColorPreset(v *paramValidator, prefix string, c lib.ColorPreset) {
+	v.check(strings.TrimSpace(c.Ident) != "", prefix+"ident", "is required")
+	v.check(len(c.Palette) >= 2, prefix+"colors", "must have at least 2 colors")
+	v.checkEnum(prefix+"interpolation", string(c.Interpolation), colorSpaces)
+	v.checkEnum(prefix+"curve", string(c.Curve), interpolationCurves)
+}
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+/*
+Reloads the presets when a presets file changes: the presets files, the files of the presets directories, and the
+files they include. The modification time and size of the files are polled, which works with all platforms and file
+systems (and with editors replacing the file instead of writing it).
+*/
+func (s *WebServer) watchPresetsFiles(interval time.Duration) {
+	last := s.presetsFilesStamp()
+	for range time.Tick(interval) {
+		stamp := s.presetsFilesStamp()
+		if stamp == last {
+			continue
+		}
+		last = stamp
+		s.reloadPresets()
+	}
+}
+
+// the modification times and sizes of the presets files and directories: changes when one of them changes or is removed
+func (s *WebServer) presetsFilesStamp() string {
+	paths := slices.Clone(s.presetsFiles)
+	for _, source := range s.currentPresets().Sources {
+		if !slices.Contains(paths, source) {
+			paths = append(paths, source)
+		}
+	}
+	var stamp strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// reloading reports the error:
+			fmt.Fprintf(&stamp, "%s %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s %d %d\n", path, info.ModTime().UnixNano(), info.Size())
+		if !info.IsDir() {
+			continue
+		}
+		// the files of a directory, which are not read yet if they were invalid:
+		entries, _ := os.ReadDir(path)
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil {
+				fmt.Fprintf(&stamp, "%s/%s %d %d\n", path, entry.Name(), info.ModTime().UnixNano(), info.Size())
+			}
+		}
+	}
+	return stamp.String()
+}
+
+// reads and validates the presets files again, and replaces the presets. If a file is invalid, the current presets are kept.
+func (s *WebServer) reloadPresets() {
+	s.presetsMu.Lock()
+	defer s.presetsMu.Unlock()
+	presets, err := lib.ReadPresetFiles(s.presetsFiles, s.embeddedPresets)
+	if err != nil {
+		log.Printf("Reloading the presets files failed, keeping the current presets:\n%v\n", err)
+		return
+	}
+	s.presets.Store(&presets)
+	log.Printf("Reloaded the presets files %s: %d fractal presets, %d color presets, %d compositions\n",
+		strings.Join(s.presetsFiles, ", "), len(presets.FractalPresets), len(presets.ColorPresets), len(presets.CompositionPresets))
+}
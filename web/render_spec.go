@@ -0,0 +1,91 @@
+package web
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+/*
+Renders the image of a render spec, e.g. /r/1AXy....jpg: the extension selects the image format.
+The optional width / height query params override the size of the spec, the other image output
+params (quality, dither, ...) are the same as for /fractal-image.
+*/
+func (s *WebServer) handleRenderSpecImage(w http.ResponseWriter, r *http.Request) {
+	v := newParamValidator(r.URL.Query())
+	specParam := r.PathValue("spec")
+	ext := path.Ext(specParam)
+	format, err := lib.ImageFormatFromFilename(specParam)
+	if err != nil {
+		v.fail("format", "unknown image format: %s", ext)
+	}
+	out, err := readImageOutput(r, v, format.Name)
+	if err != nil {
+		writeImageOutputError(w, err)
+		return
+	}
+	params, err := lib.DecodeRenderSpec(strings.TrimSuffix(specParam, ext), s.currentPresets().ColorPresets)
+	if err != nil {
+		v.fail("spec", "%s", err)
+	}
+
+	width := v.intParam("width", 0, 1, math.MaxInt32)
+	height := v.intParam("height", 0, 1, math.MaxInt32)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// the size of the spec, if not overridden:
+	width, height = params.Size(width, height)
+	v.checkLimits(s.limits, width, height, params.Fractal.MaxIterations)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	img, err := params.Render(width, height)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	encodeImage(img, out, w)
+}
+
+// Converts the query params of a fractal-image request to a render spec, returned as JSON with the spec's link.
+func (s *WebServer) handleRenderSpec(w http.ResponseWriter, r *http.Request) {
+	v := newParamValidator(r.URL.Query())
+	v.required("width")
+	v.required("height")
+	width := v.intParam("width", 0, 1, math.MaxInt32)
+	height := v.intParam("height", 0, 1, math.MaxInt32)
+	iterFunc, commonFractParams, juliaParams := s.readFractalParams(v, width, height)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fractal, err := lib.NewFractalFromParams(lib.FractalType(iterFunc), commonFractParams, juliaParams.JuliaKr, juliaParams.JuliaKi)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	params, err := lib.NewFractalRenderParams(fractal)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	spec, err := lib.EncodeRenderSpec(params, s.currentPresets().ColorPresets)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"spec": spec,
+		"link": "/r/" + spec + ".jpg",
+	})
+}
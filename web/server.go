@@ -1,13 +1,17 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"image"
 	"io/fs"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bylexus/go-fract/lib"
 )
@@ -15,26 +19,97 @@ import (
 type WebServerConfig struct {
 	Addr      string
 	WebrootFS fs.FS
+	// limits of the rendered images, DefaultLimits if not set
+	Limits Limits
+	// directory to store the asynchronous jobs and their results in. If not set, the job API is disabled.
+	JobsDir string
+	// number of jobs rendered at the same time
+	Workers int
+	// how long finished jobs and their results are kept, 0 = until they are deleted
+	JobRetention time.Duration
+	// the presets files (or directories) the presets are read from, merged over the embedded presets (see
+	// lib.ReadPresetFiles). Changed presets are saved to the last one; if it is not a file, the presets are read-only.
+	PresetsFiles    []string
+	EmbeddedPresets []byte
+	// interval to check the presets files for changes, the presets are reloaded when they change. 0 disables the reload.
+	PresetsReloadInterval time.Duration
 }
 
 type WebServer struct {
 	http.Server
 
-	colorPresets   lib.ColorPresets
-	fractalPresets lib.FractalPresets
+	// the presets are replaced as a whole when they change, requests use the presets at their start
+	presets         atomic.Pointer[lib.Presets]
+	presetsFiles    []string
+	embeddedPresets []byte
+	// the presets file changed presets are saved to, empty if the presets are read-only
+	presetsFile string
+	// serializes the changes of the presets file and the presets
+	presetsMu sync.Mutex
+	limits    Limits
+	jobs      *jobQueue
 }
 
-func NewWebServer(conf WebServerConfig, colorPresets lib.ColorPresets, fractalPresets lib.FractalPresets) *WebServer {
+// Creates the web server with the presets read from conf.PresetsFiles.
+func NewWebServer(conf WebServerConfig, presets lib.Presets) (*WebServer, error) {
 	server := &WebServer{
-		colorPresets:   colorPresets,
-		fractalPresets: fractalPresets,
+		presetsFiles:    conf.PresetsFiles,
+		embeddedPresets: conf.EmbeddedPresets,
+		limits:          conf.Limits,
+	}
+	server.presets.Store(&presets)
+	if server.limits == (Limits{}) {
+		server.limits = DefaultLimits
+	}
+	if len(conf.PresetsFiles) > 0 {
+		last := conf.PresetsFiles[len(conf.PresetsFiles)-1]
+		if info, err := os.Stat(last); err == nil && !info.IsDir() {
+			server.presetsFile = last
+		}
+	}
+	if len(conf.PresetsFiles) > 0 && conf.PresetsReloadInterval > 0 {
+		go server.watchPresetsFiles(conf.PresetsReloadInterval)
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/fractal-image/{format}", server.handleFractalImage)
 	mux.HandleFunc("/paletteViewer", server.handlePaletteViewer)
+	mux.HandleFunc("/r/{spec}", server.handleRenderSpecImage)
+	mux.HandleFunc("/render-spec", server.handleRenderSpec)
 	mux.HandleFunc("/wmts", server.handleWmtsRequest)
+	mux.HandleFunc("GET /gallery.jpg", server.handleGalleryImage)
+	mux.HandleFunc("GET /gallery.png", server.handleGalleryImage)
+	mux.HandleFunc("GET /gallery.webp", server.handleGalleryImage)
 	mux.HandleFunc("/presets.json", server.handlePresetsJson)
+	mux.HandleFunc("GET /presets.schema.json", server.handlePresetsSchema)
+	mux.HandleFunc("POST /palette-import", server.handlePaletteImport)
+	mux.HandleFunc("POST /api/v1/render", server.handleApiRender)
+	mux.HandleFunc("GET /api/v1/openapi.json", server.handleOpenApi)
+	mux.HandleFunc("GET /api/v1/presets/fractals", server.handleApiListFractalPresets)
+	mux.HandleFunc("POST /api/v1/presets/fractals", server.handleApiCreateFractalPreset)
+	mux.HandleFunc("GET /api/v1/presets/fractals/{name}", server.handleApiGetFractalPreset)
+	mux.HandleFunc("PUT /api/v1/presets/fractals/{name}", server.handleApiPutFractalPreset)
+	mux.HandleFunc("DELETE /api/v1/presets/fractals/{name}", server.handleApiDeleteFractalPreset)
+	mux.HandleFunc("GET /api/v1/presets/colors", server.handleApiListColorPresets)
+	mux.HandleFunc("POST /api/v1/presets/colors", server.handleApiCreateColorPreset)
+	mux.HandleFunc("GET /api/v1/presets/colors/{ident}", server.handleApiGetColorPreset)
+	mux.HandleFunc("PUT /api/v1/presets/colors/{ident}", server.handleApiPutColorPreset)
+	mux.HandleFunc("DELETE /api/v1/presets/colors/{ident}", server.handleApiDeleteColorPreset)
+	if conf.JobsDir != "" {
+		jobs, err := newJobQueue(conf.JobsDir, conf.Workers, server.limits.MaxQueuedJobs, conf.JobRetention, server.runJob)
+		if err != nil {
+			return nil, err
+		}
+		server.jobs = jobs
+		mux.HandleFunc("POST /api/v1/jobs/render", server.handleApiSubmitRenderJob)
+		mux.HandleFunc("POST /api/v1/jobs/flight", server.handleApiSubmitFlightJob)
+		mux.HandleFunc("GET /api/v1/jobs", server.handleApiListJobs)
+		mux.HandleFunc("GET /api/v1/jobs/{id}", server.handleApiGetJob)
+		mux.HandleFunc("DELETE /api/v1/jobs/{id}", server.handleApiDeleteJob)
+		mux.HandleFunc("POST /api/v1/jobs/{id}/cancel", server.handleApiCancelJob)
+		mux.HandleFunc("GET /api/v1/jobs/{id}/results.zip", server.handleApiJobResultsZip)
+		mux.HandleFunc("GET /api/v1/jobs/{id}/results/{name}", server.handleApiJobResult)
+	}
 	mux.Handle("/", http.FileServerFS(conf.WebrootFS))
 
 	listenAddr := conf.Addr
@@ -43,104 +118,110 @@ func NewWebServer(conf WebServerConfig, colorPresets lib.ColorPresets, fractalPr
 		Addr: listenAddr, Handler: handler,
 	}
 
-	return server
+	return server, nil
 }
 
-func (s *WebServer) handleFractalImage(w http.ResponseWriter, r *http.Request) {
-	format := strings.ToLower(r.PathValue("format"))
-	width, _ := strconv.Atoi(r.URL.Query().Get("width"))
-	height, _ := strconv.Atoi(r.URL.Query().Get("height"))
-	maxIterations, _ := strconv.Atoi(r.URL.Query().Get("maxIterations"))
-	iterFunc := strings.ToLower(r.URL.Query().Get("iterFunc"))
-	centerCX, _ := strconv.ParseFloat(r.URL.Query().Get("centerCX"), 64)
-	centerCY, _ := strconv.ParseFloat(r.URL.Query().Get("centerCY"), 64)
-	diameterCX, _ := strconv.ParseFloat(r.URL.Query().Get("diameterCX"), 64)
-	colorPresetParam := r.URL.Query().Get("colorPreset")
-	colorPaletteRepeat, _ := strconv.Atoi(r.URL.Query().Get("colorPaletteRepeat"))
-	colorPaletteLength, _ := strconv.Atoi(r.URL.Query().Get("colorPaletteLength"))
-	colorPaletteReverse, _ := strconv.ParseBool(r.URL.Query().Get("colorPaletteReverse"))
-	colorPaletteHardStops, _ := strconv.ParseBool(r.URL.Query().Get("colorPaletteHardStops"))
+// the current presets of the server: the returned presets are never modified
+func (s *WebServer) currentPresets() lib.Presets {
+	return *s.presets.Load()
+}
 
-	colorPreset, err := s.colorPresets.GetByIdent(colorPresetParam)
+func (s *WebServer) handleFractalImage(w http.ResponseWriter, r *http.Request) {
+	v := newParamValidator(r.URL.Query())
+	out, err := readImageOutput(r, v, r.PathValue("format"))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, "Unknown color preset")
+		writeImageOutputError(w, err)
 		return
 	}
+	v.required("width")
+	v.required("height")
+	width := v.intParam("width", 0, 1, math.MaxInt32)
+	height := v.intParam("height", 0, 1, math.MaxInt32)
+
+	if compositionParam := r.URL.Query().Get("composition"); compositionParam != "" {
+		s.streamCompositionImage(r.Context(), v, compositionParam, width, height, out, w)
+		return
+	}
+
+	iterFunc, commonFractParams, juliaParams := s.readFractalParams(v, width, height)
+	v.checkLimits(s.limits, width, height, commonFractParams.MaxIterations)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	streamFractalImage(iterFunc, commonFractParams, juliaParams, out, w)
+}
+
+// reads the fractal params of the fractal-image query, invalid params are recorded in the validator
+func (s *WebServer) readFractalParams(v *paramValidator, width, height int) (string, lib.CommonFractParams, lib.JuliaFractal) {
+	iterFunc := v.enumParam("iterFunc", lib.FRACTAL_TYPE_MANDELBROT, fractalTypes)
+	colorPreset, err := s.currentPresets().ColorPresets.GetByIdent(v.required("colorPreset"))
+	if err != nil && v.has("colorPreset") {
+		v.fail("colorPreset", "unknown color preset")
+	}
 
 	var commonFractParams = lib.CommonFractParams{
 		ImageWidth:            width,
 		ImageHeight:           height,
-		CenterCX:              centerCX,
-		CenterCY:              centerCY,
-		DiameterCX:            diameterCX,
-		MaxIterations:         maxIterations,
+		CenterCX:              v.floatParam("centerCX", 0),
+		CenterCY:              v.floatParam("centerCY", 0),
+		DiameterCX:            v.positiveFloatParam("diameterCX", 4),
+		MaxIterations:         v.intParam("maxIterations", 100, 1, math.MaxInt32),
 		ColorPalette:          colorPreset.Palette,
-		ColorPaletteRepeat:    colorPaletteRepeat,
-		ColorPaletteLength:    colorPaletteLength,
-		ColorPaletteReverse:   colorPaletteReverse,
-		ColorPaletteHardStops: colorPaletteHardStops,
+		ColorPaletteRepeat:    v.intParam("colorPaletteRepeat", 1, 0, math.MaxInt32),
+		ColorPaletteLength:    v.intParam("colorPaletteLength", -1, -1, math.MaxInt32),
+		ColorPaletteReverse:   v.boolParam("colorPaletteReverse", false),
+		ColorPaletteHardStops: v.boolParam("colorPaletteHardStops", false),
+		ColorPaletteSpace:     colorPreset.Interpolation,
+		ColorPaletteCurve:     colorPreset.Curve,
 	}
-	juliaKr, _ := strconv.ParseFloat(r.URL.Query().Get("juliaKr"), 64)
-	juliaKi, _ := strconv.ParseFloat(r.URL.Query().Get("juliaKi"), 64)
+	s.applyColoringParams(v, &commonFractParams)
 	juliaParams := lib.JuliaFractal{
-		JuliaKr: juliaKr,
-		JuliaKi: juliaKi,
+		JuliaKr: v.floatParam("juliaKr", 0),
+		JuliaKi: v.floatParam("juliaKi", 0),
 	}
-	streamFractalImage(iterFunc, commonFractParams, juliaParams, format, w)
+	return iterFunc, commonFractParams, juliaParams
 }
 
 func (s *WebServer) handleWmtsRequest(w http.ResponseWriter, r *http.Request) {
-	zoomLevel, _ := strconv.Atoi(r.URL.Query().Get("TileMatrix"))
-	if zoomLevel < 0 || zoomLevel > 50 {
-		zoomLevel = 0
+	v := newParamValidator(r.URL.Query())
+	// the WMTS Format param is a mime type, e.g. "image/png":
+	jpegFormat, _ := lib.GetImageFormat("jpeg")
+	format, ok := lib.NegotiateImageFormat(r.URL.Query().Get("Format"), jpegFormat)
+	if !ok {
+		v.fail("Format", "unsupported image format")
 	}
+	out, err := readImageOutput(r, v, format.Name)
+	if err != nil {
+		writeImageOutputError(w, err)
+		return
+	}
+	// the tiles are only displayed, the render params would just enlarge them:
+	out.opts.OmitMetadata = true
 
-	iterFunc := strings.ToLower(r.URL.Query().Get("iterFunc"))
-
-	tileX, _ := strconv.Atoi(r.URL.Query().Get("TileCol"))
-	tileY, _ := strconv.Atoi(r.URL.Query().Get("TileRow"))
+	// the zoom level is given by the tile width, TileMatrix is only validated:
+	v.intParam("TileMatrix", 0, 0, 50)
+	tileX := v.intParam("TileCol", 0, math.MinInt32, math.MaxInt32)
+	tileY := v.intParam("TileRow", 0, math.MinInt32, math.MaxInt32)
 
 	originX := -1.7
 	originY := -1.0
 
-	tileWidthPixels, _ := strconv.Atoi(r.URL.Query().Get("tileWidthPixels"))
-	if tileWidthPixels == 0 {
-		tileWidthPixels = 256
-	}
-	tileWidthFractal, _ := strconv.ParseFloat(r.URL.Query().Get("tileWidthFractal"), 64)
-	if tileWidthFractal == 0 {
-		tileWidthFractal = 1
-	}
+	tileWidthPixels := v.intParam("tileWidthPixels", 256, 1, math.MaxInt32)
+	tileWidthFractal := v.positiveFloatParam("tileWidthFractal", 1)
 
 	centerCX := originX + float64(tileX)*tileWidthFractal + (tileWidthFractal / 2)
 	centerCY := originY + float64(-1*tileY)*tileWidthFractal - (tileWidthFractal / 2)
 
-	maxIterations, _ := strconv.Atoi(r.URL.Query().Get("maxIterations"))
-	if maxIterations == 0 {
-		maxIterations = 50
-	}
+	iterFunc := v.enumParam("iterFunc", lib.FRACTAL_TYPE_MANDELBROT, fractalTypes)
+	maxIterations := v.intParam("maxIterations", 50, 1, math.MaxInt32)
 	colorPresetParam := r.URL.Query().Get("colorPreset")
 	if colorPresetParam == "" {
 		colorPresetParam = "Patchwork"
 	}
-	colorPaletteRepeat, _ := strconv.Atoi(r.URL.Query().Get("colorPaletteRepeat"))
-	if colorPaletteRepeat == 0 {
-		colorPaletteRepeat = 1
-	}
-	colorPaletteLength, _ := strconv.Atoi(r.URL.Query().Get("colorPaletteLength"))
-	if colorPaletteLength == 0 {
-		colorPaletteLength = -1
-	}
-
-	colorPaletteReverse, _ := strconv.ParseBool(r.URL.Query().Get("colorPaletteReverse"))
-	colorPaletteHardStops, _ := strconv.ParseBool(r.URL.Query().Get("colorPaletteHardStops"))
-
-	colorPreset, err := s.colorPresets.GetByIdent(colorPresetParam)
+	colorPreset, err := s.currentPresets().ColorPresets.GetByIdent(colorPresetParam)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, "Unknown color preset")
-		return
+		v.fail("colorPreset", "unknown color preset")
 	}
 
 	var commonFractParams = lib.CommonFractParams{
@@ -151,22 +232,31 @@ func (s *WebServer) handleWmtsRequest(w http.ResponseWriter, r *http.Request) {
 		DiameterCX:            tileWidthFractal,
 		MaxIterations:         maxIterations,
 		ColorPalette:          colorPreset.Palette,
-		ColorPaletteRepeat:    colorPaletteRepeat,
-		ColorPaletteLength:    colorPaletteLength,
-		ColorPaletteReverse:   colorPaletteReverse,
-		ColorPaletteHardStops: colorPaletteHardStops,
+		ColorPaletteRepeat:    v.intParam("colorPaletteRepeat", 1, 0, math.MaxInt32),
+		ColorPaletteLength:    v.intParam("colorPaletteLength", -1, -1, math.MaxInt32),
+		ColorPaletteReverse:   v.boolParam("colorPaletteReverse", false),
+		ColorPaletteHardStops: v.boolParam("colorPaletteHardStops", false),
+		ColorPaletteSpace:     colorPreset.Interpolation,
+		ColorPaletteCurve:     colorPreset.Curve,
 	}
-	juliaKr, _ := strconv.ParseFloat(r.URL.Query().Get("juliaKr"), 64)
-	juliaKi, _ := strconv.ParseFloat(r.URL.Query().Get("juliaKi"), 64)
+	s.applyColoringParams(v, &commonFractParams)
+	// the histogram and rank mappings depend on all pixels of the image: adjacent tiles would not match
+	mapping := commonFractParams.ColorPaletteMapping
+	v.check(mapping != lib.PALETTE_MAPPING_HISTOGRAM && mapping != lib.PALETTE_MAPPING_RANK, "colorPaletteMapping", "histogram and rank are not supported for tiles")
 	juliaParams := lib.JuliaFractal{
-		JuliaKr: juliaKr,
-		JuliaKi: juliaKi,
+		JuliaKr: v.floatParam("juliaKr", 0),
+		JuliaKi: v.floatParam("juliaKi", 0),
 	}
-	streamFractalImage(iterFunc, commonFractParams, juliaParams, "jpg", w)
+	v.checkLimits(s.limits, tileWidthPixels, tileWidthPixels, maxIterations)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	streamFractalImage(iterFunc, commonFractParams, juliaParams, out, w)
 }
 
 // calculates a fractal image and streams it to the given response writer
-func streamFractalImage(iterFunc string, commonFractParams lib.CommonFractParams, additionalFractParams any, format string, w http.ResponseWriter) {
+func streamFractalImage(iterFunc string, commonFractParams lib.CommonFractParams, additionalFractParams any, out imageOutput, w http.ResponseWriter) {
 	var fractal lib.Fractal
 
 	switch iterFunc {
@@ -184,37 +274,76 @@ func streamFractalImage(iterFunc string, commonFractParams lib.CommonFractParams
 		fractal = lib.NewJuliaFractal(commonFractParams, juliaParams.JuliaKr, juliaParams.JuliaKi)
 		break
 	default:
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ValidationError{{Field: "iterFunc", Message: "unknown fractal function"}})
 		return
 	}
 
 	img := lib.CalcFractalImage(fractal)
-	w.Header().Set("Cache-Control", "public, max-age=15552000;")
-	switch format {
-	case "png":
-		w.Header().Set("Content-Type", "image/jpeg")
-		img.EncodePng(w)
-		break
-	case "jpg":
-		fallthrough
-	case "jpeg":
-		w.Header().Set("Content-Type", "image/jpeg")
-		img.EncodeJpeg(w)
-		break
+	encodeImage(img, out, w)
+}
+
+// renders a composition preset: all other fractal params are taken from the layers' fractal presets
+func (s *WebServer) streamCompositionImage(ctx context.Context, v *paramValidator, name string, width, height int, out imageOutput, w http.ResponseWriter) {
+	presets := s.currentPresets()
+	composition, err := presets.CompositionPresets.GetByName(name)
+	if err != nil {
+		v.fail("composition", "unknown composition preset")
+	}
+	// unknown presets of the layers are reported when rendering:
+	maxIterations := 0
+	for _, layer := range composition.Layers {
+		fractalPreset, _ := presets.FractalPresets.GetByName(layer.FractalPreset)
+		maxIterations = max(maxIterations, fractalPreset.MaxIterations)
+		if layer.Mask != nil {
+			maskPreset, _ := presets.FractalPresets.GetByName(layer.Mask.FractalPreset)
+			maxIterations = max(maxIterations, maskPreset.MaxIterations)
+		}
+	}
+	v.checkLimits(s.limits, width, height, maxIterations)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
 	}
+	img, err := lib.CalcCompositionImageContext(ctx, width, height, presets, composition, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			// the client is gone
+			return
+		}
+		// the composition refers to invalid presets of the server:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	encodeImage(img, out, w)
 }
 
+// the presets of /presets.json, with the origin of each preset
+type presetsResponse struct {
+	ColorPresets       []json.RawMessage `json:"colorPresets"`
+	FractalPresets     []json.RawMessage `json:"fractalPresets"`
+	CompositionPresets []json.RawMessage `json:"compositionPresets,omitempty"`
+}
+
+// Returns the presets. Each preset has an additional "origin": the presets file it was read from, or "embedded".
 func (s *WebServer) handlePresetsJson(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	presets := lib.Presets{
-		ColorPresets:   s.colorPresets,
-		FractalPresets: s.fractalPresets,
+	presets := s.currentPresets()
+	var response presetsResponse
+	var err error
+	response.ColorPresets, err = presetsWithOrigin(presets.ColorPresets, presets.Origins.ColorPresets, func(p lib.ColorPreset) string { return p.Ident })
+	if err == nil {
+		response.FractalPresets, err = presetsWithOrigin(presets.FractalPresets, presets.Origins.FractalPresets, func(p lib.FractalPreset) string { return p.Name })
+	}
+	if err == nil {
+		response.CompositionPresets, err = presetsWithOrigin(presets.CompositionPresets, presets.Origins.CompositionPresets, func(p lib.CompositionPreset) string { return p.Name })
+	}
+	var jsonStream []byte
+	if err == nil {
+		jsonStream, err = json.Marshal(response)
 	}
-
-	jsonStream, err := json.Marshal(presets)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -222,18 +351,63 @@ func (s *WebServer) handlePresetsJson(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonStream)
 }
 
+// encodes the presets as JSON objects with an additional "origin" property
+func presetsWithOrigin[T any](presets []T, origins map[string]lib.PresetOrigin, key func(T) string) ([]json.RawMessage, error) {
+	result := make([]json.RawMessage, 0, len(presets))
+	for _, preset := range presets {
+		jsonData, err := json.Marshal(preset)
+		if err != nil {
+			return nil, err
+		}
+		var properties map[string]json.RawMessage
+		if err := json.Unmarshal(jsonData, &properties); err != nil {
+			return nil, err
+		}
+		origin := lib.PRESETS_ORIGIN_EMBEDDED
+		if o, ok := origins[strings.ToLower(key(preset))]; ok {
+			origin = o.File
+		}
+		properties["origin"], _ = json.Marshal(origin)
+		jsonData, err = json.Marshal(properties)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, jsonData)
+	}
+	return result, nil
+}
+
+// Serves the JSON Schema of the presets files.
+func (s *WebServer) handlePresetsSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(lib.PresetsSchema)
+}
+
 func (s *WebServer) handlePaletteViewer(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Cache-Control", "public, max-age=15552000;")
-	w.WriteHeader(http.StatusOK)
-	width, _ := strconv.Atoi(r.URL.Query().Get("width"))
-	if width <= 0 {
-		width = 1024
+	colorPreset, _ := s.currentPresets().ColorPresets.GetByIdent(r.URL.Query().Get("colorPreset"))
+	// preview a generated palette instead of a preset:
+	if r.URL.Query().Get("generator") != "" {
+		opts, err := paletteGenerateOptions(r.URL.Query())
+		if err == nil {
+			colorPreset.Palette, err = lib.GeneratePalette(opts)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 	}
-	height, _ := strconv.Atoi(r.URL.Query().Get("height"))
-	if height <= 0 {
-		height = 100
+
+	v := newParamValidator(r.URL.Query())
+	width := v.intParam("width", 1024, 1, math.MaxInt32)
+	height := v.intParam("height", 100, 1, math.MaxInt32)
+	v.checkLimits(s.limits, width, height, 0)
+	if err := v.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
 	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", imageCacheControl)
+	w.WriteHeader(http.StatusOK)
 	maxIter, _ := strconv.Atoi(r.URL.Query().Get("maxIterations"))
 	if maxIter == 0 {
 		maxIter = width
@@ -260,9 +434,15 @@ func (s *WebServer) handlePaletteViewer(w http.ResponseWriter, r *http.Request)
 		break
 	}
 
-	colorPreset, _ := s.colorPresets.GetByIdent(r.URL.Query().Get("colorPreset"))
+	// the preset's interpolation can be overridden, to preview other color spaces / curves:
+	if interpolation := r.URL.Query().Get("interpolation"); interpolation != "" {
+		colorPreset.Interpolation = lib.ColorSpace(strings.ToLower(interpolation))
+	}
+	if curve := r.URL.Query().Get("curve"); curve != "" {
+		colorPreset.Curve = lib.InterpolationCurve(strings.ToLower(curve))
+	}
 
-	img := lib.FractImage{image.NewRGBA(image.Rect(0, 0, width, height))}
+	img := lib.NewFractImage(width, height)
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
 			fractParams := lib.CommonFractParams{
@@ -270,6 +450,8 @@ func (s *WebServer) handlePaletteViewer(w http.ResponseWriter, r *http.Request)
 				ColorPalette:       colorPreset.Palette,
 				ColorPaletteRepeat: colorPaletteRepeat,
 				ColorPaletteLength: colorPaletteLength,
+				ColorPaletteSpace:  colorPreset.Interpolation,
+				ColorPaletteCurve:  colorPreset.Curve,
 			}
 			var iterValue float64
 			switch dir {
@@ -281,7 +463,7 @@ func (s *WebServer) handlePaletteViewer(w http.ResponseWriter, r *http.Request)
 				break
 			}
 			result := lib.FractFunctionResult{}
-			lib.SetPaletteColor(&img, x, y, iterValue, fractParams, result)
+			lib.SetPaletteColor(img, x, y, iterValue, fractParams, result)
 		}
 	}
 	img.EncodeJpeg(w)
@@ -0,0 +1,235 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bylexus/go-fract/lib"
+)
+
+// Limits of the server, to protect it against requests too expensive to render.
+type Limits struct {
+	// max. number of pixels (width * height) of an image
+	MaxPixels int
+	// max. number of iterations of a fractal
+	MaxIterations int
+	// max. number of frames of a flight job
+	MaxFrames int
+	// max. number of queued (not yet running) jobs, 0 = unlimited
+	MaxQueuedJobs int
+}
+
+var DefaultLimits = Limits{
+	MaxPixels:     7680 * 4320,
+	MaxIterations: 100000,
+	MaxFrames:     3000,
+	MaxQueuedJobs: 100,
+}
+
+// the allowed values of the enum query params
+var (
+	fractalTypes       = []string{lib.FRACTAL_TYPE_MANDELBROT, lib.FRACTAL_TYPE_MANDELBROT3, lib.FRACTAL_TYPE_MANDELBROT4, lib.FRACTAL_TYPE_JULIA}
+	coloringAlgorithms = []string{
+		lib.COLORING_ALGORITHM_ESCAPE_TIME, lib.COLORING_ALGORITHM_SMOOTH, lib.COLORING_ALGORITHM_TRIANGLE_INEQUALITY,
+		lib.COLORING_ALGORITHM_CURVATURE, lib.COLORING_ALGORITHM_STRIPE, lib.COLORING_ALGORITHM_BINARY_DECOMPOSITION,
+		lib.COLORING_ALGORITHM_FIELD_LINES, lib.COLORING_ALGORITHM_FINAL_ANGLE,
+	}
+	blendModes      = []string{lib.BLEND_MODE_MIX, lib.BLEND_MODE_ADD, lib.BLEND_MODE_MULTIPLY, lib.BLEND_MODE_SCREEN, lib.BLEND_MODE_DIFFERENCE}
+	paletteMappings = []string{
+		lib.PALETTE_MAPPING_LINEAR, lib.PALETTE_MAPPING_LOG, lib.PALETTE_MAPPING_SQRT, lib.PALETTE_MAPPING_POWER,
+		lib.PALETTE_MAPPING_HISTOGRAM, lib.PALETTE_MAPPING_RANK,
+	}
+	interiorModes = []string{
+		lib.INTERIOR_MODE_BLACK, lib.INTERIOR_MODE_PERIOD, lib.INTERIOR_MODE_FINAL_ABS, lib.INTERIOR_MODE_ANGLE,
+		lib.INTERIOR_MODE_MULTIPLIER, lib.INTERIOR_MODE_ATOM_DOMAIN,
+	}
+	distanceModes = []string{lib.DISTANCE_MODE_OFF, lib.DISTANCE_MODE_OUTLINE, lib.DISTANCE_MODE_COLOR, lib.DISTANCE_MODE_SHADING}
+	ditherModes   = []string{lib.DITHER_MODE_NONE, lib.DITHER_MODE_ORDERED, lib.DITHER_MODE_BLUE_NOISE}
+	colorSpaces   = []string{
+		lib.COLOR_SPACE_RGB, lib.COLOR_SPACE_LINEAR_RGB, lib.COLOR_SPACE_HSL, lib.COLOR_SPACE_HSV, lib.COLOR_SPACE_LAB,
+		lib.COLOR_SPACE_OKLAB, lib.COLOR_SPACE_OKLCH,
+	}
+	interpolationCurves = []string{lib.INTERPOLATION_CURVE_LINEAR, lib.INTERPOLATION_CURVE_SMOOTHSTEP, lib.INTERPOLATION_CURVE_SPLINE}
+
+	chromaSubsamplings = []string{lib.CHROMA_SUBSAMPLING_444, lib.CHROMA_SUBSAMPLING_422, lib.CHROMA_SUBSAMPLING_420}
+	pngCompressions    = []string{lib.PNG_COMPRESSION_DEFAULT, lib.PNG_COMPRESSION_NONE, lib.PNG_COMPRESSION_FAST, lib.PNG_COMPRESSION_BEST}
+)
+
+// An invalid request param
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// All invalid params of a request
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, f := range e {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// The JSON body of error responses
+type ErrorResponse struct {
+	Status int          `json:"status"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+/*
+Writes the error as JSON error response. Validation errors list the invalid fields:
+
+	{"status": 400, "error": "invalid request params", "fields": [{"field": "width", "message": "must be an integer"}]}
+*/
+func writeError(w http.ResponseWriter, status int, err error) {
+	body := ErrorResponse{Status: status, Error: err.Error()}
+	var validationErr ValidationError
+	if errors.As(err, &validationErr) {
+		body.Error = "invalid request params"
+		body.Fields = validationErr
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+/*
+Reads and validates query params: each read param is parsed, and an invalid value is recorded as field error
+instead of being ignored. Missing params return the given default value. After reading all params, Err() returns
+all invalid params at once.
+*/
+type paramValidator struct {
+	query  url.Values
+	errors ValidationError
+}
+
+func newParamValidator(query url.Values) *paramValidator {
+	return &paramValidator{query: query}
+}
+
+func (v *paramValidator) fail(field, format string, args ...any) {
+	// only the first error of a field:
+	if slices.ContainsFunc(v.errors, func(f FieldError) bool { return f.Field == field }) {
+		return
+	}
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// records an error for the field if the condition is false
+func (v *paramValidator) check(ok bool, field, format string, args ...any) {
+	if !ok {
+		v.fail(field, format, args...)
+	}
+}
+
+func (v *paramValidator) has(name string) bool {
+	return v.query.Get(name) != ""
+}
+
+func (v *paramValidator) required(name string) string {
+	value := v.query.Get(name)
+	if value == "" {
+		v.fail(name, "is required")
+	}
+	return value
+}
+
+// an integer in the range [min, max]
+func (v *paramValidator) intParam(name string, def, min, max int) int {
+	if !v.has(name) {
+		return def
+	}
+	value, err := strconv.Atoi(v.query.Get(name))
+	if err != nil {
+		v.fail(name, "must be an integer")
+		return def
+	}
+	if max == math.MaxInt32 {
+		v.check(value >= min, name, "must be at least %d", min)
+	} else {
+		v.check(value >= min && value <= max, name, "must be between %d and %d", min, max)
+	}
+	return value
+}
+
+// a finite number
+func (v *paramValidator) floatParam(name string, def float64) float64 {
+	if !v.has(name) {
+		return def
+	}
+	value, err := strconv.ParseFloat(v.query.Get(name), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		v.fail(name, "must be a number")
+		return def
+	}
+	return value
+}
+
+// a finite number greater than 0
+func (v *paramValidator) positiveFloatParam(name string, def float64) float64 {
+	value := v.floatParam(name, def)
+	v.check(value > 0, name, "must be greater than 0")
+	return value
+}
+
+func (v *paramValidator) boolParam(name string, def bool) bool {
+	if !v.has(name) {
+		return def
+	}
+	value, err := strconv.ParseBool(v.query.Get(name))
+	if err != nil {
+		v.fail(name, "must be true or false")
+		return def
+	}
+	return value
+}
+
+// one of the given values, case-insensitive. Returns the lower case value.
+func (v *paramValidator) enumParam(name, def string, values []string) string {
+	if !v.has(name) {
+		return def
+	}
+	return v.checkEnum(name, v.query.Get(name), values)
+}
+
+// checks that the value is empty or one of the given values, case-insensitive. Returns the lower case value.
+func (v *paramValidator) checkEnum(field, value string, values []string) string {
+	value = strings.ToLower(value)
+	v.check(value == "" || slices.Contains(values, value), field, "must be one of %s", strings.Join(values, ", "))
+	return value
+}
+
+// checks the image size and number of iterations against the server's limits
+func (v *paramValidator) checkLimits(limits Limits, width, height, maxIterations int) {
+	v.check(width >= 1, "width", "must be at least 1")
+	v.check(height >= 1, "height", "must be at least 1")
+	if width >= 1 && height >= 1 && width > limits.MaxPixels/height {
+		v.fail("width", "the image is too large: %d x %d pixels exceed the limit of %d pixels", width, height, limits.MaxPixels)
+	}
+	v.checkIterations(limits, "maxIterations", maxIterations)
+}
+
+// checks the number of iterations against the server's limit
+func (v *paramValidator) checkIterations(limits Limits, field string, maxIterations int) {
+	if maxIterations > limits.MaxIterations {
+		v.fail(field, "must not exceed %d", limits.MaxIterations)
+	}
+}
+
+func (v *paramValidator) Err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
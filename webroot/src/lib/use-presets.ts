@@ -11,6 +11,8 @@ export type ColorPreset = {
     A: number
     steps?: number
   }>
+  // the presets file the preset was read from, or 'embedded'
+  origin?: string
 }
 
 export type FractalPreset = {
@@ -28,6 +30,7 @@ export type FractalPreset = {
   colorPaletteHardStops: boolean
   juliaKr: number
   juliaKi: number
+  origin?: string
 }
 
 export type FractalParams = FractalPreset & { width?: number; height?: number }